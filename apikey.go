@@ -0,0 +1,69 @@
+package main
+
+import (
+	"config"
+	"context"
+	"fmt"
+	"services"
+	"strconv"
+	"strings"
+)
+
+/*
+runAPIKey implements "apikey create <name> <user_id> [scope...]" and "apikey
+revoke <key_id>", followed by the usual config flags. It is how the first
+admin key is made, user_id 0 makes a key acting as a service:
+
+	wallet-service apikey create ops 0 admin
+*/
+func runAPIKey(args []string) error {
+	usage := fmt.Errorf("usage: apikey create <name> <user_id> [scope...] | apikey revoke <key_id> [config flags]")
+	//the positional arguments end where the config flags start
+	n := 0
+	for n < len(args) && !strings.HasPrefix(args[n], "-") {
+		n++
+	}
+	positional, flags := args[:n], args[n:]
+	if len(positional) < 2 {
+		return usage
+	}
+
+	cfg, err := config.Load(flags)
+	if err != nil {
+		return err
+	}
+	db, err := config.InitDB(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	service := &services.UserService{DB: db}
+
+	switch positional[0] {
+	case "create":
+		if len(positional) < 3 {
+			return usage
+		}
+		userID, err := strconv.Atoi(positional[2])
+		if err != nil {
+			return usage
+		}
+		key, secret, err := service.CreateAPIKey(context.Background(), userID, positional[1], positional[3:])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created api key %d (%s), the secret is not shown again:\n%s\n", key.ID, key.Prefix, secret)
+	case "revoke":
+		keyID, err := strconv.Atoi(positional[1])
+		if err != nil {
+			return usage
+		}
+		if _, err := service.RevokeAPIKey(context.Background(), keyID); err != nil {
+			return err
+		}
+		fmt.Printf("revoked api key %d\n", keyID)
+	default:
+		return usage
+	}
+	return nil
+}
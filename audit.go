@@ -0,0 +1,68 @@
+package main
+
+import (
+	"config"
+	"context"
+	"fmt"
+	"services"
+	"strconv"
+)
+
+/*
+runAudit implements "audit verify", which exits non-zero when the hash chain
+of the audit log is broken, and "audit tail [n]", which prints the newest n
+events, followed by the usual config flags.
+*/
+func runAudit(args []string) error {
+	usage := fmt.Errorf("usage: audit verify | audit tail [n] [config flags]")
+	if len(args) == 0 {
+		return usage
+	}
+	action, args := args[0], args[1:]
+
+	n := 20
+	if action == "tail" && len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
+			n, args = v, args[1:]
+		}
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+	db, err := config.InitDB(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	service := &services.WalletService{DB: db}
+
+	switch action {
+	case "verify":
+		report, err := service.VerifyAuditLog(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, p := range report.Broken {
+			fmt.Printf("event %d %s\n", p.EventID, p.Problem)
+		}
+		if !report.OK() {
+			return fmt.Errorf("audit log tampered with")
+		}
+		fmt.Printf("%d events verified, chain head %s\n", report.Events, report.LastHash)
+	case "tail":
+		events, err := service.AuditEvents(context.Background(), n)
+		if err != nil {
+			return err
+		}
+		for i := len(events) - 1; i >= 0; i-- {
+			e := events[i]
+			fmt.Printf("%d %s %-16s actor=%s ip=%s request=%s balances=%s details=%s\n",
+				e.ID, e.OccurredAt.Format("2006-01-02 15:04:05.000000"), e.Operation, e.Actor, e.IP, e.RequestID, e.Balances, e.Details)
+		}
+	default:
+		return usage
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the wallet service needs at startup.
+type Config struct {
+	DB     DBConfig     `yaml:"db" toml:"db"`
+	Server ServerConfig `yaml:"server" toml:"server"`
+	FX     FXConfig     `yaml:"fx" toml:"fx"`
+	Fees   FeesConfig   `yaml:"fees" toml:"fees"`
+	Auth   AuthConfig   `yaml:"auth" toml:"auth"`
+	// RateLimits are the limits of each route group: transfers, writes and reads
+	RateLimits map[string]RouteLimitsConfig `yaml:"rate_limits" toml:"rate_limits"`
+}
+
+// DBConfig describes how to reach postgres and how big the pool may grow.
+type DBConfig struct {
+	DSN             string   `yaml:"dsn" toml:"dsn"`
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnectTimeout  Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+}
+
+// ServerConfig describes the http listener.
+type ServerConfig struct {
+	Addr            string   `yaml:"addr" toml:"addr"`
+	ReadTimeout     Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout    Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout     Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// TrustedProxies are the ips and cidrs of the proxies whose
+	// X-Forwarded-For is believed, the client ip of everyone else is the
+	// address the request came from
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// FXConfig describes where exchange rates come from and how long a quote holds.
+type FXConfig struct {
+	RatesFile string   `yaml:"rates_file" toml:"rates_file"`
+	QuoteTTL  Duration `yaml:"quote_ttl" toml:"quote_ttl"`
+}
+
+// FeesConfig points at the fee schedule, withdrawals and transfers are free without one.
+type FeesConfig struct {
+	ScheduleFile string `yaml:"schedule_file" toml:"schedule_file"`
+}
+
+// AuthConfig says which JWTs are accepted, API keys work without any of it.
+// The HS256 secret is best given as WALLET_JWT_SECRET rather than in a file.
+type AuthConfig struct {
+	JWTSecret        string `yaml:"jwt_secret" toml:"jwt_secret"`
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file" toml:"jwt_public_key_file"`
+	JWTIssuer        string `yaml:"jwt_issuer" toml:"jwt_issuer"`
+	JWTAudience      string `yaml:"jwt_audience" toml:"jwt_audience"`
+	// SigningKeysFile holds the HMAC keys requests can be signed with,
+	// signatures are not checked without one
+	SigningKeysFile string `yaml:"signing_keys_file" toml:"signing_keys_file"`
+	// RequireSignatures refuses unsigned requests made with an API key
+	RequireSignatures bool     `yaml:"require_signatures" toml:"require_signatures"`
+	SignatureSkew     Duration `yaml:"signature_skew" toml:"signature_skew"`
+}
+
+// RateLimitConfig is a token bucket of Requests every Per, in bursts of up to
+// Burst which defaults to Requests. Zero Requests turn the bucket off.
+type RateLimitConfig struct {
+	Requests int      `yaml:"requests" toml:"requests"`
+	Per      Duration `yaml:"per" toml:"per"`
+	Burst    int      `yaml:"burst" toml:"burst"`
+}
+
+// RouteLimitsConfig are the buckets of a route group, one for each caller,
+// client IP and wallet. A group given in the config file replaces its
+// defaults as a whole.
+type RouteLimitsConfig struct {
+	Principal RateLimitConfig `yaml:"principal" toml:"principal"`
+	IP        RateLimitConfig `yaml:"ip" toml:"ip"`
+	Wallet    RateLimitConfig `yaml:"wallet" toml:"wallet"`
+}
+
+// RateLimitGroups are the route groups rate limits can be configured for.
+var RateLimitGroups = []string{"transfers", "writes", "reads"}
+
+// minJWTSecret is the shortest HS256 secret accepted, as long as the hash itself
+const minJWTSecret = 32
+
+// Duration wraps time.Duration so it can be written as "30s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Default returns the configuration used when nothing else is specified.
+// The DSN carries no credentials, lib/pq falls back to PGUSER/PGPASSWORD.
+func Default() Config {
+	return Config{
+		DB: DBConfig{
+			DSN:             "host=localhost dbname=postgres sslmode=disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: Duration{5 * time.Minute},
+			ConnectTimeout:  Duration{5 * time.Second},
+		},
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     Duration{10 * time.Second},
+			WriteTimeout:    Duration{30 * time.Second},
+			IdleTimeout:     Duration{60 * time.Second},
+			ShutdownTimeout: Duration{30 * time.Second},
+		},
+		FX: FXConfig{
+			QuoteTTL: Duration{30 * time.Second},
+		},
+		Auth: AuthConfig{
+			SignatureSkew: Duration{5 * time.Minute},
+		},
+		//generous for people, tight enough that one runaway client can not
+		//hold every db connection with transfers on the same wallets
+		RateLimits: map[string]RouteLimitsConfig{
+			"transfers": {
+				Principal: RateLimitConfig{Requests: 10, Per: Duration{time.Second}, Burst: 20},
+				IP:        RateLimitConfig{Requests: 20, Per: Duration{time.Second}, Burst: 40},
+				Wallet:    RateLimitConfig{Requests: 5, Per: Duration{time.Second}, Burst: 10},
+			},
+			"writes": {
+				Principal: RateLimitConfig{Requests: 10, Per: Duration{time.Second}, Burst: 20},
+				IP:        RateLimitConfig{Requests: 20, Per: Duration{time.Second}, Burst: 40},
+			},
+			"reads": {
+				Principal: RateLimitConfig{Requests: 50, Per: Duration{time.Second}, Burst: 100},
+				IP:        RateLimitConfig{Requests: 100, Per: Duration{time.Second}, Burst: 200},
+			},
+		},
+	}
+}
+
+/*
+Load builds the configuration in increasing order of precedence:
+defaults, then the config file (-config flag or WALLET_CONFIG), then
+WALLET_* environment variables, then command line flags that were set
+explicitly. args are the command line arguments without the program name.
+*/
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("wallet-service", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("WALLET_CONFIG"), "path to a yaml or toml config file")
+	dsn := fs.String("db-dsn", "", "postgres connection string")
+	maxOpen := fs.Int("db-max-open-conns", 0, "maximum number of open db connections")
+	maxIdle := fs.Int("db-max-idle-conns", 0, "maximum number of idle db connections")
+	connLifetime := fs.Duration("db-conn-max-lifetime", 0, "maximum lifetime of a db connection")
+	connectTimeout := fs.Duration("db-connect-timeout", 0, "timeout for the initial db ping")
+	addr := fs.String("addr", "", "address the http server listens on")
+	readTimeout := fs.Duration("read-timeout", 0, "http read timeout")
+	writeTimeout := fs.Duration("write-timeout", 0, "http write timeout")
+	idleTimeout := fs.Duration("idle-timeout", 0, "http keep-alive idle timeout")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long to wait for in-flight requests on shutdown")
+	trustedProxies := fs.String("trusted-proxies", "", "comma separated ips and cidrs of the proxies X-Forwarded-For is believed from")
+	fxRatesFile := fs.String("fx-rates-file", "", "path to a json file of exchange rates")
+	fxQuoteTTL := fs.Duration("fx-quote-ttl", 0, "how long an exchange rate quote is honoured")
+	feeScheduleFile := fs.String("fee-schedule-file", "", "path to a json file of fee rules")
+	jwtPublicKeyFile := fs.String("jwt-public-key-file", "", "path to the PEM RSA public key RS256 tokens are verified with")
+	jwtIssuer := fs.String("jwt-issuer", "", "iss claim JWTs must carry")
+	jwtAudience := fs.String("jwt-audience", "", "aud claim JWTs must carry")
+	signingKeysFile := fs.String("signing-keys-file", "", "path to a json file of HMAC request signing keys")
+	requireSignatures := fs.Bool("require-signatures", false, "refuse unsigned requests made with an api key")
+	signatureSkew := fs.Duration("signature-skew", 0, "how far the timestamp of a signed request may be off")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	//only flags given on the command line override file and env values
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db-dsn":
+			cfg.DB.DSN = *dsn
+		case "db-max-open-conns":
+			cfg.DB.MaxOpenConns = *maxOpen
+		case "db-max-idle-conns":
+			cfg.DB.MaxIdleConns = *maxIdle
+		case "db-conn-max-lifetime":
+			cfg.DB.ConnMaxLifetime.Duration = *connLifetime
+		case "db-connect-timeout":
+			cfg.DB.ConnectTimeout.Duration = *connectTimeout
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "read-timeout":
+			cfg.Server.ReadTimeout.Duration = *readTimeout
+		case "write-timeout":
+			cfg.Server.WriteTimeout.Duration = *writeTimeout
+		case "idle-timeout":
+			cfg.Server.IdleTimeout.Duration = *idleTimeout
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout.Duration = *shutdownTimeout
+		case "trusted-proxies":
+			cfg.Server.TrustedProxies = splitList(*trustedProxies)
+		case "fx-rates-file":
+			cfg.FX.RatesFile = *fxRatesFile
+		case "fx-quote-ttl":
+			cfg.FX.QuoteTTL.Duration = *fxQuoteTTL
+		case "fee-schedule-file":
+			cfg.Fees.ScheduleFile = *feeScheduleFile
+		case "jwt-public-key-file":
+			cfg.Auth.JWTPublicKeyFile = *jwtPublicKeyFile
+		case "jwt-issuer":
+			cfg.Auth.JWTIssuer = *jwtIssuer
+		case "jwt-audience":
+			cfg.Auth.JWTAudience = *jwtAudience
+		case "signing-keys-file":
+			cfg.Auth.SigningKeysFile = *signingKeysFile
+		case "require-signatures":
+			cfg.Auth.RequireSignatures = *requireSignatures
+		case "signature-skew":
+			cfg.Auth.SignatureSkew.Duration = *signatureSkew
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+// Validate reports settings that can never work.
+func (c Config) Validate() error {
+	if c.DB.DSN == "" {
+		return fmt.Errorf("config: db dsn must not be empty")
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MaxIdleConns < 0 {
+		return fmt.Errorf("config: db pool sizes must not be negative")
+	}
+	if c.Server.Addr == "" {
+		return fmt.Errorf("config: server addr must not be empty")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("config: trusted proxy %q is neither an ip nor a cidr", proxy)
+			}
+		}
+	}
+	if c.FX.QuoteTTL.Duration <= 0 {
+		return fmt.Errorf("config: fx quote ttl must be positive")
+	}
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < minJWTSecret {
+		return fmt.Errorf("config: jwt secret must be at least %d bytes", minJWTSecret)
+	}
+	if c.Auth.RequireSignatures && c.Auth.SigningKeysFile == "" {
+		return fmt.Errorf("config: require signatures needs a signing keys file")
+	}
+	if c.Auth.SignatureSkew.Duration <= 0 {
+		return fmt.Errorf("config: signature skew must be positive")
+	}
+	for group, limits := range c.RateLimits {
+		if !isRateLimitGroup(group) {
+			return fmt.Errorf("config: unknown rate limit group %q, groups are %s", group, strings.Join(RateLimitGroups, ", "))
+		}
+		for key, limit := range map[string]RateLimitConfig{"principal": limits.Principal, "ip": limits.IP, "wallet": limits.Wallet} {
+			if limit.Requests < 0 || limit.Burst < 0 {
+				return fmt.Errorf("config: rate limit %s.%s must not be negative", group, key)
+			}
+			if limit.Requests > 0 && limit.Per.Duration <= 0 {
+				return fmt.Errorf("config: rate limit %s.%s needs a positive per", group, key)
+			}
+		}
+	}
+	return nil
+}
+
+func isRateLimitGroup(group string) bool {
+	for _, g := range RateLimitGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated flag or env value, an empty one is an
+// empty list
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: reading %s: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config: unsupported file type %q, use .yaml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+func loadEnv(cfg *Config) error {
+	strs := map[string]*string{
+		"WALLET_DB_DSN":              &cfg.DB.DSN,
+		"WALLET_ADDR":                &cfg.Server.Addr,
+		"WALLET_FX_RATES_FILE":       &cfg.FX.RatesFile,
+		"WALLET_FEE_SCHEDULE_FILE":   &cfg.Fees.ScheduleFile,
+		"WALLET_JWT_SECRET":          &cfg.Auth.JWTSecret,
+		"WALLET_JWT_PUBLIC_KEY_FILE": &cfg.Auth.JWTPublicKeyFile,
+		"WALLET_JWT_ISSUER":          &cfg.Auth.JWTIssuer,
+		"WALLET_JWT_AUDIENCE":        &cfg.Auth.JWTAudience,
+		"WALLET_SIGNING_KEYS_FILE":   &cfg.Auth.SigningKeysFile,
+	}
+	for name, dst := range strs {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+
+	if v, ok := os.LookupEnv("WALLET_TRUSTED_PROXIES"); ok {
+		cfg.Server.TrustedProxies = splitList(v)
+	}
+
+	ints := map[string]*int{
+		"WALLET_DB_MAX_OPEN_CONNS": &cfg.DB.MaxOpenConns,
+		"WALLET_DB_MAX_IDLE_CONNS": &cfg.DB.MaxIdleConns,
+	}
+	for name, dst := range ints {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+			*dst = n
+		}
+	}
+
+	bools := map[string]*bool{
+		"WALLET_REQUIRE_SIGNATURES": &cfg.Auth.RequireSignatures,
+	}
+	for name, dst := range bools {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+			*dst = b
+		}
+	}
+
+	durations := map[string]*Duration{
+		"WALLET_DB_CONN_MAX_LIFETIME": &cfg.DB.ConnMaxLifetime,
+		"WALLET_DB_CONNECT_TIMEOUT":   &cfg.DB.ConnectTimeout,
+		"WALLET_READ_TIMEOUT":         &cfg.Server.ReadTimeout,
+		"WALLET_WRITE_TIMEOUT":        &cfg.Server.WriteTimeout,
+		"WALLET_IDLE_TIMEOUT":         &cfg.Server.IdleTimeout,
+		"WALLET_SHUTDOWN_TIMEOUT":     &cfg.Server.ShutdownTimeout,
+		"WALLET_FX_QUOTE_TTL":         &cfg.FX.QuoteTTL,
+		"WALLET_SIGNATURE_SKEW":       &cfg.Auth.SignatureSkew,
+	}
+	for name, dst := range durations {
+		if v, ok := os.LookupEnv(name); ok {
+			if err := dst.UnmarshalText([]byte(v)); err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
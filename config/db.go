@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -8,18 +9,32 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func InitDB() (*sql.DB, error) {
-	connStr := "user=my password=66668888 dbname=postgres sslmode=disable"
-	db, err := sql.Open("postgres", connStr)
+/*
+InitDB opens the connection pool described by cfg and makes sure the
+database is reachable. Errors are returned to the caller, it is up to
+main or the test runner to decide whether they are fatal.
+*/
+func InitDB(cfg DBConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DSN)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, fmt.Errorf("open db: %w", err)
 	}
 
-	err = db.Ping()
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+
+	ctx := context.Background()
+	if cfg.ConnectTimeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout.Duration)
+		defer cancel()
+	}
+
+	err = db.PingContext(ctx)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		db.Close()
+		return nil, fmt.Errorf("ping db: %w", err)
 	}
 
 	return db, nil
@@ -0,0 +1,66 @@
+package handles
+
+import (
+	"context"
+	"net/http"
+	"services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusChange is the body of the wallet status endpoints, the reason ends up
+// in the status history next to the principal that made the change
+type statusChange struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// FreezeWallet handles POST /admin/wallets/:wallet_id/freeze
+func (h *WalletHandler) FreezeWallet(c *gin.Context) {
+	h.changeWalletStatus(c, h.Service.FreezeWallet)
+}
+
+// UnfreezeWallet handles POST /admin/wallets/:wallet_id/unfreeze
+func (h *WalletHandler) UnfreezeWallet(c *gin.Context) {
+	h.changeWalletStatus(c, h.Service.UnfreezeWallet)
+}
+
+// CloseWallet handles POST /admin/wallets/:wallet_id/close, only an empty
+// wallet can be closed
+func (h *WalletHandler) CloseWallet(c *gin.Context) {
+	h.changeWalletStatus(c, h.Service.CloseWallet)
+}
+
+func (h *WalletHandler) changeWalletStatus(c *gin.Context, change func(ctx context.Context, walletID int, actor, reason string) (services.Wallet, error)) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	var request statusChange
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	wallet, err := change(c.Request.Context(), walletID, principalSubject(c), request.Reason)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"wallet": wallet})
+}
+
+// GetWalletStatusEvents handles GET /admin/wallets/:wallet_id/status-events
+func (h *WalletHandler) GetWalletStatusEvents(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	events, err := h.Service.WalletStatusEvents(c.Request.Context(), walletID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
@@ -0,0 +1,61 @@
+package handles
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateAPIKey handles POST /admin/api-keys, e.g. {"user_id": 42, "name":
+// "payroll", "scopes": []}. Without user_id the key acts as a service. The
+// secret is only part of this response.
+func (h *UserHandler) CreateAPIKey(c *gin.Context) {
+	var request struct {
+		UserID int      `json:"user_id"`
+		Name   string   `json:"name" binding:"required"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	key, secret, err := h.Service.CreateAPIKey(c.Request.Context(), request.UserID, request.Name, request.Scopes)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"api_key": key, "secret": secret})
+}
+
+// ListAPIKeys handles GET /users/:id/api-keys, secrets are never listed
+func (h *UserHandler) ListAPIKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	keys, err := h.Service.ListAPIKeys(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// RevokeAPIKey handles DELETE /admin/api-keys/:key_id
+func (h *UserHandler) RevokeAPIKey(c *gin.Context) {
+	keyID, ok := pathID(c, "key_id", "Invalid API key ID")
+	if !ok {
+		return
+	}
+
+	key, err := h.Service.RevokeAPIKey(c.Request.Context(), keyID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_key": key})
+}
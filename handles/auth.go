@@ -0,0 +1,364 @@
+package handles
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"os"
+	"services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// APIKeyHeader is the request header API keys are sent in, JWTs go in
+// Authorization as bearer tokens.
+const APIKeyHeader = "X-API-Key"
+
+// Ways a principal can have authenticated.
+const (
+	AuthJWT    = "jwt"
+	AuthAPIKey = "api_key"
+)
+
+const (
+	principalKey = "principal"
+	//set when a path parameter names something of another user
+	othersAccountKey = "others_account"
+)
+
+// Principal is the caller a request was authenticated as.
+type Principal struct {
+	Subject string `json:"subject"`
+	// UserID is the user the caller acts as, zero for a service
+	UserID int      `json:"user_id,omitempty"`
+	Scopes []string `json:"scopes"`
+	// Roles are those assigned to the user and those named by scopes
+	Roles  []string `json:"roles"`
+	Method string   `json:"method"`
+
+	permissions map[string]bool
+	anyAccount  map[string]bool
+}
+
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the roles of the principal grant permission.
+func (p Principal) Can(permission string) bool {
+	return p.permissions[permission]
+}
+
+// CanOnAnyAccount reports whether the roles of the principal grant
+// permission on what belongs to other users as well.
+func (p Principal) CanOnAnyAccount(permission string) bool {
+	return p.anyAccount[permission]
+}
+
+// owns reports whether what belongs to userID is the principal's own
+func (p Principal) owns(userID int) bool {
+	return p.UserID != 0 && p.UserID == userID
+}
+
+// APIKeyAuthenticator resolves the secret of an API key, *services.UserService is one.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, secret string) (services.APIKey, error)
+}
+
+// OwnerResolver tells which user a resource belongs to, *services.WalletService is one.
+type OwnerResolver interface {
+	ResourceOwner(ctx context.Context, resource string, id int) (int, error)
+}
+
+// RoleResolver returns the roles of a user, *services.UserService is one.
+type RoleResolver interface {
+	UserRoles(ctx context.Context, userID int) ([]string, error)
+}
+
+// JWTConfig says which bearer tokens are accepted. HS256 tokens need
+// HMACSecret and RS256 tokens RSAPublicKey, without either JWTs are refused.
+// Issuer and Audience are checked when set.
+type JWTConfig struct {
+	HMACSecret   []byte
+	RSAPublicKey *rsa.PublicKey
+	Issuer       string
+	Audience     string
+}
+
+// LoadRSAPublicKey reads the PEM encoded key RS256 tokens are verified with.
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: reading %s: %w", path, err)
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: parsing %s: %w", path, err)
+	}
+	return key, nil
+}
+
+/*
+Authenticator resolves the principal of every request, from a bearer JWT or
+an API key, and refuses requests with neither.
+
+A JWT must carry exp and sub. A numeric sub is the id of the user the token
+acts as, scopes come space separated in the scope claim like in OAuth, e.g.
+
+	{"sub": "42", "scope": "admin", "exp": 1735689600}
+
+The roles of a principal are those Roles has for its user and those its
+scopes name, so a key or token of a service can be given "finance". Path
+parameters naming a user, wallet, hold or schedule must belong to the
+principal's user unless its roles grant services.PermAnyAccount, the answer
+is 403 otherwise. Which routes need which permission is up to
+RequirePermission, which on the wallets of others only counts what the roles
+granting services.PermAnyAccount grant.
+*/
+type Authenticator struct {
+	JWT     JWTConfig
+	APIKeys APIKeyAuthenticator
+	Owners  OwnerResolver
+	Roles   RoleResolver
+	// Signatures checks signed requests around Authenticate, nil for none
+	Signatures *SignatureVerifier
+}
+
+// Authenticate is the middleware NewRouter puts in front of every endpoint.
+func (a *Authenticator) Authenticate(c *gin.Context) {
+	principal, err := a.principal(c)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer realm="wallet"`)
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthorized, err.Error())
+		return
+	}
+	if err := a.resolveRoles(c.Request.Context(), &principal); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Set(principalKey, principal)
+	c.Request = c.Request.WithContext(services.WithAuditContext(c.Request.Context(), services.AuditContext{
+		Actor: principal.Subject, IP: c.ClientIP(), RequestID: requestID(c),
+	}))
+
+	if !a.authorizeOwner(c, principal) {
+		return
+	}
+	c.Next()
+}
+
+func (a *Authenticator) principal(c *gin.Context) (Principal, error) {
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		if a.APIKeys == nil {
+			return Principal{}, services.ErrUnauthenticated
+		}
+		apiKey, err := a.APIKeys.AuthenticateAPIKey(c.Request.Context(), key)
+		if err != nil {
+			return Principal{}, services.ErrUnauthenticated
+		}
+		return Principal{Subject: "api_key:" + strconv.Itoa(apiKey.ID), UserID: apiKey.UserID, Scopes: apiKey.Scopes, Method: AuthAPIKey}, nil
+	}
+
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return Principal{}, services.ErrUnauthenticated
+	}
+	return a.parseJWT(strings.TrimSpace(token))
+}
+
+func (a *Authenticator) resolveRoles(ctx context.Context, principal *Principal) error {
+	var roles []string
+	if principal.UserID != 0 {
+		roles = []string{services.RoleCustomer}
+		if a.Roles != nil {
+			var err error
+			if roles, err = a.Roles.UserRoles(ctx, principal.UserID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, scope := range principal.Scopes {
+		if services.IsRole(scope) {
+			roles = append(roles, scope)
+		}
+	}
+	principal.Roles = roles
+	principal.permissions = services.Permissions(roles)
+	principal.anyAccount = services.AnyAccountPermissions(roles)
+	return nil
+}
+
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope"`
+}
+
+func (a *Authenticator) parseJWT(raw string) (Principal, error) {
+	//only the algorithms a key is configured for are accepted, a token can
+	//not pick HS256 and get verified with the public RSA key as secret
+	var methods []string
+	if len(a.JWT.HMACSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if a.JWT.RSAPublicKey != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return Principal{}, services.ErrUnauthenticated
+	}
+
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() == jwt.SigningMethodRS256.Alg() {
+			return a.JWT.RSAPublicKey, nil
+		}
+		return a.JWT.HMACSecret, nil
+	}, jwt.WithValidMethods(methods))
+	if err != nil {
+		return Principal{}, services.ErrUnauthenticated
+	}
+	if !claims.VerifyExpiresAt(time.Now(), true) || claims.Subject == "" {
+		return Principal{}, services.ErrUnauthenticated
+	}
+	if a.JWT.Issuer != "" && !claims.VerifyIssuer(a.JWT.Issuer, true) {
+		return Principal{}, services.ErrUnauthenticated
+	}
+	if a.JWT.Audience != "" && !claims.VerifyAudience(a.JWT.Audience, true) {
+		return Principal{}, services.ErrUnauthenticated
+	}
+
+	principal := Principal{Subject: claims.Subject, Scopes: strings.Fields(claims.Scope), Method: AuthJWT}
+	if userID, err := strconv.Atoi(claims.Subject); err == nil && userID > 0 {
+		principal.UserID = userID
+	}
+	return principal, nil
+}
+
+// ownerParams are the path parameters that name something belonging to a
+// user, resource is empty for parameters that are user ids themselves
+var ownerParams = []struct {
+	param    string
+	resource string
+}{
+	{"user_id", ""},
+	{"from_user_id", ""},
+	{"wallet_id", services.ResourceWallet},
+	{"hold_id", services.ResourceHold},
+	{"schedule_id", services.ResourceSchedule},
+}
+
+// authorizeOwner answers 403 and returns false when a path parameter names
+// something the principal does not own and may not reach either
+func (a *Authenticator) authorizeOwner(c *gin.Context, principal Principal) bool {
+	params := ownerParams
+	//the user routes name their user :id
+	if strings.HasPrefix(c.FullPath(), APIPrefix+"/users/:id") {
+		params = append(params[:len(params):len(params)], struct{ param, resource string }{"id", ""})
+	}
+	for _, p := range params {
+		value := c.Param(p.param)
+		if value == "" {
+			continue
+		}
+		//malformed ids are left to the handler to reject
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			continue
+		}
+
+		owner := id
+		if p.resource != "" {
+			if a.Owners == nil {
+				abortWithError(c, http.StatusForbidden, CodeForbidden, "ownership can not be checked")
+				return false
+			}
+			owner, err = a.Owners.ResourceOwner(c.Request.Context(), p.resource, id)
+			if err != nil {
+				respondError(c, err)
+				return false
+			}
+		}
+		if principal.owns(owner) {
+			continue
+		}
+		//whether the route's permission reaches that far is for RequirePermission
+		if principal.Can(services.PermAnyAccount) {
+			c.Set(othersAccountKey, true)
+			continue
+		}
+		abortWithError(c, http.StatusForbidden, CodeForbidden, fmt.Sprintf("%s %d does not belong to the caller", strings.TrimSuffix(p.param, "_id"), id))
+		return false
+	}
+	return true
+}
+
+// RequirePermission answers 403 to principals whose roles do not grant
+// permission, or do not grant it on the accounts of others when the path
+// names something of another user, naming the permission in the error details.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := PrincipalFrom(c)
+		if !ok {
+			abortWithError(c, http.StatusUnauthorized, CodeUnauthorized, services.ErrUnauthenticated.Error())
+			return
+		}
+		if !principal.Can(permission) {
+			abortMissingPermission(c, permission, "missing permission "+permission)
+			return
+		}
+		if c.GetBool(othersAccountKey) && !principal.CanOnAnyAccount(permission) {
+			abortMissingPermission(c, permission, "missing permission "+permission+" on the accounts of other users")
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortMissingPermission(c *gin.Context, permission, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrorBody{
+		Code:      CodeForbidden,
+		Message:   message,
+		RequestID: requestID(c),
+		Details:   gin.H{"permission": permission},
+	}})
+}
+
+// PrincipalFrom returns the principal Authenticate stored for the request.
+func PrincipalFrom(c *gin.Context) (Principal, bool) {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := v.(Principal)
+	return principal, ok
+}
+
+// principalSubject is the subject of the caller, the actor recorded by admin
+// changes, empty when the request was not authenticated
+func principalSubject(c *gin.Context) string {
+	principal, _ := PrincipalFrom(c)
+	return principal.Subject
+}
+
+// authorizeUser answers 403 and returns false when the request acts on a
+// user named in its body that is not the principal's, unless its roles grant
+// permission on any account. Requests that never went through Authenticate,
+// like handlers mounted directly in tests, pass.
+func authorizeUser(c *gin.Context, userID int, permission string) bool {
+	principal, ok := PrincipalFrom(c)
+	if !ok || principal.owns(userID) || principal.CanOnAnyAccount(permission) {
+		return true
+	}
+	abortWithError(c, http.StatusForbidden, CodeForbidden, fmt.Sprintf("user %d does not belong to the caller", userID))
+	return false
+}
@@ -0,0 +1,92 @@
+package handles
+
+import (
+	"encoding/json"
+	"net/http"
+	"services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchLegResponse is a leg of a batch response, a failed leg carries the
+// same code and message a single transfer failing that way would
+type batchLegResponse struct {
+	services.BatchLegResult
+	Error *ErrorBody `json:"error,omitempty"`
+}
+
+// renderBatch builds the body of a batch response, it is stored with the
+// Idempotency-Key as well so a retry gets the very same legs back
+func renderBatch(result interface{}) ([]byte, error) {
+	batch := result.(services.BatchResult)
+	legs := make([]batchLegResponse, len(batch.Legs))
+	for i, leg := range batch.Legs {
+		legs[i] = batchLegResponse{BatchLegResult: leg}
+		if leg.Err != nil {
+			_, code, ok := errorCode(leg.Err)
+			message := leg.Err.Error()
+			if !ok {
+				message = "internal server error"
+			}
+			legs[i].Error = &ErrorBody{Code: code, Message: message, Details: errorDetails(leg.Err)}
+		}
+	}
+	return json.Marshal(gin.H{
+		"mode":           batch.Mode,
+		"from_wallet_id": batch.FromWalletID,
+		"currency":       batch.Currency,
+		"total":          batch.Total,
+		"succeeded":      batch.Succeeded,
+		"failed":         batch.Failed,
+		"legs":           legs,
+	})
+}
+
+/*
+TransferBatch handles POST /wallets/:wallet_id/transfers/batch, paying the
+default wallet of every user in legs, e.g.
+
+	{"mode": "best_effort", "legs": [{"to_user_id": 2, "amount": "1200"}, {"to_user_id": 3, "amount": "950.50"}]}
+
+mode is atomic unless given. An atomic batch that fails answers with the
+error of the failing leg, whose index is in the details. A best effort batch
+answers 200 with the outcome of every leg.
+*/
+func (h *WalletHandler) TransferBatch(c *gin.Context) {
+	fromWalletID, ok := pathID(c, "wallet_id", "Invalid sender wallet ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Mode string              `json:"mode"`
+		Legs []services.BatchLeg `json:"legs" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if request.Mode == "" {
+		request.Mode = services.BatchAtomic
+	}
+
+	if err := withIdempotentResult(c, request, http.StatusOK, renderBatch); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	result, err := h.Service.TransferBatch(c.Request.Context(), fromWalletID, request.Mode, request.Legs)
+	if err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	body, err := renderBatch(result)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,157 @@
+package handles
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Machine-readable error codes, clients should switch on these rather than on messages.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidAmount        = "invalid_amount"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeInsufficientFunds    = "insufficient_funds"
+	CodeWalletNotFound       = "wallet_not_found"
+	CodeUserNotFound         = "user_not_found"
+	CodeWalletFrozen         = "wallet_frozen"
+	CodeWalletClosed         = "wallet_closed"
+	CodeWalletNotEmpty       = "wallet_not_empty"
+	CodeInvalidStatusChange  = "invalid_status_change"
+	CodeUnsupportedCurrency  = "unsupported_currency"
+	CodeCurrencyMismatch     = "currency_mismatch"
+	CodeRateUnavailable      = "rate_unavailable"
+	CodeQuoteNotFound        = "quote_not_found"
+	CodeQuoteExpired         = "quote_expired"
+	CodeHoldNotFound         = "hold_not_found"
+	CodeHoldNotActive        = "hold_not_active"
+	CodeTransactionNotFound  = "transaction_not_found"
+	CodeNotReversible        = "not_reversible"
+	CodeAlreadyReversed      = "already_reversed"
+	CodeScheduleNotFound     = "schedule_not_found"
+	CodeScheduleNotActive    = "schedule_not_active"
+	CodeAPIKeyNotFound       = "api_key_not_found"
+	CodeLimitExceeded        = "limit_exceeded"
+	CodeConflict             = "conflict"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeRateLimited          = "rate_limited"
+	CodeInvalidSignature     = "invalid_signature"
+	CodeInternal             = "internal_error"
+)
+
+// ErrorBody is the payload of every error response, sent as {"error": ErrorBody}.
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	// Details carries machine-readable fields of some errors, like the allowance left under a limit
+	Details gin.H `json:"details,omitempty"`
+}
+
+// errorMappings translates service errors into statuses and codes, checked with errors.Is in order
+var errorMappings = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{services.ErrInvalidAmount, http.StatusBadRequest, CodeInvalidAmount},
+	{services.ErrSameWallet, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidUserName, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidFilter, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidReason, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidActor, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidLimits, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidTier, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidOperation, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidSchedule, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidBatch, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidAPIKey, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrInvalidRole, http.StatusBadRequest, CodeInvalidRequest},
+	{services.ErrUnauthenticated, http.StatusUnauthorized, CodeUnauthorized},
+	{services.ErrUnsupportedCurrency, http.StatusBadRequest, CodeUnsupportedCurrency},
+	{services.ErrWalletNotFound, http.StatusNotFound, CodeWalletNotFound},
+	{services.ErrUserNotFound, http.StatusNotFound, CodeUserNotFound},
+	{services.ErrQuoteNotFound, http.StatusNotFound, CodeQuoteNotFound},
+	{services.ErrHoldNotFound, http.StatusNotFound, CodeHoldNotFound},
+	{services.ErrTransactionNotFound, http.StatusNotFound, CodeTransactionNotFound},
+	{services.ErrScheduleNotFound, http.StatusNotFound, CodeScheduleNotFound},
+	{services.ErrAPIKeyNotFound, http.StatusNotFound, CodeAPIKeyNotFound},
+	{services.ErrInsufficientFunds, http.StatusUnprocessableEntity, CodeInsufficientFunds},
+	{services.ErrCurrencyMismatch, http.StatusUnprocessableEntity, CodeCurrencyMismatch},
+	{services.ErrRateUnavailable, http.StatusUnprocessableEntity, CodeRateUnavailable},
+	{services.ErrNotReversible, http.StatusUnprocessableEntity, CodeNotReversible},
+	{services.ErrLimitExceeded, http.StatusUnprocessableEntity, CodeLimitExceeded},
+	{services.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused},
+	{services.ErrWalletFrozen, http.StatusConflict, CodeWalletFrozen},
+	{services.ErrWalletClosed, http.StatusConflict, CodeWalletClosed},
+	{services.ErrWalletNotEmpty, http.StatusConflict, CodeWalletNotEmpty},
+	{services.ErrInvalidStatusChange, http.StatusConflict, CodeInvalidStatusChange},
+	{services.ErrQuoteExpired, http.StatusConflict, CodeQuoteExpired},
+	{services.ErrHoldNotActive, http.StatusConflict, CodeHoldNotActive},
+	{services.ErrAlreadyReversed, http.StatusConflict, CodeAlreadyReversed},
+	{services.ErrScheduleNotActive, http.StatusConflict, CodeScheduleNotActive},
+	{services.ErrConflict, http.StatusConflict, CodeConflict},
+}
+
+// abortWithError writes the standard error body and stops the handler chain.
+func abortWithError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(c),
+	}})
+}
+
+// respondError maps an error coming back from the services to its response,
+// anything unknown is logged and reported as a 500 without internal details.
+func respondError(c *gin.Context, err error) {
+	status, code, ok := errorCode(err)
+	if !ok {
+		log.Printf("request %s: %s %s: %v", requestID(c), c.Request.Method, c.Request.URL.Path, err)
+		abortWithError(c, status, code, "internal server error")
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": ErrorBody{
+		Code:      code,
+		Message:   err.Error(),
+		RequestID: requestID(c),
+		Details:   errorDetails(err),
+	}})
+}
+
+// errorCode looks err up in errorMappings, false for errors that are not
+// meant for clients which get a 500
+func errorCode(err error) (int, string, bool) {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return m.status, m.code, true
+		}
+	}
+	return http.StatusInternalServerError, CodeInternal, false
+}
+
+// errorDetails returns the machine-readable fields an error carries, nil for most errors
+func errorDetails(err error) gin.H {
+	var details gin.H
+	var limit *services.LimitExceededError
+	if errors.As(err, &limit) {
+		details = gin.H{
+			"wallet_id": limit.WalletID,
+			"limit":     limit.Limit,
+			"remaining": limit.Remaining.String(),
+			"currency":  limit.Currency,
+		}
+	}
+	var leg *services.BatchLegError
+	if errors.As(err, &leg) {
+		if details == nil {
+			details = gin.H{}
+		}
+		details["leg"] = leg.Index
+	}
+	return details
+}
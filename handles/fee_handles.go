@@ -0,0 +1,24 @@
+package handles
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PreviewFee handles GET /wallets/:wallet_id/fee?operation=withdraw&amount=25,
+// a dry run returning the fee the operation would be charged and the total
+// leaving the wallet, nothing is booked
+func (h *WalletHandler) PreviewFee(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	quote, err := h.Service.PreviewFee(c.Request.Context(), walletID, c.Query("operation"), c.Query("amount"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, quote)
+}
@@ -0,0 +1,72 @@
+package handles
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateQuote handles POST /fx/quotes, locking the current rate of a
+// currency pair for the quote TTL
+func (h *WalletHandler) CreateQuote(c *gin.Context) {
+	var request struct {
+		FromCurrency string `json:"from_currency" binding:"required"`
+		ToCurrency   string `json:"to_currency" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	quote, err := h.Service.CreateQuote(c.Request.Context(), request.FromCurrency, request.ToCurrency)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, quote)
+}
+
+// ConvertBetweenWallets handles POST /wallets/:wallet_id/convert, a transfer
+// to a wallet in another currency at the rate of a quote
+func (h *WalletHandler) ConvertBetweenWallets(c *gin.Context) {
+	fromWalletID, ok := pathID(c, "wallet_id", "Invalid sender wallet ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ToWalletID int    `json:"to_wallet_id" binding:"required"`
+		Amount     string `json:"amount" binding:"required"`
+		QuoteID    string `json:"quote_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	if err := withIdempotentResult(c, request, http.StatusOK, renderConvert); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	txID, err := h.Service.ConvertAndTransfer(c.Request.Context(), fromWalletID, request.ToWalletID, request.Amount, request.QuoteID)
+	if err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	body, err := renderConvert(txID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// renderConvert is the response of a conversion, naming the transaction booked
+func renderConvert(result interface{}) ([]byte, error) {
+	return json.Marshal(gin.H{"message": "Transfer successful", "transaction_id": result.(int64)})
+}
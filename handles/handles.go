@@ -1,9 +1,12 @@
 package handles
 
 import (
+	"context"
 	"net/http"
 	"services"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
@@ -17,78 +20,169 @@ func NewWalletHandler(service *services.WalletService) *WalletHandler {
 	return &WalletHandler{Service: service}
 }
 
+// pathID parses a positive integer path parameter, answering 400 with
+// message and returning false when it is missing or malformed
+func pathID(c *gin.Context, name, message string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, message)
+		return 0, false
+	}
+	return id, true
+}
+
 func (h *WalletHandler) CreateWallet(c *gin.Context) {
-	id, err := h.Service.CreateWallet()
+	var request struct {
+		UserID   int    `json:"user_id" binding:"required"`
+		Currency string `json:"currency"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if !authorizeUser(c, request.UserID, services.PermWalletsWrite) {
+		return
+	}
+
+	id, err := h.Service.CreateWallet(c.Request.Context(), request.UserID, request.Currency)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"wallet_id": id})
 }
 
-func (h *WalletHandler) Deposit(c *gin.Context) {
-	userID, err := strconv.Atoi(c.Param("user_id"))
+// ListUserWallets handles GET /users/:id/wallets
+func (h *WalletHandler) ListUserWallets(c *gin.Context) {
+	userID, ok := pathID(c, "id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	wallets, err := h.Service.ListWallets(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		respondError(c, err)
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"wallets": wallets})
+}
 
+func (h *WalletHandler) Deposit(c *gin.Context) {
+	userID, ok := pathID(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+	h.deposit(c, func(ctx context.Context, amount string) error {
+		return h.Service.Deposit(ctx, userID, amount)
+	})
+}
+
+func (h *WalletHandler) DepositToWallet(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+	h.deposit(c, func(ctx context.Context, amount string) error {
+		return h.Service.DepositToWallet(ctx, walletID, amount)
+	})
+}
+
+func (h *WalletHandler) deposit(c *gin.Context, do func(ctx context.Context, amount string) error) {
 	var request struct {
 		Amount string `json:"amount" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
 		return
 	}
 
 	//check if amount is number format
 	amount, err := decimal.NewFromString(request.Amount)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount format"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidAmount, "Invalid amount format")
 		return
 	}
 
 	if amount.Sign() <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Deposit amount must be greater than 0"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidAmount, "Deposit amount must be greater than 0")
+		return
+	}
+
+	response := gin.H{"message": "Deposit successful"}
+	if err := withIdempotency(c, request, http.StatusOK, response); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
+
 	// Call the service layer to perform the deposit
-	if err := h.Service.Deposit(userID, request.Amount); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := do(c.Request.Context(), request.Amount); err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Deposit successful"})
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *WalletHandler) Withdraw(c *gin.Context) {
-	userID, err := strconv.Atoi(c.Param("user_id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	userID, ok := pathID(c, "user_id", "Invalid user ID")
+	if !ok {
 		return
 	}
+	h.withdraw(c, func(ctx context.Context, amount string) error {
+		return h.Service.Withdraw(ctx, userID, amount)
+	})
+}
 
+func (h *WalletHandler) WithdrawFromWallet(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+	h.withdraw(c, func(ctx context.Context, amount string) error {
+		return h.Service.WithdrawFromWallet(ctx, walletID, amount)
+	})
+}
+
+func (h *WalletHandler) withdraw(c *gin.Context, do func(ctx context.Context, amount string) error) {
 	var request struct {
 		Amount string `json:"amount" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	response := gin.H{"message": "Withdrawal successful"}
+	if err := withIdempotency(c, request, http.StatusOK, response); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
 	// Call the service layer to perform the withdrawal
-	if err := h.Service.Withdraw(userID, request.Amount); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := do(c.Request.Context(), request.Amount); err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Withdrawal successful"})
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *WalletHandler) Transfer(c *gin.Context) {
-	fromUserID, err := strconv.Atoi(c.Param("from_user_id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sender user ID"})
+	//the sender can be named either :from_user_id or :user_id, gin does not allow
+	//two different wildcard names under the same /wallet prefix
+	param := c.Param("from_user_id")
+	if param == "" {
+		param = c.Param("user_id")
+	}
+	fromUserID, err := strconv.Atoi(param)
+	if err != nil || fromUserID <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid sender user ID")
 		return
 	}
 
@@ -97,50 +191,210 @@ func (h *WalletHandler) Transfer(c *gin.Context) {
 		Amount   string `json:"amount" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	h.transfer(c, request, func(ctx context.Context) error {
+		return h.Service.Transfer(ctx, fromUserID, request.ToUserID, request.Amount)
+	})
+}
+
+func (h *WalletHandler) TransferBetweenWallets(c *gin.Context) {
+	fromWalletID, ok := pathID(c, "wallet_id", "Invalid sender wallet ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ToWalletID int    `json:"to_wallet_id" binding:"required"`
+		Amount     string `json:"amount" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	h.transfer(c, request, func(ctx context.Context) error {
+		return h.Service.TransferBetweenWallets(ctx, fromWalletID, request.ToWalletID, request.Amount)
+	})
+}
+
+func (h *WalletHandler) transfer(c *gin.Context, request interface{}, do func(ctx context.Context) error) {
+	response := gin.H{"message": "Transfer successful"}
+	if err := withIdempotency(c, request, http.StatusOK, response); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
 	// Call the service layer to perform the transfer
-	if err := h.Service.Transfer(fromUserID, request.ToUserID, request.Amount); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := do(c.Request.Context()); err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Transfer successful"})
+	c.JSON(http.StatusOK, response)
 }
 
 // GetBalance handler
 func (h *WalletHandler) GetBalance(c *gin.Context) {
-	userID, err := strconv.Atoi(c.Param("user_id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	userID, ok := pathID(c, "user_id", "Invalid user ID")
+	if !ok {
 		return
 	}
 
 	// Call the service layer to get the balance
-	balance, err := h.Service.GetBalance(userID)
+	wallet, err := h.Service.GetDefaultWallet(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"balance": balance.String()})
+	c.JSON(http.StatusOK, balanceResponse(wallet))
 }
 
-func (h *WalletHandler) GetTransactionHistory(c *gin.Context) {
-	userID, err := strconv.Atoi(c.Param("user_id"))
+func (h *WalletHandler) GetWalletBalance(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	wallet, err := h.Service.GetWallet(c.Request.Context(), walletID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, balanceResponse(wallet))
+}
+
+// balanceResponse renders the balances with the number of decimals of the
+// wallet currency, so 5 JPY reads "5" and 5 USD reads "5.00". balance is the
+// ledger balance, available leaves out what active holds reserve
+func balanceResponse(wallet services.Wallet) gin.H {
+	return gin.H{
+		"balance":   services.FormatAmount(wallet.Currency, wallet.Balance),
+		"available": services.FormatAmount(wallet.Currency, wallet.Available()),
+		"currency":  wallet.Currency,
+	}
+}
+
+func (h *WalletHandler) GetTransactionHistory(c *gin.Context) {
+	userID, ok := pathID(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	filter, ok := historyFilter(c)
+	if !ok {
 		return
 	}
 
 	// Call the service layer to get the transaction history
-	transactions, err := h.Service.GetTransactionHistory(userID)
+	page, err := h.Service.GetTransactionHistory(c.Request.Context(), userID, filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *WalletHandler) GetWalletTransactionHistory(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, transactions)
+	filter, ok := historyFilter(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.Service.GetWalletTransactionHistory(c.Request.Context(), walletID, filter)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+/*
+historyFilter reads the history query parameters:
+
+	?limit=50&cursor=...&order=asc|desc&type=deposit,transfer
+	&min_amount=10&max_amount=100&from=2024-01-01T00:00:00Z&to=...
+	&counterparty_user_id=2&counterparty_wallet_id=7
+
+answering 400 and returning false when one of them is malformed
+*/
+func historyFilter(c *gin.Context) (services.HistoryFilter, bool) {
+	filter := services.HistoryFilter{Cursor: c.Query("cursor")}
+	invalid := func(message string) (services.HistoryFilter, bool) {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, message)
+		return filter, false
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > services.MaxHistoryLimit {
+			return invalid("limit must be between 1 and " + strconv.Itoa(services.MaxHistoryLimit))
+		}
+		filter.Limit = limit
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		filter.Ascending = true
+	case "desc":
+	default:
+		return invalid("order must be asc or desc")
+	}
+
+	for _, types := range c.QueryArray("type") {
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				filter.Types = append(filter.Types, t)
+			}
+		}
+	}
+
+	amounts := map[string]**decimal.Decimal{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount}
+	for name, dst := range amounts {
+		if v := c.Query(name); v != "" {
+			amount, err := decimal.NewFromString(v)
+			if err != nil {
+				return invalid(name + " must be a number")
+			}
+			*dst = &amount
+		}
+	}
+
+	times := map[string]**time.Time{"from": &filter.From, "to": &filter.To}
+	for name, dst := range times {
+		if v := c.Query(name); v != "" {
+			at, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return invalid(name + " must be an RFC 3339 timestamp")
+			}
+			*dst = &at
+		}
+	}
+
+	ids := map[string]*int{"counterparty_user_id": &filter.CounterpartyUserID, "counterparty_wallet_id": &filter.CounterpartyWalletID}
+	for name, dst := range ids {
+		if v := c.Query(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				return invalid(name + " must be a positive integer")
+			}
+			*dst = id
+		}
+	}
+
+	return filter, true
 }
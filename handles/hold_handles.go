@@ -0,0 +1,127 @@
+package handles
+
+import (
+	"encoding/json"
+	"net/http"
+	"services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlaceHold handles POST /wallets/:wallet_id/holds, the body names the amount
+// and optionally how long the hold lasts, e.g. {"amount": "20", "expires_in": "15m"}
+func (h *WalletHandler) PlaceHold(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Amount    string `json:"amount" binding:"required"`
+		ExpiresIn string `json:"expires_in"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	var ttl time.Duration
+	if request.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(request.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "expires_in must be a positive duration such as 15m")
+			return
+		}
+	}
+
+	if err := withIdempotentResult(c, request, http.StatusCreated, renderHold); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	hold, err := h.Service.PlaceHold(c.Request.Context(), walletID, request.Amount, ttl)
+	if err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, hold)
+}
+
+// renderHold is the response of placing a hold, the hold as placed
+func renderHold(result interface{}) ([]byte, error) {
+	return json.Marshal(result.(services.Hold))
+}
+
+func (h *WalletHandler) GetHold(c *gin.Context) {
+	holdID, ok := pathID(c, "hold_id", "Invalid hold ID")
+	if !ok {
+		return
+	}
+
+	hold, err := h.Service.GetHold(c.Request.Context(), holdID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, hold)
+}
+
+// CaptureHold handles POST /holds/:hold_id/capture, without to_wallet_id the
+// captured amount is withdrawn, with it the amount is transferred there
+func (h *WalletHandler) CaptureHold(c *gin.Context) {
+	holdID, ok := pathID(c, "hold_id", "Invalid hold ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Amount     string `json:"amount" binding:"required"`
+		ToWalletID int    `json:"to_wallet_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	response := gin.H{"message": "Hold captured"}
+	if err := withIdempotency(c, request, http.StatusOK, response); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := h.Service.CaptureHold(c.Request.Context(), holdID, request.Amount, request.ToWalletID); err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *WalletHandler) VoidHold(c *gin.Context) {
+	holdID, ok := pathID(c, "hold_id", "Invalid hold ID")
+	if !ok {
+		return
+	}
+
+	response := gin.H{"message": "Hold voided"}
+	if err := withIdempotency(c, gin.H{"hold_id": holdID}, http.StatusOK, response); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := h.Service.VoidHold(c.Request.Context(), holdID); err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,96 @@
+package handles
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyHeader is the request header clients put their retry key in.
+const IdempotencyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+// maxScopedSubject is the longest subject put into an idempotency scope as is
+const maxScopedSubject = 128
+
+var errInvalidIdempotencyKey = errors.New("Idempotency-Key must be at most 255 characters")
+
+/*
+withIdempotency attaches the Idempotency-Key of the request, if any, to the
+request context. Keys are scoped to the caller, two callers picking the same
+key never see each other's requests. The fingerprint covers the caller,
+method, path and the parsed body, so reformatting the JSON does not count as
+a different request. The success response is stored with the key so a retry
+can be answered with it.
+*/
+func withIdempotency(c *gin.Context, request interface{}, status int, response gin.H) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return attachIdempotencyKey(c, request, &services.IdempotencyKey{Status: status, Body: body})
+}
+
+// withIdempotentResult is withIdempotency for a response that depends on the
+// outcome of the operation, render turns that outcome into the stored body
+func withIdempotentResult(c *gin.Context, request interface{}, status int, render func(result interface{}) ([]byte, error)) error {
+	return attachIdempotencyKey(c, request, &services.IdempotencyKey{Status: status, Body: []byte("{}"), Render: render})
+}
+
+func attachIdempotencyKey(c *gin.Context, request interface{}, idempotencyKey *services.IdempotencyKey) error {
+	key := c.GetHeader(IdempotencyHeader)
+	if key == "" {
+		return nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return errInvalidIdempotencyKey
+	}
+
+	canonical, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	subject := principalSubject(c)
+	hash := sha256.New()
+	hash.Write([]byte(subject + "\n" + c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	hash.Write(canonical)
+
+	idempotencyKey.Scope = idempotencyScope(subject)
+	idempotencyKey.Key = key
+	idempotencyKey.Fingerprint = hex.EncodeToString(hash.Sum(nil))
+	c.Request = c.Request.WithContext(services.WithIdempotencyKey(c.Request.Context(), idempotencyKey))
+	return nil
+}
+
+// idempotencyScope is the scope of the keys of the caller with given subject,
+// subjects too long for the column are hashed. Requests that did not
+// authenticate share the empty scope.
+func idempotencyScope(subject string) string {
+	if subject == "" {
+		return ""
+	}
+	if len(subject) > maxScopedSubject {
+		sum := sha256.Sum256([]byte(subject))
+		subject = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return "principal:" + subject
+}
+
+// replayIdempotent answers with the stored response when err says the
+// request was already processed, and reports whether it did so. A key
+// reused for another request is left to respondError.
+func replayIdempotent(c *gin.Context, err error) bool {
+	var replay *services.IdempotentReplay
+	if errors.As(err, &replay) {
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(replay.Status, "application/json; charset=utf-8", replay.Body)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package handles
+
+import (
+	"net/http"
+	"services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetWalletLimits handles GET /wallets/:wallet_id/limits, it reports the
+// limits in force and what the wallet already sent within them
+func (h *WalletHandler) GetWalletLimits(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	limits, err := h.Service.GetWalletLimits(c.Request.Context(), walletID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, limits)
+}
+
+// SetWalletLimits handles PUT /admin/wallets/:wallet_id/limits, the body
+// replaces the limits of the wallet and omitted limits are not enforced,
+// e.g. {"max_per_transaction": "500", "daily_count": 10}
+func (h *WalletHandler) SetWalletLimits(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	var limits services.Limits
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.Service.SetWalletLimits(c.Request.Context(), walletID, limits); err != nil {
+		respondError(c, err)
+		return
+	}
+	h.GetWalletLimits(c)
+}
+
+// ClearWalletLimits handles DELETE /admin/wallets/:wallet_id/limits, the
+// limits of the wallet tier apply again
+func (h *WalletHandler) ClearWalletLimits(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	if err := h.Service.ClearWalletLimits(c.Request.Context(), walletID); err != nil {
+		respondError(c, err)
+		return
+	}
+	h.GetWalletLimits(c)
+}
+
+// SetWalletTier handles PUT /admin/wallets/:wallet_id/tier with body {"tier": "premium"}
+func (h *WalletHandler) SetWalletTier(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Tier string `json:"tier" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.Service.SetWalletTier(c.Request.Context(), walletID, request.Tier); err != nil {
+		respondError(c, err)
+		return
+	}
+	h.GetWalletLimits(c)
+}
+
+// SetTierLimits handles PUT /admin/tiers/:tier/limits/:currency, the body
+// has the shape SetWalletLimits takes
+func (h *WalletHandler) SetTierLimits(c *gin.Context) {
+	var limits services.Limits
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	tier, currency := c.Param("tier"), c.Param("currency")
+	if err := h.Service.SetTierLimits(c.Request.Context(), tier, currency, limits); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tier": tier, "currency": currency, "limits": limits})
+}
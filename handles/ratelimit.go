@@ -0,0 +1,272 @@
+package handles
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route groups limits are configured for. Every endpoint is in one of them,
+// see RouteGroup.
+const (
+	RateGroupTransfers = "transfers"
+	RateGroupWrites    = "writes"
+	RateGroupReads     = "reads"
+)
+
+// Keys a request is limited by, named in the details of a 429.
+const (
+	RateKeyPrincipal = "principal"
+	RateKeyIP        = "ip"
+	RateKeyWallet    = "wallet"
+)
+
+// Limit is a token bucket: Requests every Per on average, in bursts of up to
+// Burst, which defaults to Requests. A zero Requests means no limit.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// RouteLimits are the limits of one route group. Each caller, client IP and
+// wallet has buckets of its own in every group.
+type RouteLimits struct {
+	Principal Limit
+	IP        Limit
+	Wallet    Limit
+}
+
+// RateLimitStore keeps the token buckets. MemoryStore keeps them in the
+// process, a store shared by every instance makes the limits hold for the
+// whole deployment.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, which refills at limit. When
+	// the bucket is empty it returns false and how long until a token is back.
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+/*
+RateLimiter answers 429 to requests over the limits of their route group,
+with a Retry-After header of the seconds until one would pass. NewRouter puts
+LimitIP in front of authentication, so a client hammering the API is turned
+away before the database is asked who it is, and Limit right after
+Authenticate: the principal bucket is keyed by the subject that authenticated
+and the wallet bucket by the wallet acted on, by the user for the
+/wallet/:user_id aliases so that no request waits on the database before it
+is let through. Store errors let requests through, an outage of a shared
+store must not take the API down with it.
+*/
+type RateLimiter struct {
+	Store  RateLimitStore
+	Groups map[string]RouteLimits
+}
+
+// rateCheck is one bucket a request is charged, key is only worked out when
+// the bucket is enabled and empty when the request has nothing to key it by
+type rateCheck struct {
+	name  string
+	limit func(RouteLimits) Limit
+	key   func(c *gin.Context) string
+}
+
+// LimitIP is the middleware NewRouter puts in front of authentication, it
+// charges the client ip bucket only. A nil RateLimiter limits nothing.
+func (l *RateLimiter) LimitIP(c *gin.Context) {
+	l.charge(c, rateCheck{RateKeyIP, func(r RouteLimits) Limit { return r.IP }, (*gin.Context).ClientIP})
+}
+
+// Limit is the middleware NewRouter puts right after Authenticate, it charges
+// the principal and wallet buckets. A nil RateLimiter limits nothing.
+func (l *RateLimiter) Limit(c *gin.Context) {
+	l.charge(c,
+		rateCheck{RateKeyPrincipal, func(r RouteLimits) Limit { return r.Principal }, principalSubject},
+		rateCheck{RateKeyWallet, func(r RouteLimits) Limit { return r.Wallet }, walletKey},
+	)
+}
+
+func (l *RateLimiter) charge(c *gin.Context, checks ...rateCheck) {
+	if l == nil || l.Store == nil {
+		c.Next()
+		return
+	}
+	group := RouteGroup(c.Request.Method, c.FullPath())
+	limits, ok := l.Groups[group]
+	if !ok {
+		c.Next()
+		return
+	}
+
+	for _, check := range checks {
+		limit := check.limit(limits)
+		if !limit.enabled() {
+			continue
+		}
+		key := check.key(c)
+		if key == "" {
+			continue
+		}
+		ok, retryAfter, err := l.Store.Take(c.Request.Context(), group+"|"+check.name+":"+key, limit)
+		if err != nil {
+			log.Printf("request %s: rate limit store: %v", requestID(c), err)
+			continue
+		}
+		if !ok {
+			abortRateLimited(c, group, check.name, retryAfter)
+			return
+		}
+	}
+	c.Next()
+}
+
+func abortRateLimited(c *gin.Context, group, key string, retryAfter time.Duration) {
+	//Retry-After is in whole seconds, rounded up so the retry does pass
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": ErrorBody{
+		Code:      CodeRateLimited,
+		Message:   "too many " + group + " requests, retry in " + strconv.Itoa(seconds) + "s",
+		RequestID: requestID(c),
+		Details:   gin.H{"group": group, "limit": key, "retry_after": seconds},
+	}})
+}
+
+// transferRoutes are the endpoints moving money, each one a db transaction
+// locking wallets
+var transferRoutes = map[string]bool{
+	"/wallets/:wallet_id/deposit":           true,
+	"/wallets/:wallet_id/withdraw":          true,
+	"/wallets/:wallet_id/transfer":          true,
+	"/wallets/:wallet_id/transfers/batch":   true,
+	"/wallets/:wallet_id/convert":           true,
+	"/wallets/:wallet_id/holds":             true,
+	"/holds/:hold_id/capture":               true,
+	"/holds/:hold_id/void":                  true,
+	"/transactions/:transaction_id/reverse": true,
+	"/wallet/:user_id/deposit":              true,
+	"/wallet/:user_id/withdraw":             true,
+	"/wallet/:user_id/transfer":             true,
+}
+
+// RouteGroup returns the group of the route registered as path under
+// APIPrefix: requests moving money are transfers, other changes writes and
+// everything read with GET reads.
+func RouteGroup(method, path string) string {
+	path = strings.TrimPrefix(path, APIPrefix)
+	switch {
+	case method == http.MethodPost && transferRoutes[path]:
+		return RateGroupTransfers
+	case method == http.MethodGet || method == http.MethodHead:
+		return RateGroupReads
+	default:
+		return RateGroupWrites
+	}
+}
+
+// walletKey is the id of the wallet a request acts on, the /wallet/:user_id
+// aliases are keyed by the user instead of looking up the default wallet
+// before the handler does it anyway
+func walletKey(c *gin.Context) string {
+	if id := c.Param("wallet_id"); id != "" {
+		return id
+	}
+	if id := c.Param("user_id"); id != "" {
+		return "user:" + id
+	}
+	return ""
+}
+
+// memorySweepInterval is how often MemoryStore forgets the buckets that
+// have filled up again
+const memorySweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	//the refill of the last Take, to know when the bucket is full
+	rate  float64
+	burst float64
+}
+
+// MemoryStore keeps token buckets in the process, limits hold per instance.
+type MemoryStore struct {
+	// Now is the clock of the buckets, time.Now when nil
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take implements RateLimitStore.
+func (m *MemoryStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now()
+	if m.Now != nil {
+		now = m.Now()
+	}
+	rate := float64(limit.Requests) / limit.Per.Seconds()
+	burst := float64(limit.burst())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.buckets == nil {
+		m.buckets = map[string]*bucket{}
+	}
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	}
+	b.last, b.rate, b.burst = now, rate, burst
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep drops the buckets that are full by now, a new one starts out full anyway
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < memorySweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package handles
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReverseTransaction handles POST /transactions/:transaction_id/reverse, with
+// an amount only that much is refunded, without one whatever is left of the
+// transaction is given back
+func (h *WalletHandler) ReverseTransaction(c *gin.Context) {
+	transactionID, ok := pathID(c, "transaction_id", "Invalid transaction ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Reason string `json:"reason" binding:"required"`
+		Amount string `json:"amount"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	render := func(result interface{}) ([]byte, error) {
+		return json.Marshal(gin.H{"message": "Transaction reversed", "transaction_id": result.(int64), "reverses_id": transactionID})
+	}
+	if err := withIdempotentResult(c, request, http.StatusOK, render); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	var reversalID int64
+	var err error
+	if request.Amount == "" {
+		reversalID, err = h.Service.Reverse(c.Request.Context(), transactionID, request.Reason)
+	} else {
+		reversalID, err = h.Service.Refund(c.Request.Context(), transactionID, request.Amount, request.Reason)
+	}
+	if err != nil {
+		if replayIdempotent(c, err) {
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	body, err := render(reversalID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,56 @@
+package handles
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserRoles handles GET /users/:id/roles
+func (h *UserHandler) GetUserRoles(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	roles, err := h.Service.UserRoles(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": id, "roles": roles})
+}
+
+// AssignRole handles PUT /admin/users/:id/roles/:role, the caller is recorded
+// as the one who granted the role
+func (h *UserHandler) AssignRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	assignment, err := h.Service.AssignRole(c.Request.Context(), id, c.Param("role"), principalSubject(c))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role_assignment": assignment})
+}
+
+// RevokeRole handles DELETE /admin/users/:id/roles/:role
+func (h *UserHandler) RevokeRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.Service.RevokeRole(c.Request.Context(), id, c.Param("role")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
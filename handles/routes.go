@@ -0,0 +1,97 @@
+package handles
+
+import (
+	"services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIPrefix is the versioned prefix every public endpoint is mounted under.
+const APIPrefix = "/api/v1"
+
+// RouteRegistrar is implemented by every handler that exposes endpoints.
+type RouteRegistrar interface {
+	RegisterRoutes(rg *gin.RouterGroup)
+}
+
+// RegisterRoutes mounts all wallet endpoints on the given router group,
+// each one behind the permission it needs.
+func (h *WalletHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	read := RequirePermission(services.PermWalletsRead)
+	write := RequirePermission(services.PermWalletsWrite)
+
+	rg.POST("/wallets", write, h.CreateWallet)
+
+	rg.GET("/users/:id/wallets", read, h.ListUserWallets)
+
+	rg.POST("/fx/quotes", write, h.CreateQuote)
+
+	//wallets addressed by their own id
+	byID := rg.Group("/wallets/:wallet_id")
+	byID.POST("/deposit", write, h.DepositToWallet)
+	byID.POST("/withdraw", write, h.WithdrawFromWallet)
+	byID.POST("/transfer", write, h.TransferBetweenWallets)
+	byID.POST("/transfers/batch", write, h.TransferBatch)
+	byID.POST("/convert", write, h.ConvertBetweenWallets)
+	byID.POST("/holds", write, h.PlaceHold)
+	byID.GET("/balance", read, h.GetWalletBalance)
+	byID.GET("/transactions", read, h.GetWalletTransactionHistory)
+	byID.GET("/limits", read, h.GetWalletLimits)
+	byID.GET("/fee", read, h.PreviewFee)
+	byID.POST("/schedules", write, h.CreateSchedule)
+	byID.GET("/schedules", read, h.ListSchedules)
+
+	//money is taken back from the receiver, so the sender can not reverse
+	rg.POST("/transactions/:transaction_id/reverse", RequirePermission(services.PermTransactionsReverse), h.ReverseTransaction)
+
+	holds := rg.Group("/holds/:hold_id")
+	holds.GET("", read, h.GetHold)
+	holds.POST("/capture", write, h.CaptureHold)
+	holds.POST("/void", write, h.VoidHold)
+
+	schedules := rg.Group("/schedules/:schedule_id")
+	schedules.GET("", read, h.GetSchedule)
+	schedules.PATCH("", write, h.UpdateSchedule)
+	schedules.DELETE("", write, h.CancelSchedule)
+	schedules.GET("/runs", read, h.GetScheduleRuns)
+
+	//compliance actions on the status and limits of a wallet
+	freeze := RequirePermission(services.PermWalletsFreeze)
+	limits := RequirePermission(services.PermLimitsManage)
+	admin := rg.Group("/admin/wallets/:wallet_id")
+	admin.POST("/freeze", freeze, h.FreezeWallet)
+	admin.POST("/unfreeze", freeze, h.UnfreezeWallet)
+	admin.POST("/close", RequirePermission(services.PermWalletsClose), h.CloseWallet)
+	admin.GET("/status-events", freeze, h.GetWalletStatusEvents)
+	admin.PUT("/limits", limits, h.SetWalletLimits)
+	admin.DELETE("/limits", limits, h.ClearWalletLimits)
+	admin.PUT("/tier", limits, h.SetWalletTier)
+	rg.PUT("/admin/tiers/:tier/limits/:currency", limits, h.SetTierLimits)
+
+	//aliases acting on the default wallet of a user
+	wallet := rg.Group("/wallet/:user_id")
+	wallet.POST("/deposit", write, h.Deposit)
+	wallet.POST("/withdraw", write, h.Withdraw)
+	wallet.POST("/transfer", write, h.Transfer)
+	wallet.GET("/balance", read, h.GetBalance)
+	wallet.GET("/transactions", read, h.GetTransactionHistory)
+}
+
+// NewRouter builds a gin engine with the given handlers registered under
+// APIPrefix, every one of them behind the ip limit of limiter, the signature
+// check of auth, auth itself and the check that the signing key is the
+// caller's, then the principal and wallet limits of limiter. A nil limiter
+// limits nothing. No proxy is trusted, the client ip is the address a
+// request came from until SetTrustedProxies says otherwise, a spoofed
+// X-Forwarded-For would get a fresh ip bucket every time.
+func NewRouter(auth *Authenticator, limiter *RateLimiter, handlers ...RouteRegistrar) *gin.Engine {
+	router := gin.New()
+	//gin trusts every proxy by default, nil can not fail to parse
+	_ = router.SetTrustedProxies(nil)
+	router.Use(RequestID(), gin.Logger(), gin.Recovery())
+	api := router.Group(APIPrefix, limiter.LimitIP, auth.Signatures.Verify, auth.Authenticate, auth.Signatures.CheckOwner, limiter.Limit)
+	for _, h := range handlers {
+		h.RegisterRoutes(api)
+	}
+	return router
+}
@@ -0,0 +1,138 @@
+package handles
+
+import (
+	"net/http"
+	"services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateSchedule handles POST /wallets/:wallet_id/schedules, the body names the
+// receiver, amount and recurrence and optionally when runs start and end, e.g.
+// {"to_wallet_id": 7, "amount": "50", "recurrence": "monthly", "start_at": "2024-07-01T09:00:00Z"}
+func (h *WalletHandler) CreateSchedule(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ToWalletID int        `json:"to_wallet_id" binding:"required"`
+		Amount     string     `json:"amount" binding:"required"`
+		Recurrence string     `json:"recurrence"`
+		StartAt    *time.Time `json:"start_at"`
+		EndAt      *time.Time `json:"end_at"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	req := services.NewSchedule{
+		FromWalletID: walletID,
+		ToWalletID:   request.ToWalletID,
+		Amount:       request.Amount,
+		Recurrence:   request.Recurrence,
+		EndAt:        request.EndAt,
+	}
+	if request.StartAt != nil {
+		req.StartAt = *request.StartAt
+	}
+	schedule, err := h.Service.CreateSchedule(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// ListSchedules handles GET /wallets/:wallet_id/schedules
+func (h *WalletHandler) ListSchedules(c *gin.Context) {
+	walletID, ok := pathID(c, "wallet_id", "Invalid wallet ID")
+	if !ok {
+		return
+	}
+
+	schedules, err := h.Service.ListSchedules(c.Request.Context(), walletID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func (h *WalletHandler) GetSchedule(c *gin.Context) {
+	scheduleID, ok := pathID(c, "schedule_id", "Invalid schedule ID")
+	if !ok {
+		return
+	}
+
+	schedule, err := h.Service.GetSchedule(c.Request.Context(), scheduleID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// UpdateSchedule handles PATCH /schedules/:schedule_id, every field is
+// optional, e.g. {"status": "paused"} or {"amount": "75", "end_at": "2025-01-01T00:00:00Z"}
+func (h *WalletHandler) UpdateSchedule(c *gin.Context) {
+	scheduleID, ok := pathID(c, "schedule_id", "Invalid schedule ID")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Amount *string    `json:"amount"`
+		Status *string    `json:"status"`
+		EndAt  *time.Time `json:"end_at"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	schedule, err := h.Service.UpdateSchedule(c.Request.Context(), scheduleID, services.ScheduleUpdate{
+		Amount: request.Amount,
+		Status: request.Status,
+		EndAt:  request.EndAt,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// CancelSchedule handles DELETE /schedules/:schedule_id, the schedule and its
+// runs are kept with status cancelled
+func (h *WalletHandler) CancelSchedule(c *gin.Context) {
+	scheduleID, ok := pathID(c, "schedule_id", "Invalid schedule ID")
+	if !ok {
+		return
+	}
+
+	schedule, err := h.Service.CancelSchedule(c.Request.Context(), scheduleID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// GetScheduleRuns handles GET /schedules/:schedule_id/runs, newest run first
+func (h *WalletHandler) GetScheduleRuns(c *gin.Context) {
+	scheduleID, ok := pathID(c, "schedule_id", "Invalid schedule ID")
+	if !ok {
+		return
+	}
+
+	runs, err := h.Service.ScheduleRuns(c.Request.Context(), scheduleID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
@@ -0,0 +1,283 @@
+package handles
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"signing"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultSignatureSkew is how far the timestamp of a signed request may be
+// from the server clock when SignatureVerifier.Skew is not set.
+const DefaultSignatureSkew = 5 * time.Minute
+
+const (
+	// maxSignedBody is the largest body read to check a signature
+	maxSignedBody = 1 << 20
+	minSigningKey = 32
+	maxNonce      = 128
+	minNonce      = 16
+	signingKeyKey = "signing_key"
+)
+
+// SigningKey is a shared secret callers sign requests with. Several keys
+// are accepted at once so callers can move to a new one before the old one
+// expires.
+type SigningKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	// Subject is the principal the key belongs to, api_key:<id> or the sub
+	// of a token, nobody else can sign with it
+	Subject string `json:"subject"`
+	// ExpiresAt is when the key stops being accepted, never when nil
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LoadSigningKeys reads a json file of the form {"keys": [{"id": "partner-1",
+// "secret": "...", "subject": "api_key:7", "expires_at": "2025-01-01T00:00:00Z"}]}.
+func LoadSigningKeys(path string) ([]SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("signing keys: reading %s: %w", path, err)
+	}
+	var raw struct {
+		Keys []SigningKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("signing keys: parsing %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for _, key := range raw.Keys {
+		if key.ID == "" || seen[key.ID] {
+			return nil, fmt.Errorf("signing keys: %s: key ids must be set and unique, %q is not", path, key.ID)
+		}
+		if len(key.Secret) < minSigningKey {
+			return nil, fmt.Errorf("signing keys: %s: secret of %q must be at least %d bytes", path, key.ID, minSigningKey)
+		}
+		if key.Subject == "" {
+			return nil, fmt.Errorf("signing keys: %s: key %q needs the subject it belongs to", path, key.ID)
+		}
+		seen[key.ID] = true
+	}
+	return raw.Keys, nil
+}
+
+// NonceStore remembers the nonces of signed requests. MemoryNonceStore keeps
+// them in the process, with several instances a shared store is needed to
+// refuse a request replayed against another instance.
+type NonceStore interface {
+	// Remember stores nonce until the given time and returns false when it
+	// is already stored.
+	Remember(ctx context.Context, nonce string, until time.Time) (bool, error)
+}
+
+/*
+SignatureVerifier checks the HMAC-SHA256 signatures of the signing package,
+refusing unknown or expired keys, timestamps further than Skew from the
+server clock and nonces seen before with 401 invalid_signature. NewRouter
+runs Verify ahead of Authenticate and CheckOwner right after it, which
+refuses a key signing for a principal other than its Subject. Requests
+without a signature pass, unless Required is set and they authenticate with
+an API key, the way server to server callers do, or as the subject of a
+signing key. A nil SignatureVerifier checks nothing.
+*/
+type SignatureVerifier struct {
+	Keys     []SigningKey
+	Nonces   NonceStore
+	Skew     time.Duration
+	Required bool
+	// Now is the clock timestamps are checked against, time.Now when nil
+	Now func() time.Time
+}
+
+// Verify is the middleware checking request signatures.
+func (v *SignatureVerifier) Verify(c *gin.Context) {
+	if v == nil {
+		c.Next()
+		return
+	}
+	signature := c.GetHeader(signing.HeaderSignature)
+	if signature == "" {
+		c.Next()
+		return
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	keyID := c.GetHeader(signing.HeaderKeyID)
+	key, ok := v.key(keyID)
+	if !ok {
+		abortInvalidSignature(c, fmt.Sprintf("unknown signing key %q", keyID))
+		return
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		abortInvalidSignature(c, fmt.Sprintf("signing key %q expired", keyID))
+		return
+	}
+
+	timestamp := c.GetHeader(signing.HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		abortInvalidSignature(c, "timestamp must be unix seconds")
+		return
+	}
+	signedAt := time.Unix(unix, 0)
+	skew := v.skew()
+	if signedAt.Before(now.Add(-skew)) || signedAt.After(now.Add(skew)) {
+		abortInvalidSignature(c, fmt.Sprintf("timestamp is more than %s off the server clock", skew))
+		return
+	}
+	nonce := c.GetHeader(signing.HeaderNonce)
+	if len(nonce) < minNonce || len(nonce) > maxNonce {
+		abortInvalidSignature(c, fmt.Sprintf("nonce must be %d to %d characters", minNonce, maxNonce))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBody+1))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "reading body: "+err.Error())
+		return
+	}
+	if len(body) > maxSignedBody {
+		abortWithError(c, http.StatusRequestEntityTooLarge, CodeInvalidRequest, fmt.Sprintf("signed bodies are limited to %d bytes", maxSignedBody))
+		return
+	}
+	//the handlers read the body again
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := signing.Sign([]byte(key.Secret), c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		abortInvalidSignature(c, "signature does not match the request")
+		return
+	}
+
+	//only nonces of valid signatures are stored, nobody else can use them up.
+	//a nonce has to be remembered for as long as its timestamp is accepted
+	if v.Nonces != nil {
+		fresh, err := v.Nonces.Remember(c.Request.Context(), keyID+":"+nonce, signedAt.Add(skew))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if !fresh {
+			abortInvalidSignature(c, "nonce was already used")
+			return
+		}
+	}
+	c.Set(signingKeyKey, keyID)
+	c.Next()
+}
+
+// CheckOwner is the middleware NewRouter puts right after Authenticate, a
+// valid signature only counts for the principal its key belongs to.
+func (v *SignatureVerifier) CheckOwner(c *gin.Context) {
+	if v == nil {
+		c.Next()
+		return
+	}
+	principal, _ := PrincipalFrom(c)
+	keyID, signed := SigningKeyFrom(c)
+	if !signed {
+		if v.Required && (principal.Method == AuthAPIKey || v.hasSubject(principal.Subject)) {
+			abortInvalidSignature(c, "requests of "+principal.Subject+" must be signed")
+			return
+		}
+		c.Next()
+		return
+	}
+	if key, _ := v.key(keyID); key.Subject != principal.Subject {
+		abortInvalidSignature(c, fmt.Sprintf("signing key %q does not belong to the caller", keyID))
+		return
+	}
+	c.Next()
+}
+
+func (v *SignatureVerifier) hasSubject(subject string) bool {
+	for _, key := range v.Keys {
+		if key.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *SignatureVerifier) key(id string) (SigningKey, bool) {
+	for _, key := range v.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+func (v *SignatureVerifier) skew() time.Duration {
+	if v.Skew > 0 {
+		return v.Skew
+	}
+	return DefaultSignatureSkew
+}
+
+func abortInvalidSignature(c *gin.Context, message string) {
+	abortWithError(c, http.StatusUnauthorized, CodeInvalidSignature, message)
+}
+
+// SigningKeyFrom returns the id of the key the request was signed with,
+// false for requests that were not signed.
+func SigningKeyFrom(c *gin.Context) (string, bool) {
+	id := c.GetString(signingKeyKey)
+	return id, id != ""
+}
+
+// MemoryNonceStore keeps nonces in the process until they expire.
+type MemoryNonceStore struct {
+	// Now is the clock nonces expire by, time.Now when nil
+	Now func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryNonceStore returns an empty MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+// Remember implements NonceStore.
+func (m *MemoryNonceStore) Remember(ctx context.Context, nonce string, until time.Time) (bool, error) {
+	now := time.Now()
+	if m.Now != nil {
+		now = m.Now()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nonces == nil {
+		m.nonces = map[string]time.Time{}
+	}
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		m.lastSweep = now
+		for n, expires := range m.nonces {
+			if !now.Before(expires) {
+				delete(m.nonces, n)
+			}
+		}
+	}
+
+	if expires, ok := m.nonces[nonce]; ok && now.Before(expires) {
+		return false, nil
+	}
+	m.nonces[nonce] = until
+	return true, nil
+}
@@ -0,0 +1,117 @@
+package handles
+
+import (
+	"net/http"
+	"services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	Service *services.UserService
+}
+
+func NewUserHandler(service *services.UserService) *UserHandler {
+	return &UserHandler{Service: service}
+}
+
+// RegisterRoutes mounts the user endpoints on the given router group.
+func (h *UserHandler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("/users", RequirePermission(services.PermUsersCreate), h.CreateUser)
+	rg.GET("/users", RequirePermission(services.PermUsersList), h.ListUsers)
+	rg.GET("/users/:id", RequirePermission(services.PermProfileRead), h.GetUser)
+	rg.PATCH("/users/:id", RequirePermission(services.PermProfileWrite), h.UpdateUser)
+	rg.GET("/users/:id/api-keys", RequirePermission(services.PermProfileRead), h.ListAPIKeys)
+	rg.GET("/users/:id/roles", RequirePermission(services.PermProfileRead), h.GetUserRoles)
+
+	keys := rg.Group("/admin/api-keys", RequirePermission(services.PermAPIKeysManage))
+	keys.POST("", h.CreateAPIKey)
+	keys.DELETE("/:key_id", h.RevokeAPIKey)
+
+	roles := rg.Group("/admin/users/:id/roles/:role", RequirePermission(services.PermRolesManage))
+	roles.PUT("", h.AssignRole)
+	roles.DELETE("", h.RevokeRole)
+}
+
+// CreateUser creates a user and its default wallet, returning both ids
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var request struct {
+		Name     string `json:"name" binding:"required"`
+		Currency string `json:"currency"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	user, walletID, err := h.Service.CreateUser(c.Request.Context(), request.Name, request.Currency)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"user": user, "wallet_id": walletID})
+}
+
+func (h *UserHandler) GetUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := h.Service.GetUser(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// ListUsers supports ?limit= and ?offset= for paging
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > services.MaxListUsers {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(services.MaxListUsers))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "offset must not be negative")
+		return
+	}
+
+	users, err := h.Service.ListUsers(c.Request.Context(), limit, offset)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+		return
+	}
+
+	var request struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.Service.UpdateUser(c.Request.Context(), id, request.Name)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"config"
+	"fmt"
+	"services"
+)
+
+// runLedger implements "ledger check", it exits non-zero when an invariant is broken
+func runLedger(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: ledger check [config flags]")
+	}
+
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		return err
+	}
+	db, err := config.InitDB(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	service := &services.WalletService{DB: db}
+	report, err := service.CheckLedger()
+	if err != nil {
+		return err
+	}
+
+	for currency, total := range report.Totals {
+		fmt.Printf("sum of all %s postings: %s\n", currency, total)
+	}
+	for _, id := range report.UnbalancedTransactions {
+		fmt.Printf("transaction %d is unbalanced\n", id)
+	}
+	for _, m := range report.MismatchedWallets {
+		fmt.Printf("wallet %d balance %s differs from ledger balance %s\n", m.WalletID, m.Balance, m.LedgerBalance)
+	}
+	if !report.OK() {
+		return fmt.Errorf("ledger invariants violated")
+	}
+	fmt.Println("ledger is balanced")
+	return nil
+}
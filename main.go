@@ -2,18 +2,264 @@ package main
 
 import (
 	"config"
-	"fmt"
+	"context"
+	"errors"
+	"handles"
+	"log"
+	"migrations"
+	"net/http"
+	"os"
+	"os/signal"
 	"services"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// holdExpiryInterval is how often holds past their expiry are released
+const holdExpiryInterval = time.Minute
+
+// scheduleInterval is how often due scheduled transfers are looked for,
+// scheduleBatch how many of them one pass claims
+const (
+	scheduleInterval = time.Minute
+	scheduleBatch    = 100
 )
 
 func main() {
-	db, err := config.InitDB()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("migrate err: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := runAPIKey(os.Args[2:]); err != nil {
+			log.Fatalf("apikey err: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ledger" {
+		if err := runLedger(os.Args[2:]); err != nil {
+			log.Fatalf("ledger err: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		if err := runAudit(os.Args[2:]); err != nil {
+			log.Fatalf("audit err: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("config err: %v", err)
+	}
+
+	db, err := config.InitDB(cfg.DB)
 	if err != nil {
-		fmt.Printf("db config err: %v\n", err)
+		log.Fatalf("db config err: %v", err)
 	}
-	fmt.Printf("db config ok...")
 
-	service := services.WalletService{DB: db}
+	if err := migrations.Verify(db, services.SchemaVersion); err != nil {
+		db.Close()
+		log.Fatalf("schema check err: %v", err)
+	}
+
+	walletService := &services.WalletService{DB: db, QuoteTTL: cfg.FX.QuoteTTL.Duration}
+	//without a rates file quotes are refused, same-currency transfers still work
+	if cfg.FX.RatesFile != "" {
+		rates, err := services.LoadStaticRates(cfg.FX.RatesFile)
+		if err != nil {
+			db.Close()
+			log.Fatalf("fx rates err: %v", err)
+		}
+		walletService.FX = rates
+	}
+	//without a fee schedule withdrawals and transfers are free
+	if cfg.Fees.ScheduleFile != "" {
+		schedule, err := services.LoadFeeSchedule(cfg.Fees.ScheduleFile)
+		if err != nil {
+			db.Close()
+			log.Fatalf("fee schedule err: %v", err)
+		}
+		walletService.Fees = schedule
+	}
+
+	userService := &services.UserService{DB: db}
+	auth := &handles.Authenticator{
+		JWT: handles.JWTConfig{
+			HMACSecret: []byte(cfg.Auth.JWTSecret),
+			Issuer:     cfg.Auth.JWTIssuer,
+			Audience:   cfg.Auth.JWTAudience,
+		},
+		APIKeys: userService,
+		Owners:  walletService,
+		Roles:   userService,
+	}
+	if cfg.Auth.JWTPublicKeyFile != "" {
+		key, err := handles.LoadRSAPublicKey(cfg.Auth.JWTPublicKeyFile)
+		if err != nil {
+			db.Close()
+			log.Fatalf("jwt key err: %v", err)
+		}
+		auth.JWT.RSAPublicKey = key
+	}
+	//without signing keys signatures are not checked
+	if cfg.Auth.SigningKeysFile != "" {
+		keys, err := handles.LoadSigningKeys(cfg.Auth.SigningKeysFile)
+		if err != nil {
+			db.Close()
+			log.Fatalf("signing keys err: %v", err)
+		}
+		auth.Signatures = &handles.SignatureVerifier{
+			Keys:     keys,
+			Nonces:   handles.NewMemoryNonceStore(),
+			Skew:     cfg.Auth.SignatureSkew.Duration,
+			Required: cfg.Auth.RequireSignatures,
+		}
+	}
+
+	walletHandler := handles.NewWalletHandler(walletService)
+	userHandler := handles.NewUserHandler(userService)
+
+	router := handles.NewRouter(auth, rateLimiter(cfg.RateLimits), walletHandler, userHandler)
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		db.Close()
+		log.Fatalf("trusted proxies err: %v", err)
+	}
 
-	fmt.Printf("init service ok...:%v", service)
+	srv := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout.Duration,
+		WriteTimeout: cfg.Server.WriteTimeout.Duration,
+		IdleTimeout:  cfg.Server.IdleTimeout.Duration,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("wallet service listening on %s", cfg.Server.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("http server err: %v", err)
+		}
+	}()
+
+	//the background jobs stop starting passes on the signal, the pass in
+	//flight runs on workCtx which is only cancelled once the shutdown timeout
+	//is up
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		expireHolds(ctx, workCtx, walletService)
+	}()
+	go func() {
+		defer workers.Done()
+		runSchedules(ctx, workCtx, walletService)
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("shutting down, waiting for in-flight requests and jobs...")
+
+	//Shutdown stops accepting new connections and blocks until every active
+	//request has returned, so a transfer that already started gets to commit
+	//before we close the db below
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http server shutdown err: %v", err)
+	}
+
+	//the same goes for a hold sweep or scheduled transfer pass, it gets what
+	//is left of the shutdown timeout to finish before the pool is closed
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		log.Printf("background jobs did not finish in time, cancelling them")
+		cancelWork()
+		<-done
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("db close err: %v", err)
+	}
+	log.Printf("wallet service stopped")
+}
+
+// rateLimiter builds the limiter of the configured route groups, limits hold
+// per instance with the in-memory store
+func rateLimiter(groups map[string]config.RouteLimitsConfig) *handles.RateLimiter {
+	limit := func(l config.RateLimitConfig) handles.Limit {
+		return handles.Limit{Requests: l.Requests, Per: l.Per.Duration, Burst: l.Burst}
+	}
+	limiter := &handles.RateLimiter{Store: handles.NewMemoryStore(), Groups: map[string]handles.RouteLimits{}}
+	for group, limits := range groups {
+		limiter.Groups[group] = handles.RouteLimits{
+			Principal: limit(limits.Principal),
+			IP:        limit(limits.IP),
+			Wallet:    limit(limits.Wallet),
+		}
+	}
+	return limiter
+}
+
+// expireHolds releases stale holds every holdExpiryInterval until stop is
+// done, a sweep runs on ctx. Every instance may run it, the sweep skips
+// wallets another one has locked.
+func expireHolds(stop, ctx context.Context, walletService *services.WalletService) {
+	ticker := time.NewTicker(holdExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+			n, err := walletService.ExpireHolds(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("hold expiry err: %v", err)
+			} else if n > 0 {
+				log.Printf("released %d expired holds", n)
+			}
+		}
+	}
+}
+
+// runSchedules books due scheduled transfers every scheduleInterval until
+// stop is done, a pass runs on ctx. Like expireHolds it is safe on every
+// instance, a pass keeps claiming batches until nothing is due so a backlog
+// clears in one tick, but no new batch is claimed once stop is done.
+func runSchedules(stop, ctx context.Context, walletService *services.WalletService) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop.Done():
+			return
+		case <-ticker.C:
+			for stop.Err() == nil {
+				n, err := walletService.RunDueSchedules(ctx, time.Now(), scheduleBatch)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("scheduled transfers err: %v", err)
+				}
+				if n > 0 {
+					log.Printf("made %d scheduled transfer runs", n)
+				}
+				if err != nil || n < scheduleBatch {
+					break
+				}
+			}
+		}
+	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"config"
+	"fmt"
+	"migrations"
+	"strconv"
+)
+
+/*
+runMigrate implements "migrate up|down [steps]|status", the remaining
+arguments are the usual config flags so the same dsn settings apply.
+*/
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status [config flags]")
+	}
+	action, args := args[0], args[1:]
+
+	steps := 1
+	if action == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			steps, args = n, args[1:]
+		}
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+	db, err := config.InitDB(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch action {
+	case "up":
+		applied, err := migrations.Up(db)
+		for _, v := range applied {
+			fmt.Printf("applied %d\n", v)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		reverted, err := migrations.Down(db, steps)
+		for _, v := range reverted {
+			fmt.Printf("reverted %d\n", v)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		statuses, err := migrations.Status(db)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-32s %s\n", st.Version, st.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q, use up, down or status", action)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the advisory lock key held while migrating, so two instances
+// starting at the same time do not apply the same migration twice
+const lockID = 724_311_001
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change with its up and down scripts.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied and when.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// All returns every embedded migration ordered by version.
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: unexpected file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		body, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	var all []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: version %d needs both an up and a down file", m.Version)
+		}
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+
+	//versions must be 1..n without gaps so "latest" is unambiguous
+	for i, m := range all {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrations: expected version %d, found %d", i+1, m.Version)
+		}
+	}
+	return all, nil
+}
+
+// Latest returns the highest embedded migration version.
+func Latest() (int, error) {
+	all, err := All()
+	if err != nil {
+		return 0, err
+	}
+	if len(all) == 0 {
+		return 0, nil
+	}
+	return all[len(all)-1].Version, nil
+}
+
+func ensureTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(128) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// CurrentVersion returns the highest applied version, 0 for an empty database.
+func CurrentVersion(db *sql.DB) (int, error) {
+	if err := ensureTable(db); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// Up applies every pending migration, each one in its own transaction,
+// and returns the versions it applied.
+func Up(db *sql.DB) ([]int, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []int
+	for _, m := range all {
+		if m.Version <= current {
+			continue
+		}
+		ok, err := apply(db, m, true)
+		if err != nil {
+			return applied, fmt.Errorf("migrations: up %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m.Version)
+		}
+	}
+	return applied, nil
+}
+
+// Down rolls back the given number of most recent migrations and returns
+// the versions it reverted.
+func Down(db *sql.DB, steps int) ([]int, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []int
+	for i := len(all) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := all[i]
+		if m.Version > current {
+			continue
+		}
+		ok, err := apply(db, m, false)
+		if err != nil {
+			return reverted, fmt.Errorf("migrations: down %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			reverted = append(reverted, m.Version)
+		}
+	}
+	return reverted, nil
+}
+
+/*
+apply runs the up or down script of m together with its schema_migrations
+bookkeeping in one transaction while holding the advisory lock. The applied
+state is re-checked after taking the lock, so a migration another instance
+finished in the meantime is skipped and false is returned.
+*/
+func apply(db *sql.DB, m Migration, up bool) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", lockID); err != nil {
+		return false, err
+	}
+
+	var applied bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&applied)
+	if err != nil {
+		return false, err
+	}
+	if applied == up {
+		return false, nil
+	}
+
+	if up {
+		if _, err := tx.Exec(m.Up); err != nil {
+			return false, err
+		}
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+	} else {
+		if _, err := tx.Exec(m.Down); err != nil {
+			return false, err
+		}
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Status lists every embedded migration and when it was applied.
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(all))
+	for _, m := range all {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := appliedAt[m.Version]; ok {
+			at := at
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Verify fails unless the database schema is exactly at version expected.
+func Verify(db *sql.DB, expected int) error {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	if current != expected {
+		return fmt.Errorf("migrations: schema is at version %d but version %d is required, run \"migrate up\"", current, expected)
+	}
+	return nil
+}
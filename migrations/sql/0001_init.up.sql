@@ -0,0 +1,25 @@
+-- users, wallets and transactions as they used to be created by hand from the README,
+-- IF NOT EXISTS lets databases set up that way adopt the migrations without changes
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(128) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS wallets (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    balance NUMERIC(20, 4) NOT NULL DEFAULT 0,
+    CHECK (balance >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id),
+    type VARCHAR(32) NOT NULL,
+    amount NUMERIC(20, 4) NOT NULL,
+    to_user_id INT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS wallets_user_id_idx ON wallets (user_id);
+CREATE INDEX IF NOT EXISTS transactions_user_id_created_at_idx ON transactions (user_id, created_at);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS wallets_open_account ON wallets;
+DROP FUNCTION IF EXISTS open_wallet_account();
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS accounts;
+DELETE FROM transactions WHERE type = 'opening_balance';
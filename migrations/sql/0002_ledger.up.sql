@@ -0,0 +1,72 @@
+-- every wallet gets a ledger account, money coming from or leaving to the outside
+-- world is booked against system accounts so all postings always sum to zero
+CREATE TABLE accounts (
+    id SERIAL PRIMARY KEY,
+    wallet_id INT UNIQUE REFERENCES wallets(id),
+    code VARCHAR(64) UNIQUE,
+    kind VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (kind IN ('wallet', 'system')),
+    CHECK ((kind = 'wallet' AND wallet_id IS NOT NULL AND code IS NULL)
+        OR (kind = 'system' AND wallet_id IS NULL AND code IS NOT NULL))
+);
+
+-- a positive amount increases the balance of the account, a negative one decreases it
+CREATE TABLE postings (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL REFERENCES transactions(id),
+    account_id INT NOT NULL REFERENCES accounts(id),
+    amount NUMERIC(20, 4) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (amount <> 0)
+);
+
+CREATE INDEX postings_account_id_idx ON postings (account_id, created_at, id);
+CREATE INDEX postings_transaction_id_idx ON postings (transaction_id);
+
+INSERT INTO accounts (code, kind) VALUES
+    ('external:deposits', 'system'),
+    ('external:withdrawals', 'system'),
+    ('equity:opening_balances', 'system');
+
+-- wallets inserted by hand or by older code still get an account, and a balance
+-- they start with is booked against the opening balances account
+CREATE FUNCTION open_wallet_account() RETURNS TRIGGER AS $$
+DECLARE
+    account INT;
+    opening INT;
+BEGIN
+    INSERT INTO accounts (wallet_id, kind) VALUES (NEW.id, 'wallet') RETURNING id INTO account;
+    IF NEW.balance <> 0 THEN
+        INSERT INTO transactions (user_id, type, amount)
+            VALUES (NEW.user_id, 'opening_balance', NEW.balance) RETURNING id INTO opening;
+        INSERT INTO postings (transaction_id, account_id, amount) VALUES
+            (opening, account, NEW.balance),
+            (opening, (SELECT id FROM accounts WHERE code = 'equity:opening_balances'), -NEW.balance);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER wallets_open_account AFTER INSERT ON wallets
+    FOR EACH ROW EXECUTE FUNCTION open_wallet_account();
+
+-- backfill accounts and opening balances for the wallets that already exist
+DO $$
+DECLARE
+    w RECORD;
+    account INT;
+    opening INT;
+BEGIN
+    FOR w IN SELECT id, user_id, balance FROM wallets ORDER BY id LOOP
+        INSERT INTO accounts (wallet_id, kind) VALUES (w.id, 'wallet') RETURNING id INTO account;
+        IF w.balance <> 0 THEN
+            INSERT INTO transactions (user_id, type, amount)
+                VALUES (w.user_id, 'opening_balance', w.balance) RETURNING id INTO opening;
+            INSERT INTO postings (transaction_id, account_id, amount) VALUES
+                (opening, account, w.balance),
+                (opening, (SELECT id FROM accounts WHERE code = 'equity:opening_balances'), -w.balance);
+        END IF;
+    END LOOP;
+END;
+$$;
@@ -0,0 +1,11 @@
+-- a key is stored in the same transaction as the balance change it protects,
+-- together with the response that was returned so retries can replay it
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    fingerprint CHAR(64) NOT NULL,
+    response_status INT NOT NULL,
+    response_body BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
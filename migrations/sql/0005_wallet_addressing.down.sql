@@ -0,0 +1,26 @@
+CREATE OR REPLACE FUNCTION open_wallet_account() RETURNS TRIGGER AS $$
+DECLARE
+    account INT;
+    opening INT;
+BEGIN
+    INSERT INTO accounts (wallet_id, kind) VALUES (NEW.id, 'wallet') RETURNING id INTO account;
+    IF NEW.balance <> 0 THEN
+        INSERT INTO transactions (user_id, type, amount)
+            VALUES (NEW.user_id, 'opening_balance', NEW.balance) RETURNING id INTO opening;
+        INSERT INTO postings (transaction_id, account_id, amount) VALUES
+            (opening, account, NEW.balance),
+            (opening, (SELECT id FROM accounts WHERE code = 'equity:opening_balances'), -NEW.balance);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS transactions_wallet_id_created_at_idx;
+ALTER TABLE transactions
+    DROP COLUMN IF EXISTS wallet_id,
+    DROP COLUMN IF EXISTS to_wallet_id;
+
+DROP TRIGGER IF EXISTS wallets_default_flag ON wallets;
+DROP FUNCTION IF EXISTS set_default_wallet();
+DROP INDEX IF EXISTS wallets_one_default_per_user;
+ALTER TABLE wallets DROP COLUMN IF EXISTS is_default;
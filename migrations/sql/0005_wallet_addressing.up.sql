@@ -0,0 +1,52 @@
+-- users can own several wallets, one of them is the default used by the user id routes
+ALTER TABLE wallets ADD COLUMN is_default BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE wallets SET is_default = TRUE
+WHERE id IN (SELECT MIN(id) FROM wallets GROUP BY user_id);
+
+CREATE UNIQUE INDEX wallets_one_default_per_user ON wallets (user_id) WHERE is_default;
+
+-- the first wallet of a user becomes the default one, however it was inserted
+CREATE FUNCTION set_default_wallet() RETURNS TRIGGER AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM wallets WHERE user_id = NEW.user_id AND is_default) THEN
+        NEW.is_default := TRUE;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER wallets_default_flag BEFORE INSERT ON wallets
+    FOR EACH ROW EXECUTE FUNCTION set_default_wallet();
+
+-- transactions are now recorded against wallets, user ids are kept for the owners
+ALTER TABLE transactions
+    ADD COLUMN wallet_id INT REFERENCES wallets(id),
+    ADD COLUMN to_wallet_id INT REFERENCES wallets(id);
+
+UPDATE transactions t SET wallet_id = w.id
+FROM wallets w
+WHERE w.user_id = t.user_id AND w.is_default;
+
+UPDATE transactions t SET to_wallet_id = w.id
+FROM wallets w
+WHERE t.to_user_id IS NOT NULL AND w.user_id = t.to_user_id AND w.is_default;
+
+CREATE INDEX transactions_wallet_id_created_at_idx ON transactions (wallet_id, created_at);
+
+CREATE OR REPLACE FUNCTION open_wallet_account() RETURNS TRIGGER AS $$
+DECLARE
+    account INT;
+    opening INT;
+BEGIN
+    INSERT INTO accounts (wallet_id, kind) VALUES (NEW.id, 'wallet') RETURNING id INTO account;
+    IF NEW.balance <> 0 THEN
+        INSERT INTO transactions (user_id, wallet_id, type, amount)
+            VALUES (NEW.user_id, NEW.id, 'opening_balance', NEW.balance) RETURNING id INTO opening;
+        INSERT INTO postings (transaction_id, account_id, amount) VALUES
+            (opening, account, NEW.balance),
+            (opening, (SELECT id FROM accounts WHERE code = 'equity:opening_balances'), -NEW.balance);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
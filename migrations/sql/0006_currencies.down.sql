@@ -0,0 +1,21 @@
+CREATE OR REPLACE FUNCTION open_wallet_account() RETURNS TRIGGER AS $$
+DECLARE
+    account INT;
+    opening INT;
+BEGIN
+    INSERT INTO accounts (wallet_id, kind) VALUES (NEW.id, 'wallet') RETURNING id INTO account;
+    IF NEW.balance <> 0 THEN
+        INSERT INTO transactions (user_id, wallet_id, type, amount)
+            VALUES (NEW.user_id, NEW.id, 'opening_balance', NEW.balance) RETURNING id INTO opening;
+        INSERT INTO postings (transaction_id, account_id, amount) VALUES
+            (opening, account, NEW.balance),
+            (opening, (SELECT id FROM accounts WHERE code = 'equity:opening_balances'), -NEW.balance);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE postings DROP COLUMN IF EXISTS currency;
+ALTER TABLE transactions DROP COLUMN IF EXISTS currency;
+ALTER TABLE wallets DROP CONSTRAINT IF EXISTS wallets_currency_iso;
+ALTER TABLE wallets DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,28 @@
+-- every wallet holds a single ISO 4217 currency, existing wallets are USD
+ALTER TABLE wallets ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE wallets ADD CONSTRAINT wallets_currency_iso CHECK (currency ~ '^[A-Z]{3}$');
+
+-- transactions and postings carry the currency they were booked in, journals
+-- must balance per currency so there is no default to fall back on
+ALTER TABLE transactions ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE transactions ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE postings ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE postings ALTER COLUMN currency DROP DEFAULT;
+
+CREATE OR REPLACE FUNCTION open_wallet_account() RETURNS TRIGGER AS $$
+DECLARE
+    account INT;
+    opening INT;
+BEGIN
+    INSERT INTO accounts (wallet_id, kind) VALUES (NEW.id, 'wallet') RETURNING id INTO account;
+    IF NEW.balance <> 0 THEN
+        INSERT INTO transactions (user_id, wallet_id, type, amount, currency)
+            VALUES (NEW.user_id, NEW.id, 'opening_balance', NEW.balance, NEW.currency) RETURNING id INTO opening;
+        INSERT INTO postings (transaction_id, account_id, amount, currency) VALUES
+            (opening, account, NEW.balance, NEW.currency),
+            (opening, (SELECT id FROM accounts WHERE code = 'equity:opening_balances'), -NEW.balance, NEW.currency);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
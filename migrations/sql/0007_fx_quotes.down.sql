@@ -0,0 +1,9 @@
+DELETE FROM accounts WHERE code = 'fx:conversion';
+
+ALTER TABLE transactions
+    DROP COLUMN quote_id,
+    DROP COLUMN fx_rate,
+    DROP COLUMN to_currency,
+    DROP COLUMN to_amount;
+
+DROP TABLE fx_quotes;
@@ -0,0 +1,25 @@
+-- a quote locks an exchange rate until expires_at, it can be used by one
+-- conversion only
+CREATE TABLE fx_quotes (
+    id VARCHAR(32) PRIMARY KEY,
+    from_currency CHAR(3) NOT NULL,
+    to_currency CHAR(3) NOT NULL,
+    rate NUMERIC(24, 10) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (rate > 0),
+    CHECK (from_currency <> to_currency)
+);
+
+-- a converting transfer records what left the sender and what reached the
+-- receiver, amount and currency stay the source side
+ALTER TABLE transactions
+    ADD COLUMN to_amount NUMERIC(20, 4),
+    ADD COLUMN to_currency CHAR(3),
+    ADD COLUMN fx_rate NUMERIC(24, 10),
+    ADD COLUMN quote_id VARCHAR(32) REFERENCES fx_quotes(id);
+
+-- both legs of a conversion go through this account, so every currency
+-- still balances on its own
+INSERT INTO accounts (code, kind) VALUES ('fx:conversion', 'system');
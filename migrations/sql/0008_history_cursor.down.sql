@@ -0,0 +1,2 @@
+DROP INDEX transactions_wallet_id_created_at_id_idx;
+CREATE INDEX transactions_wallet_id_created_at_idx ON transactions (wallet_id, created_at);
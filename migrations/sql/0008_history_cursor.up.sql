@@ -0,0 +1,4 @@
+-- history pages are read by (created_at, id) after a cursor, id breaks ties
+-- between transactions booked in the same microsecond
+DROP INDEX transactions_wallet_id_created_at_idx;
+CREATE INDEX transactions_wallet_id_created_at_id_idx ON transactions (wallet_id, created_at, id);
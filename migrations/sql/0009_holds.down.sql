@@ -0,0 +1,6 @@
+ALTER TABLE transactions DROP COLUMN hold_id;
+
+ALTER TABLE wallets DROP CONSTRAINT wallets_held_covered;
+ALTER TABLE wallets DROP COLUMN held;
+
+DROP TABLE holds;
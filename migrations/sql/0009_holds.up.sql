@@ -0,0 +1,25 @@
+-- a hold reserves part of a wallet balance until it is captured, voided or
+-- expires, it never touches the ledger. wallets.held caches the sum of the
+-- active holds of a wallet and is kept in the same db transaction
+CREATE TABLE holds (
+    id SERIAL PRIMARY KEY,
+    wallet_id INT NOT NULL REFERENCES wallets(id),
+    amount NUMERIC(20, 4) NOT NULL,
+    captured_amount NUMERIC(20, 4),
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (amount > 0),
+    CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+    CHECK (captured_amount IS NULL OR (captured_amount > 0 AND captured_amount <= amount))
+);
+
+CREATE INDEX holds_wallet_id_idx ON holds (wallet_id);
+CREATE INDEX holds_active_expires_at_idx ON holds (expires_at) WHERE status = 'active';
+
+ALTER TABLE wallets ADD COLUMN held NUMERIC(20, 4) NOT NULL DEFAULT 0;
+ALTER TABLE wallets ADD CONSTRAINT wallets_held_covered CHECK (held >= 0 AND held <= balance);
+
+-- a capture is booked as a withdrawal or transfer that points at its hold
+ALTER TABLE transactions ADD COLUMN hold_id INT REFERENCES holds(id);
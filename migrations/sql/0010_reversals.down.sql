@@ -0,0 +1,7 @@
+DROP INDEX transactions_reverses_id_idx;
+
+ALTER TABLE transactions DROP CONSTRAINT transactions_reversal_link;
+
+ALTER TABLE transactions
+    DROP COLUMN reason,
+    DROP COLUMN reverses_id;
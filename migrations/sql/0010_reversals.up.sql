@@ -0,0 +1,10 @@
+-- a reversal is a transaction of its own whose postings mirror the original,
+-- several partial reversals may point at the same original
+ALTER TABLE transactions
+    ADD COLUMN reverses_id INT REFERENCES transactions(id),
+    ADD COLUMN reason TEXT;
+
+ALTER TABLE transactions ADD CONSTRAINT transactions_reversal_link
+    CHECK ((type = 'reversal') = (reverses_id IS NOT NULL));
+
+CREATE INDEX transactions_reverses_id_idx ON transactions (reverses_id) WHERE reverses_id IS NOT NULL;
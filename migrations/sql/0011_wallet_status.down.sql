@@ -0,0 +1,4 @@
+DROP TABLE wallet_status_events;
+
+ALTER TABLE wallets DROP CONSTRAINT wallets_status_check;
+ALTER TABLE wallets DROP COLUMN status;
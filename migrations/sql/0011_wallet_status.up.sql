@@ -0,0 +1,17 @@
+-- active wallets move money freely, frozen ones can still receive but not
+-- send and closed ones do neither. closed is final
+ALTER TABLE wallets ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active';
+ALTER TABLE wallets ADD CONSTRAINT wallets_status_check CHECK (status IN ('active', 'frozen', 'closed'));
+
+-- every change of a wallet status, with who made it and why
+CREATE TABLE wallet_status_events (
+    id SERIAL PRIMARY KEY,
+    wallet_id INT NOT NULL REFERENCES wallets(id),
+    from_status VARCHAR(16) NOT NULL,
+    to_status VARCHAR(16) NOT NULL,
+    actor VARCHAR(128) NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX wallet_status_events_wallet_id_idx ON wallet_status_events (wallet_id, id);
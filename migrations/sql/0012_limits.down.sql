@@ -0,0 +1,3 @@
+DROP TABLE wallet_limits;
+
+ALTER TABLE wallets DROP COLUMN tier;
@@ -0,0 +1,25 @@
+-- every wallet belongs to a tier, the limits of its tier apply unless the
+-- wallet has limits of its own
+ALTER TABLE wallets ADD COLUMN tier VARCHAR(32) NOT NULL DEFAULT 'standard';
+
+-- a row either sets the limits of a tier for one currency or those of a
+-- single wallet, a null column means that limit is not enforced
+CREATE TABLE wallet_limits (
+    id SERIAL PRIMARY KEY,
+    wallet_id INT REFERENCES wallets(id),
+    tier VARCHAR(32),
+    currency CHAR(3),
+    max_per_transaction NUMERIC(20, 4),
+    daily_amount NUMERIC(20, 4),
+    monthly_amount NUMERIC(20, 4),
+    daily_count INT,
+    monthly_count INT,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((wallet_id IS NULL) <> (tier IS NULL)),
+    CHECK ((tier IS NULL) = (currency IS NULL)),
+    CHECK (max_per_transaction >= 0 AND daily_amount >= 0 AND monthly_amount >= 0),
+    CHECK (daily_count >= 0 AND monthly_count >= 0)
+);
+
+CREATE UNIQUE INDEX wallet_limits_wallet_id_idx ON wallet_limits (wallet_id) WHERE wallet_id IS NOT NULL;
+CREATE UNIQUE INDEX wallet_limits_tier_currency_idx ON wallet_limits (tier, currency) WHERE tier IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE transactions DROP CONSTRAINT transactions_fee_positive;
+ALTER TABLE transactions DROP COLUMN fee;
+
+DELETE FROM accounts WHERE code = 'fees:revenue';
@@ -0,0 +1,7 @@
+-- fees are booked as an extra leg of the withdrawal or transfer they are
+-- charged on, the sender pays amount + fee and the fee lands here
+INSERT INTO accounts (code, kind) VALUES ('fees:revenue', 'system');
+
+-- the fee charged on top of amount, null when the operation was free
+ALTER TABLE transactions ADD COLUMN fee NUMERIC(20, 4);
+ALTER TABLE transactions ADD CONSTRAINT transactions_fee_positive CHECK (fee > 0);
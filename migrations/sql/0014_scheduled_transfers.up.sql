@@ -0,0 +1,36 @@
+-- a transfer booked at next_run_at and then again as recurrence says, all
+-- times are UTC. only active schedules have a next run
+CREATE TABLE scheduled_transfers (
+    id SERIAL PRIMARY KEY,
+    from_wallet_id INT NOT NULL REFERENCES wallets(id),
+    to_wallet_id INT NOT NULL REFERENCES wallets(id),
+    amount NUMERIC(20, 4) NOT NULL,
+    recurrence VARCHAR(64) NOT NULL,
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP,
+    next_run_at TIMESTAMP,
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (amount > 0),
+    CHECK (from_wallet_id <> to_wallet_id),
+    CONSTRAINT scheduled_transfers_status_check CHECK (status IN ('active', 'paused', 'completed', 'cancelled')),
+    CHECK (status <> 'active' OR next_run_at IS NOT NULL)
+);
+
+-- the worker only ever looks for active schedules that are due
+CREATE INDEX scheduled_transfers_due_idx ON scheduled_transfers (next_run_at) WHERE status = 'active';
+CREATE INDEX scheduled_transfers_from_wallet_id_idx ON scheduled_transfers (from_wallet_id);
+
+-- one row per run, a run is never made twice for the same due time
+CREATE TABLE scheduled_transfer_runs (
+    id SERIAL PRIMARY KEY,
+    schedule_id INT NOT NULL REFERENCES scheduled_transfers(id),
+    scheduled_for TIMESTAMP NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    transaction_id INT REFERENCES transactions(id),
+    error TEXT,
+    ran_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (status IN ('succeeded', 'failed')),
+    UNIQUE (schedule_id, scheduled_for)
+);
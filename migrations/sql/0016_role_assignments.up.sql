@@ -0,0 +1,10 @@
+-- roles a user holds on top of customer, which every user has. what each
+-- role may do is defined in code
+CREATE TABLE role_assignments (
+    user_id INT NOT NULL REFERENCES users(id),
+    role VARCHAR(16) NOT NULL,
+    granted_by VARCHAR(128) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role),
+    CHECK (role IN ('support', 'finance', 'admin'))
+);
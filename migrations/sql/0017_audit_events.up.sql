@@ -0,0 +1,28 @@
+-- every state change with who made it, from where and the wallet balances
+-- around it. each event carries the hash of the one before it, so editing or
+-- removing an event breaks the chain from there on. rows are only ever
+-- inserted, the trigger below refuses anything else
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP NOT NULL,
+    actor VARCHAR(128) NOT NULL,
+    ip VARCHAR(128) NOT NULL,
+    request_id VARCHAR(128) NOT NULL,
+    operation VARCHAR(64) NOT NULL,
+    -- kept as the exact text that was hashed, jsonb would normalise it
+    balances TEXT NOT NULL,
+    details TEXT NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+
+CREATE FUNCTION refuse_audit_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION refuse_audit_change();
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION refuse_audit_change();
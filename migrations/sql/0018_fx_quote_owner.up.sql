@@ -0,0 +1,4 @@
+-- the principal that asked for a quote, only it may convert with the quote.
+-- quotes live seconds, those from before the column are never usable again
+ALTER TABLE fx_quotes ADD COLUMN created_by VARCHAR(128) NOT NULL DEFAULT '';
+ALTER TABLE fx_quotes ALTER COLUMN created_by DROP DEFAULT;
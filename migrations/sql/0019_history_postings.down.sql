@@ -0,0 +1,2 @@
+DROP INDEX postings_account_id_created_at_transaction_id_idx;
+CREATE INDEX postings_account_id_idx ON postings (account_id, created_at, id);
@@ -0,0 +1,5 @@
+-- history pages are read from the postings of the wallet's account by
+-- (created_at, transaction_id) after a cursor, the transaction id breaks ties
+-- the way the cursor does
+DROP INDEX postings_account_id_idx;
+CREATE INDEX postings_account_id_created_at_transaction_id_idx ON postings (account_id, created_at, transaction_id);
@@ -0,0 +1,6 @@
+-- keys of one scope may clash with those of another, only the unscoped and
+-- the schedule run keys are kept
+DELETE FROM idempotency_keys WHERE scope NOT IN ('', 'internal:schedules');
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys DROP COLUMN scope;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
@@ -0,0 +1,8 @@
+-- keys are unique within a scope only. the keys the server makes for itself,
+-- like those of scheduled transfer runs, live in scopes no client can reach
+ALTER TABLE idempotency_keys ADD COLUMN scope VARCHAR(160) NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, key);
+
+-- the run keys so far were stored where clients could claim them first
+UPDATE idempotency_keys SET scope = 'internal:schedules' WHERE key LIKE 'schedule:%';
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key so a leaked one is easy to recognise.
+const APIKeyPrefix = "wk_"
+
+// MaxAPIKeyName is the longest name an API key can be given, in characters.
+const MaxAPIKeyName = 64
+
+var scopePattern = regexp.MustCompile(`^[a-z][a-z0-9_.:-]{0,63}$`)
+
+// APIKey describes a key without its secret, which is only shown once on creation.
+type APIKey struct {
+	ID int `json:"id"`
+	// UserID is the user the key acts as, zero for a key of a service
+	UserID    int        `json:"user_id,omitempty"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, scopes, created_at, revoked_at"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }, key *APIKey) error {
+	var userID sql.NullInt64
+	var revokedAt sql.NullTime
+	var scopes pq.StringArray
+	if err := row.Scan(&key.ID, &userID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &revokedAt); err != nil {
+		return err
+	}
+	key.UserID = int(userID.Int64)
+	key.Scopes = []string(scopes)
+	key.RevokedAt = nil
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return nil
+}
+
+// hashAPIKey is what a key is stored and looked up as. The secret is 32
+// random bytes, so a plain sha256 is enough, there is nothing to brute force.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+CreateAPIKey issues a key acting as given user, or as no user when userID is
+zero, with scopes such as "admin". It returns the key and its secret, the
+secret is not stored and can not be shown again.
+*/
+func (s *UserService) CreateAPIKey(ctx context.Context, userID int, name string, scopes []string) (APIKey, string, error) {
+	var key APIKey
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxAPIKeyName {
+		return key, "", fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidAPIKey, MaxAPIKeyName)
+	}
+	if scopes == nil {
+		scopes = []string{}
+	}
+	for _, scope := range scopes {
+		if !scopePattern.MatchString(scope) {
+			return key, "", fmt.Errorf("%w: scope %q", ErrInvalidAPIKey, scope)
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return key, "", err
+	}
+	secret := APIKeyPrefix + hex.EncodeToString(buf)
+
+	user := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+	err := inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		err := scanAPIKey(tx.QueryRowContext(ctx, `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes)
+			VALUES ($1, $2, $3, $4, $5) RETURNING `+apiKeyColumns,
+			user, name, secret[:len(APIKeyPrefix)+8], hashAPIKey(secret), pq.Array(scopes)), &key)
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+		}
+		if err != nil {
+			return err
+		}
+		//the secret itself never reaches the audit log
+		return recordAudit(ctx, tx, AuditAPIKeyCreate, auditDetails{"key_id": key.ID, "user_id": key.UserID, "prefix": key.Prefix, "scopes": key.Scopes})
+	})
+	if err != nil {
+		return key, "", err
+	}
+	return key, secret, nil
+}
+
+// AuthenticateAPIKey returns the key secret belongs to, ErrUnauthenticated
+// for unknown and revoked keys alike
+func (s *UserService) AuthenticateAPIKey(ctx context.Context, secret string) (APIKey, error) {
+	var key APIKey
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return key, ErrUnauthenticated
+	}
+	err := scanAPIKey(s.DB.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		hashAPIKey(secret)), &key)
+	if err == sql.ErrNoRows {
+		return key, ErrUnauthenticated
+	}
+	return key, err
+}
+
+// ListAPIKeys returns the keys of given user, revoked ones included
+func (s *UserService) ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey stops a key from authenticating, revoking it again changes nothing
+func (s *UserService) RevokeAPIKey(ctx context.Context, keyID int) (APIKey, error) {
+	var key APIKey
+	err := inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		err := scanAPIKey(tx.QueryRowContext(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP)
+			WHERE id = $1 RETURNING `+apiKeyColumns, keyID), &key)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrAPIKeyNotFound, keyID)
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditAPIKeyRevoke, auditDetails{"key_id": key.ID, "user_id": key.UserID, "prefix": key.Prefix})
+	})
+	return key, err
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// Operations recorded in the audit log.
+const (
+	AuditDeposit        = "deposit"
+	AuditWithdraw       = "withdraw"
+	AuditTransfer       = "transfer"
+	AuditTransferBatch  = "transfer_batch"
+	AuditConvert        = "convert"
+	AuditHoldPlace      = "hold_place"
+	AuditHoldCapture    = "hold_capture"
+	AuditHoldVoid       = "hold_void"
+	AuditHoldsExpire    = "holds_expire"
+	AuditReversal       = "reversal"
+	AuditWalletStatus   = "wallet_status"
+	AuditWalletCreate   = "wallet_create"
+	AuditWalletLimits   = "wallet_limits"
+	AuditWalletTier     = "wallet_tier"
+	AuditTierLimits     = "tier_limits"
+	AuditScheduleCreate = "schedule_create"
+	AuditScheduleUpdate = "schedule_update"
+	AuditScheduleCancel = "schedule_cancel"
+	AuditUserCreate     = "user_create"
+	AuditUserUpdate     = "user_update"
+	AuditAPIKeyCreate   = "api_key_create"
+	AuditAPIKeyRevoke   = "api_key_revoke"
+	AuditRoleAssign     = "role_assign"
+	AuditRoleRevoke     = "role_revoke"
+)
+
+// AuditScheduler is the actor of the transfers made by scheduled runs.
+const AuditScheduler = "scheduler"
+
+const (
+	auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	auditSystemActor = "system"
+	//the advisory lock key that serialises writers of the chain
+	auditLockKey      = 72616
+	maxAuditField     = 128
+	auditVerifyBatch  = 1000
+	auditEventColumns = "id, occurred_at, actor, ip, request_id, operation, balances, details, prev_hash, hash"
+)
+
+// AuditContext is who is behind a service call, the handlers attach it to
+// the request context from the authenticated principal.
+type AuditContext struct {
+	Actor     string
+	IP        string
+	RequestID string
+}
+
+type auditCtxKey struct{}
+
+// WithAuditContext attaches actor to ctx, every state change made with ctx
+// is recorded under it. Without one the actor is "system".
+func WithAuditContext(ctx context.Context, actor AuditContext) context.Context {
+	return context.WithValue(ctx, auditCtxKey{}, actor)
+}
+
+func auditContextFrom(ctx context.Context) AuditContext {
+	actor, _ := ctx.Value(auditCtxKey{}).(AuditContext)
+	if actor.Actor == "" {
+		actor.Actor = auditSystemActor
+	}
+	return actor
+}
+
+// BalanceChange is the balance and held amount of a wallet before and
+// after an audited operation.
+type BalanceChange struct {
+	WalletID      int             `json:"wallet_id"`
+	Currency      string          `json:"currency"`
+	BalanceBefore decimal.Decimal `json:"balance_before"`
+	BalanceAfter  decimal.Decimal `json:"balance_after"`
+	HeldBefore    decimal.Decimal `json:"held_before"`
+	HeldAfter     decimal.Decimal `json:"held_after"`
+}
+
+/*
+AuditEvent is one entry of the audit log. Balances and Details are kept as
+the JSON text that was hashed, Hash is the sha256 of PrevHash and every
+other field but ID, so an event can not be changed without the change
+showing, and PrevHash is the Hash of the event before it.
+*/
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id"`
+	Operation  string          `json:"operation"`
+	Balances   json.RawMessage `json:"balances"`
+	Details    json.RawMessage `json:"details"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+func (e AuditEvent) computeHash() string {
+	//a JSON array keeps the fields apart whatever text they contain
+	fields, _ := json.Marshal([]string{
+		e.PrevHash, e.OccurredAt.UTC().Format(time.RFC3339Nano), e.Actor, e.IP, e.RequestID, e.Operation, string(e.Balances), string(e.Details),
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditDetails are the operation specific fields of an event
+type auditDetails map[string]interface{}
+
+/*
+recordAudit appends an event for operation to the audit log inside tx, with
+the balances of the wallets in before as they were when locked and as they
+are now. It is the last write of a db transaction: the chain is extended
+under a transaction level advisory lock, which serialises the commits of
+audited transactions but is held only from here to the commit.
+*/
+func recordAudit(ctx context.Context, tx *sql.Tx, operation string, details auditDetails, before ...Wallet) error {
+	actor := auditContextFrom(ctx)
+	event := AuditEvent{
+		OccurredAt: time.Now().UTC().Truncate(time.Microsecond),
+		Actor:      truncateAuditField(actor.Actor),
+		IP:         truncateAuditField(actor.IP),
+		RequestID:  truncateAuditField(actor.RequestID),
+		Operation:  operation,
+	}
+
+	changes, err := balanceChanges(ctx, tx, before)
+	if err != nil {
+		return err
+	}
+	if event.Balances, err = json.Marshal(changes); err != nil {
+		return err
+	}
+	if details == nil {
+		details = auditDetails{}
+	}
+	if event.Details, err = json.Marshal(details); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditLockKey); err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&event.PrevHash)
+	if err == sql.ErrNoRows {
+		event.PrevHash, err = auditGenesisHash, nil
+	}
+	if err != nil {
+		return err
+	}
+	event.Hash = event.computeHash()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO audit_events (occurred_at, actor, ip, request_id, operation, balances, details, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		event.OccurredAt, event.Actor, event.IP, event.RequestID, event.Operation, string(event.Balances), string(event.Details), event.PrevHash, event.Hash)
+	return err
+}
+
+// balanceChanges pairs the locked wallets in before with their rows as they are now in tx
+func balanceChanges(ctx context.Context, tx *sql.Tx, before []Wallet) ([]BalanceChange, error) {
+	changes := []BalanceChange{}
+	if len(before) == 0 {
+		return changes, nil
+	}
+	ids := make([]int64, len(before))
+	for i, wallet := range before {
+		ids[i] = int64(wallet.ID)
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, balance, held FROM wallets WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	after := map[int]Wallet{}
+	for rows.Next() {
+		var wallet Wallet
+		if err := rows.Scan(&wallet.ID, &wallet.Balance, &wallet.Held); err != nil {
+			return nil, err
+		}
+		after[wallet.ID] = wallet
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	for _, wallet := range before {
+		if seen[wallet.ID] {
+			continue
+		}
+		seen[wallet.ID] = true
+		changes = append(changes, BalanceChange{
+			WalletID:      wallet.ID,
+			Currency:      wallet.Currency,
+			BalanceBefore: wallet.Balance,
+			BalanceAfter:  after[wallet.ID].Balance,
+			HeldBefore:    wallet.Held,
+			HeldAfter:     after[wallet.ID].Held,
+		})
+	}
+	return changes, nil
+}
+
+func truncateAuditField(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	if len(s) <= maxAuditField {
+		return s
+	}
+	//cut on a rune boundary
+	for i := maxAuditField; i > 0; i-- {
+		if (s[i] & 0xC0) != 0x80 {
+			return s[:i]
+		}
+	}
+	return ""
+}
+
+// inTx runs fn in a db transaction and commits it when fn succeeds
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AuditReport is the result of checking the hash chain of the audit log.
+type AuditReport struct {
+	Events int `json:"events"`
+	// LastHash is the head of the chain, kept somewhere else it also shows
+	// events removed from the end
+	LastHash string         `json:"last_hash"`
+	Broken   []AuditProblem `json:"broken"`
+}
+
+type AuditProblem struct {
+	EventID int64  `json:"event_id"`
+	Problem string `json:"problem"`
+}
+
+// OK reports whether the chain is intact.
+func (r *AuditReport) OK() bool {
+	return len(r.Broken) == 0
+}
+
+/*
+VerifyAuditLog walks the audit log in order, recomputing the hash of every
+event and checking that it links to the event before it. An edited event
+shows as a hash mismatch, a removed one as a broken link on the event after.
+*/
+func (s *WalletService) VerifyAuditLog(ctx context.Context) (*AuditReport, error) {
+	report := &AuditReport{LastHash: auditGenesisHash}
+	var lastID int64
+	for {
+		rows, err := s.DB.QueryContext(ctx, "SELECT "+auditEventColumns+" FROM audit_events WHERE id > $1 ORDER BY id LIMIT $2", lastID, auditVerifyBatch)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for rows.Next() {
+			event, err := scanAuditEvent(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			n++
+			lastID = event.ID
+			report.Events++
+			if event.PrevHash != report.LastHash {
+				report.Broken = append(report.Broken, AuditProblem{EventID: event.ID, Problem: "does not link to the event before it"})
+			}
+			if event.computeHash() != event.Hash {
+				report.Broken = append(report.Broken, AuditProblem{EventID: event.ID, Problem: "hash does not match its content"})
+			}
+			report.LastHash = event.Hash
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if n < auditVerifyBatch {
+			return report, nil
+		}
+	}
+}
+
+// AuditEvents returns the newest events of the audit log, newest first
+func (s *WalletService) AuditEvents(ctx context.Context, limit int) ([]AuditEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+auditEventColumns+" FROM audit_events ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func scanAuditEvent(rows *sql.Rows) (AuditEvent, error) {
+	var event AuditEvent
+	var balances, details string
+	err := rows.Scan(&event.ID, &event.OccurredAt, &event.Actor, &event.IP, &event.RequestID, &event.Operation, &balances, &details, &event.PrevHash, &event.Hash)
+	if err != nil {
+		return event, fmt.Errorf("audit event: %w", err)
+	}
+	event.OccurredAt = event.OccurredAt.UTC()
+	event.Balances = json.RawMessage(balances)
+	event.Details = json.RawMessage(details)
+	return event, nil
+}
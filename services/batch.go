@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Modes of a batch transfer. An atomic batch books every leg or none, a
+// best effort batch books the legs it can and reports the others as failed.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+// MaxBatchLegs is the most legs one batch transfer accepts.
+const MaxBatchLegs = 500
+
+// Outcomes of a batch leg.
+const (
+	LegSucceeded = "succeeded"
+	LegFailed    = "failed"
+)
+
+// BatchLeg pays Amount to the default wallet of ToUserID.
+type BatchLeg struct {
+	ToUserID int    `json:"to_user_id"`
+	Amount   string `json:"amount"`
+}
+
+// BatchLegResult is the outcome of one leg, in the order the legs were given.
+type BatchLegResult struct {
+	Index      int             `json:"index"`
+	ToUserID   int             `json:"to_user_id"`
+	ToWalletID int             `json:"to_wallet_id,omitempty"`
+	Amount     decimal.Decimal `json:"amount"`
+	Fee        decimal.Decimal `json:"fee"`
+	Status     string          `json:"status"`
+	// TransactionID is the transfer a succeeded leg booked
+	TransactionID int64 `json:"transaction_id,omitempty"`
+	// Err says why a leg failed, callers turn it into something to show
+	Err error `json:"-"`
+}
+
+// BatchResult reports a batch transfer leg by leg. Total is what left the
+// source wallet, fees included.
+type BatchResult struct {
+	Mode         string           `json:"mode"`
+	FromWalletID int              `json:"from_wallet_id"`
+	Currency     string           `json:"currency"`
+	Total        decimal.Decimal  `json:"total"`
+	Succeeded    int              `json:"succeeded"`
+	Failed       int              `json:"failed"`
+	Legs         []BatchLegResult `json:"legs"`
+}
+
+// BatchLegError fails an atomic batch, it names the leg that could not be booked.
+type BatchLegError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchLegError) Error() string {
+	return fmt.Sprintf("leg %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchLegError) Unwrap() error {
+	return e.Err
+}
+
+/*
+TransferBatch pays every leg from one wallet in a single db transaction. The
+source and all receiving wallets are locked up front in id order, the same
+way a single transfer locks its pair, so batches and transfers touching the
+same wallets never deadlock.
+
+Legs are booked in order as separate transfers, each charged its fee and
+counted against the limits of the source wallet like a transfer of its own.
+An atomic batch first checks the total of all legs and fees against the
+available balance and then fails as a whole with a *BatchLegError on the
+first leg that can not be booked. A best effort batch skips such legs and
+commits the rest, each leg runs under a savepoint so only failures of the
+business rules are reported per leg, a db error fails the whole batch. A
+malformed leg fails the batch in either mode.
+*/
+func (s *WalletService) TransferBatch(ctx context.Context, fromWalletID int, mode string, legs []BatchLeg) (BatchResult, error) {
+	result := BatchResult{Mode: mode, FromWalletID: fromWalletID}
+	if mode != BatchAtomic && mode != BatchBestEffort {
+		return result, fmt.Errorf("%w: mode must be %s or %s", ErrInvalidBatch, BatchAtomic, BatchBestEffort)
+	}
+	if len(legs) == 0 || len(legs) > MaxBatchLegs {
+		return result, fmt.Errorf("%w: a batch has 1 to %d legs", ErrInvalidBatch, MaxBatchLegs)
+	}
+	amounts := make([]decimal.Decimal, len(legs))
+	for i, leg := range legs {
+		amount, err := ParseAmount(leg.Amount)
+		if err != nil {
+			return result, &BatchLegError{Index: i, Err: err}
+		}
+		amounts[i] = amount
+	}
+
+	//receivers are resolved to their default wallet before anything is locked
+	toWalletIDs := make([]int, len(legs))
+	for i, leg := range legs {
+		walletID, err := s.DefaultWalletID(ctx, leg.ToUserID)
+		if errors.Is(err, ErrWalletNotFound) && mode == BatchBestEffort {
+			continue
+		}
+		if err != nil {
+			return result, &BatchLegError{Index: i, Err: err}
+		}
+		toWalletIDs[i] = walletID
+	}
+
+	err := retryTx(ctx, func() error {
+		var err error
+		result, err = s.transferBatch(ctx, fromWalletID, mode, legs, amounts, toWalletIDs)
+		return err
+	})
+	return result, err
+}
+
+func (s *WalletService) transferBatch(ctx context.Context, fromWalletID int, mode string, legs []BatchLeg, amounts []decimal.Decimal, toWalletIDs []int) (BatchResult, error) {
+	result := BatchResult{Mode: mode, FromWalletID: fromWalletID, Total: decimal.Zero, Legs: make([]BatchLegResult, len(legs))}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	if err := claimIdempotencyKey(ctx, tx); err != nil {
+		return result, err
+	}
+
+	walletIDs := []int64{int64(fromWalletID)}
+	for _, id := range toWalletIDs {
+		if id != 0 {
+			walletIDs = append(walletIDs, int64(id))
+		}
+	}
+	wallets, err := lockWallets(tx, walletIDs)
+	if err != nil {
+		return result, err
+	}
+	from, ok := wallets[int64(fromWalletID)]
+	if !ok {
+		return result, fmt.Errorf("%w: %d", ErrWalletNotFound, fromWalletID)
+	}
+	if err := from.canSend(); err != nil {
+		return result, err
+	}
+	result.Currency = from.Currency
+	//the copies in wallets follow the legs, the audit needs them as locked
+	locked := make([]Wallet, 0, len(wallets))
+	for _, id := range walletIDs {
+		locked = append(locked, wallets[id])
+	}
+
+	fees := make([]decimal.Decimal, len(legs))
+	total := decimal.Zero
+	for i, amount := range amounts {
+		if fees[i], err = s.fee(ctx, FeeTransfer, from.Currency, amount); err != nil {
+			return result, err
+		}
+		total = total.Add(amount).Add(fees[i])
+	}
+	if mode == BatchAtomic && from.Available().LessThan(total) {
+		return result, fmt.Errorf("%w: the batch needs %s %s", ErrInsufficientFunds, total, from.Currency)
+	}
+
+	for i, leg := range legs {
+		res := BatchLegResult{Index: i, ToUserID: leg.ToUserID, ToWalletID: toWalletIDs[i], Amount: amounts[i], Fee: fees[i], Status: LegSucceeded}
+		to, ok := wallets[int64(toWalletIDs[i])]
+		if !ok {
+			res.Err = fmt.Errorf("%w: user %d has no wallet", ErrWalletNotFound, leg.ToUserID)
+		} else {
+			var txID int64
+			txID, res.Err = s.bookLeg(ctx, tx, mode, from, to, amounts[i], fees[i])
+			if res.Err != nil && !isLegFailure(res.Err) {
+				return result, res.Err
+			}
+			res.TransactionID = txID
+		}
+
+		if res.Err != nil {
+			if mode == BatchAtomic {
+				return result, &BatchLegError{Index: i, Err: res.Err}
+			}
+			res.Status = LegFailed
+			res.Fee = decimal.Zero
+			result.Failed++
+		} else {
+			//the wallets were written with absolute balances, keep the copies in step
+			from.Balance = from.Balance.Sub(amounts[i]).Sub(fees[i])
+			to = wallets[int64(to.ID)]
+			to.Balance = to.Balance.Add(amounts[i])
+			wallets[int64(to.ID)] = to
+			result.Total = result.Total.Add(amounts[i]).Add(fees[i])
+			result.Succeeded++
+		}
+		result.Legs[i] = res
+	}
+
+	transactionIDs := []int64{}
+	for _, leg := range result.Legs {
+		if leg.Status == LegSucceeded {
+			transactionIDs = append(transactionIDs, leg.TransactionID)
+		}
+	}
+	err = recordAudit(ctx, tx, AuditTransferBatch, auditDetails{
+		"mode": mode, "succeeded": result.Succeeded, "failed": result.Failed, "total": result.Total, "transaction_ids": transactionIDs,
+	}, locked...)
+	if err != nil {
+		return result, err
+	}
+	if err := storeIdempotentResult(ctx, tx, result); err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
+
+// legFailureErrors are the errors that fail a single leg of a best effort
+// batch, anything else fails the whole batch
+var legFailureErrors = []error{
+	ErrInsufficientFunds, ErrWalletFrozen, ErrWalletClosed, ErrWalletNotFound, ErrLimitExceeded,
+	ErrCurrencyMismatch, ErrInvalidAmount, ErrSameWallet,
+}
+
+func isLegFailure(err error) bool {
+	for _, target := range legFailureErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// bookLeg books a leg, under a savepoint in best effort mode so a
+// leg that fails takes back whatever it wrote and leaves tx usable for the
+// next one. A db error is returned as is, the batch fails with it.
+func (s *WalletService) bookLeg(ctx context.Context, tx *sql.Tx, mode string, from, to Wallet, amount, fee decimal.Decimal) (int64, error) {
+	if mode != BatchBestEffort {
+		return s.bookBatchLeg(ctx, tx, from, to, amount, fee)
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_leg"); err != nil {
+		return 0, err
+	}
+	txID, err := s.bookBatchLeg(ctx, tx, from, to, amount, fee)
+	if err != nil {
+		if isLegFailure(err) {
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_leg"); rollbackErr != nil {
+				return 0, rollbackErr
+			}
+		}
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_leg")
+	return txID, err
+}
+
+// bookBatchLeg checks and books one leg, nothing is written when a check fails
+func (s *WalletService) bookBatchLeg(ctx context.Context, tx *sql.Tx, from, to Wallet, amount, fee decimal.Decimal) (int64, error) {
+	if from.ID == to.ID {
+		return 0, ErrSameWallet
+	}
+	if err := to.canReceive(); err != nil {
+		return 0, err
+	}
+	if from.Currency != to.Currency {
+		return 0, fmt.Errorf("%w: %s to %s", ErrCurrencyMismatch, from.Currency, to.Currency)
+	}
+	if err := ValidateAmount(from.Currency, amount); err != nil {
+		return 0, err
+	}
+	if from.Available().LessThan(amount.Add(fee)) {
+		return 0, ErrInsufficientFunds
+	}
+	if err := checkLimits(ctx, tx, from, amount); err != nil {
+		return 0, err
+	}
+	return bookTransfer(tx, from, to, amount, fee, sql.NullInt64{})
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultCurrency is used for wallets created without an explicit currency.
+const DefaultCurrency = "USD"
+
+// minorUnits is the number of decimal places ISO 4217 defines for each
+// supported currency, amounts with more places are rejected
+var minorUnits = map[string]int32{
+	"AUD": 2,
+	"BHD": 3,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"INR": 2,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"SGD": 2,
+	"USD": 2,
+}
+
+// NormalizeCurrency upper-cases code and checks that it is supported, an
+// empty code means DefaultCurrency.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+	if _, ok := minorUnits[code]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedCurrency, code)
+	}
+	return code, nil
+}
+
+// MinorUnits returns the number of decimal places allowed for currency.
+func MinorUnits(currency string) (int32, error) {
+	units, ok := minorUnits[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	return units, nil
+}
+
+// ValidateAmount rejects amounts more precise than currency allows,
+// 0.5 JPY or 1.234 USD can not be moved.
+func ValidateAmount(currency string, amount decimal.Decimal) error {
+	units, err := MinorUnits(currency)
+	if err != nil {
+		return err
+	}
+	if !amount.Equal(amount.Truncate(units)) {
+		return fmt.Errorf("%w: %s allows at most %d decimal places", ErrInvalidAmount, currency, units)
+	}
+	return nil
+}
+
+// FormatAmount renders amount with exactly the decimal places of currency.
+func FormatAmount(currency string, amount decimal.Decimal) string {
+	units, ok := minorUnits[currency]
+	if !ok {
+		return amount.String()
+	}
+	return amount.StringFixed(units)
+}
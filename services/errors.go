@@ -0,0 +1,60 @@
+package services
+
+import "errors"
+
+/*
+Errors returned by the services, wrapped with more detail where useful, so
+callers should compare with errors.Is instead of matching on the message.
+*/
+var (
+	ErrInvalidAmount       = errors.New("invalid amount")
+	ErrInsufficientFunds   = errors.New("insufficient balance")
+	ErrWalletNotFound      = errors.New("wallet not found")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidUserName     = errors.New("user name must be between 1 and 128 characters")
+	ErrWalletFrozen        = errors.New("wallet is frozen")
+	ErrSameWallet          = errors.New("cannot transfer to the same wallet")
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrWalletClosed        = errors.New("wallet is closed")
+	// ErrWalletNotEmpty is returned when closing a wallet that still holds money
+	ErrWalletNotEmpty = errors.New("wallet balance must be zero to close it")
+	// ErrInvalidStatusChange is returned for a status change the current status does not allow
+	ErrInvalidStatusChange = errors.New("wallet status change not allowed")
+	ErrInvalidActor        = errors.New("actor must be between 1 and 128 characters")
+	// ErrCurrencyMismatch is returned for a transfer between wallets of different currencies without conversion
+	ErrCurrencyMismatch = errors.New("wallets hold different currencies, a conversion is required")
+	// ErrRateUnavailable means the rate provider has no rate for a currency pair
+	ErrRateUnavailable = errors.New("no exchange rate available for currency pair")
+	ErrQuoteNotFound   = errors.New("quote not found")
+	// ErrQuoteExpired is returned for a quote past its expiry or already used by a conversion
+	ErrQuoteExpired = errors.New("quote expired or already used")
+	ErrHoldNotFound = errors.New("hold not found")
+	// ErrHoldNotActive is returned for a hold that was already captured, voided or has expired
+	ErrHoldNotActive       = errors.New("hold is no longer active")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	// ErrNotReversible is returned for transaction types that can not be given back, like reversals themselves
+	ErrNotReversible = errors.New("transaction can not be reversed")
+	// ErrAlreadyReversed is returned once the reversals of a transaction add up to its amount
+	ErrAlreadyReversed = errors.New("transaction was already fully reversed")
+	ErrInvalidReason   = errors.New("reason must be between 1 and 500 characters")
+	// ErrInvalidFilter is returned for a malformed history filter or cursor
+	ErrInvalidFilter = errors.New("invalid history filter")
+	// ErrLimitExceeded is matched by LimitExceededError, which tells the limit and the allowance left
+	ErrLimitExceeded = errors.New("transaction limit exceeded")
+	ErrInvalidLimits = errors.New("invalid limits")
+	ErrInvalidTier   = errors.New("tier must be 1 to 32 lowercase letters, digits, dashes or underscores")
+	// ErrInvalidOperation is returned for an operation a fee can not be previewed for
+	ErrInvalidOperation = errors.New("invalid operation")
+	ErrScheduleNotFound = errors.New("schedule not found")
+	// ErrScheduleNotActive is returned for changes to a schedule that completed or was cancelled
+	ErrScheduleNotActive = errors.New("schedule is no longer active")
+	ErrInvalidSchedule   = errors.New("invalid schedule")
+	ErrInvalidBatch      = errors.New("invalid batch")
+	// ErrUnauthenticated is returned for credentials that do not identify anyone
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	ErrInvalidAPIKey   = errors.New("invalid api key")
+	ErrAPIKeyNotFound  = errors.New("api key not found")
+	ErrInvalidRole     = errors.New("invalid role")
+	// ErrConflict means the operation collided with concurrent ones and can be retried later
+	ErrConflict = errors.New("operation conflicted with a concurrent update, please retry")
+)
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/shopspring/decimal"
+)
+
+// AccountFeeRevenue is the system account charged fees are booked to.
+const AccountFeeRevenue = "fees:revenue"
+
+// Operations a fee schedule prices.
+const (
+	FeeWithdraw = "withdraw"
+	FeeTransfer = "transfer"
+)
+
+// AnyCurrency in a fee rule matches every currency without a rule of its own.
+const AnyCurrency = "*"
+
+// FeeSchedule returns the fee for an operation of amount in currency, zero
+// when the operation is free. The fee is charged on top of amount.
+type FeeSchedule interface {
+	Fee(ctx context.Context, operation, currency string, amount decimal.Decimal) (decimal.Decimal, error)
+}
+
+// FeeTier is one bracket of a tiered fee, a tier without UpTo covers every
+// amount above the previous one.
+type FeeTier struct {
+	UpTo    *decimal.Decimal `json:"up_to"`
+	Flat    decimal.Decimal  `json:"flat"`
+	Percent decimal.Decimal  `json:"percent"`
+}
+
+// FeeRule prices one operation in one currency or in AnyCurrency. With
+// tiers the bracket of the amount replaces Flat and Percent.
+type FeeRule struct {
+	Operation string           `json:"operation"`
+	Currency  string           `json:"currency"`
+	Flat      decimal.Decimal  `json:"flat"`
+	Percent   decimal.Decimal  `json:"percent"`
+	Min       *decimal.Decimal `json:"min"`
+	Max       *decimal.Decimal `json:"max"`
+	Tiers     []FeeTier        `json:"tiers"`
+}
+
+func (r FeeRule) fee(amount decimal.Decimal) decimal.Decimal {
+	flat, percent := r.Flat, r.Percent
+	for _, tier := range r.Tiers {
+		if tier.UpTo == nil || amount.LessThanOrEqual(*tier.UpTo) {
+			flat, percent = tier.Flat, tier.Percent
+			break
+		}
+	}
+
+	fee := flat.Add(amount.Mul(percent).Div(decimal.NewFromInt(100)))
+	if r.Min != nil && fee.LessThan(*r.Min) {
+		fee = *r.Min
+	}
+	if r.Max != nil && fee.GreaterThan(*r.Max) {
+		fee = *r.Max
+	}
+	return fee
+}
+
+func (r FeeRule) validate() error {
+	if r.Operation != FeeWithdraw && r.Operation != FeeTransfer {
+		return fmt.Errorf("operation %q must be %s or %s", r.Operation, FeeWithdraw, FeeTransfer)
+	}
+	for _, v := range []*decimal.Decimal{&r.Flat, &r.Percent, r.Min, r.Max} {
+		if v != nil && v.Sign() < 0 {
+			return fmt.Errorf("%s %s: fees must not be negative", r.Operation, r.Currency)
+		}
+	}
+	if r.Min != nil && r.Max != nil && r.Min.GreaterThan(*r.Max) {
+		return fmt.Errorf("%s %s: min is above max", r.Operation, r.Currency)
+	}
+	for i, tier := range r.Tiers {
+		if tier.Flat.Sign() < 0 || tier.Percent.Sign() < 0 {
+			return fmt.Errorf("%s %s: fees must not be negative", r.Operation, r.Currency)
+		}
+		if tier.UpTo == nil && i != len(r.Tiers)-1 {
+			return fmt.Errorf("%s %s: only the last tier may leave out up_to", r.Operation, r.Currency)
+		}
+		if i > 0 && tier.UpTo != nil && !tier.UpTo.GreaterThan(*r.Tiers[i-1].UpTo) {
+			return fmt.Errorf("%s %s: tiers must be in increasing order of up_to", r.Operation, r.Currency)
+		}
+	}
+	return nil
+}
+
+/*
+RuleSchedule is a FeeSchedule made of FeeRules, usually read from a JSON
+file of the form
+
+	{"rules": [
+		{"operation": "withdraw", "currency": "USD", "flat": "0.25", "percent": "1", "min": "0.50", "max": "20"},
+		{"operation": "transfer", "currency": "*", "tiers": [{"up_to": "100", "flat": "0"}, {"percent": "0.5"}]}
+	]}
+
+A rule for the exact currency wins over one for "*" and an operation
+without a matching rule is free. Tiers are brackets, the first tier whose
+up_to the amount does not exceed prices the whole amount. min and max cap
+the fee, which is then rounded to the minor units of the currency.
+*/
+type RuleSchedule struct {
+	rules map[string]FeeRule
+}
+
+// NewRuleSchedule checks rules and builds a schedule of them.
+func NewRuleSchedule(rules []FeeRule) (*RuleSchedule, error) {
+	schedule := &RuleSchedule{rules: make(map[string]FeeRule, len(rules))}
+	for _, rule := range rules {
+		if rule.Currency != AnyCurrency {
+			currency, err := NormalizeCurrency(rule.Currency)
+			if err != nil {
+				return nil, err
+			}
+			rule.Currency = currency
+		}
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		key := rule.Operation + "/" + rule.Currency
+		if _, ok := schedule.rules[key]; ok {
+			return nil, fmt.Errorf("more than one rule for %s %s", rule.Operation, rule.Currency)
+		}
+		schedule.rules[key] = rule
+	}
+	return schedule, nil
+}
+
+// LoadFeeSchedule reads the fee schedule file at path.
+func LoadFeeSchedule(path string) (*RuleSchedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fees: reading %s: %w", path, err)
+	}
+	var raw struct {
+		Rules []FeeRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("fees: parsing %s: %w", path, err)
+	}
+	schedule, err := NewRuleSchedule(raw.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("fees: %s: %w", path, err)
+	}
+	return schedule, nil
+}
+
+func (s *RuleSchedule) Fee(ctx context.Context, operation, currency string, amount decimal.Decimal) (decimal.Decimal, error) {
+	rule, ok := s.rules[operation+"/"+currency]
+	if !ok {
+		rule, ok = s.rules[operation+"/"+AnyCurrency]
+	}
+	if !ok {
+		return decimal.Zero, nil
+	}
+	units, err := MinorUnits(currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return rule.fee(amount).Round(units), nil
+}
+
+// FeeQuote is the outcome of a dry run, what an operation would cost without booking it.
+type FeeQuote struct {
+	Operation string          `json:"operation"`
+	Currency  string          `json:"currency"`
+	Amount    decimal.Decimal `json:"amount"`
+	Fee       decimal.Decimal `json:"fee"`
+	// Total is what leaves the wallet, amount and fee together
+	Total decimal.Decimal `json:"total"`
+}
+
+// nullFee stores a zero fee as null, the column only holds fees actually charged
+func nullFee(fee decimal.Decimal) decimal.NullDecimal {
+	return decimal.NullDecimal{Decimal: fee, Valid: fee.Sign() > 0}
+}
+
+/*
+withFee charges fee to the paying wallet, the first of postings, and books
+it to the fee revenue account as a line of its own in the same journal. The
+wallet keeps a single posting of amount and fee together, so its history
+shows one entry per transaction.
+*/
+func withFee(tx *sql.Tx, postings []Posting, fee decimal.Decimal) ([]Posting, error) {
+	if fee.Sign() == 0 {
+		return postings, nil
+	}
+	revenue, err := systemAccountID(tx, AccountFeeRevenue)
+	if err != nil {
+		return nil, err
+	}
+	postings[0].Amount = postings[0].Amount.Sub(fee)
+	return append(postings, Posting{AccountID: revenue, Amount: fee, Currency: postings[0].Currency}), nil
+}
+
+// fee prices an operation with the configured schedule, everything is free without one
+func (s *WalletService) fee(ctx context.Context, operation, currency string, amount decimal.Decimal) (decimal.Decimal, error) {
+	if s.Fees == nil {
+		return decimal.Zero, nil
+	}
+	fee, err := s.Fees.Fee(ctx, operation, currency, amount)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if fee.Sign() < 0 {
+		return decimal.Zero, fmt.Errorf("fee schedule returned a negative fee for %s of %s %s", operation, amount, currency)
+	}
+	return fee, nil
+}
+
+// PreviewFee returns the fee an operation of amountStr from given wallet would
+// be charged right now, without moving any money
+func (s *WalletService) PreviewFee(ctx context.Context, walletID int, operation, amountStr string) (FeeQuote, error) {
+	var quote FeeQuote
+	if operation != FeeWithdraw && operation != FeeTransfer {
+		return quote, fmt.Errorf("%w: operation must be %s or %s", ErrInvalidOperation, FeeWithdraw, FeeTransfer)
+	}
+	amount, err := ParseAmount(amountStr)
+	if err != nil {
+		return quote, err
+	}
+	wallet, err := s.GetWallet(ctx, walletID)
+	if err != nil {
+		return quote, err
+	}
+	if err := ValidateAmount(wallet.Currency, amount); err != nil {
+		return quote, err
+	}
+
+	fee, err := s.fee(ctx, operation, wallet.Currency, amount)
+	if err != nil {
+		return quote, err
+	}
+	return FeeQuote{Operation: operation, Currency: wallet.Currency, Amount: amount, Fee: fee, Total: amount.Add(fee)}, nil
+}
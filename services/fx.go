@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// AccountFXConversion is the system account both legs of a conversion are booked against.
+const AccountFXConversion = "fx:conversion"
+
+// DefaultQuoteTTL is how long a quote is honoured when WalletService.QuoteTTL is not set.
+const DefaultQuoteTTL = 30 * time.Second
+
+// FXRateProvider returns how many units of to one unit of from buys.
+type FXRateProvider interface {
+	Rate(ctx context.Context, from, to string) (decimal.Decimal, error)
+}
+
+/*
+StaticRates serves rates read once from a JSON file of the form
+
+	{"USD/EUR": "0.92", "EUR/JPY": "161.5"}
+
+A pair missing from the file is answered with the inverse of the opposite
+pair when that one is present.
+*/
+type StaticRates struct {
+	rates map[string]decimal.Decimal
+}
+
+// LoadStaticRates reads the rate file at path.
+func LoadStaticRates(path string) (*StaticRates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fx: reading %s: %w", path, err)
+	}
+	var raw map[string]decimal.Decimal
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("fx: parsing %s: %w", path, err)
+	}
+
+	rates := make(map[string]decimal.Decimal, len(raw))
+	for pair, rate := range raw {
+		from, to, ok := strings.Cut(pair, "/")
+		if !ok {
+			return nil, fmt.Errorf("fx: %s: pair %q must look like USD/EUR", path, pair)
+		}
+		if from, err = NormalizeCurrency(from); err != nil {
+			return nil, fmt.Errorf("fx: %s: %w", path, err)
+		}
+		if to, err = NormalizeCurrency(to); err != nil {
+			return nil, fmt.Errorf("fx: %s: %w", path, err)
+		}
+		if rate.Sign() <= 0 {
+			return nil, fmt.Errorf("fx: %s: rate for %s must be positive", path, pair)
+		}
+		rates[from+"/"+to] = rate
+	}
+	return &StaticRates{rates: rates}, nil
+}
+
+func (r *StaticRates) Rate(ctx context.Context, from, to string) (decimal.Decimal, error) {
+	if rate, ok := r.rates[from+"/"+to]; ok {
+		return rate, nil
+	}
+	if rate, ok := r.rates[to+"/"+from]; ok {
+		return decimal.NewFromInt(1).DivRound(rate, 10), nil
+	}
+	return decimal.Decimal{}, fmt.Errorf("%w: %s/%s", ErrRateUnavailable, from, to)
+}
+
+// MemoryRates is an FXRateProvider whose rates are set in code, meant for tests.
+type MemoryRates struct {
+	mu    sync.Mutex
+	rates map[string]decimal.Decimal
+}
+
+func NewMemoryRates() *MemoryRates {
+	return &MemoryRates{rates: map[string]decimal.Decimal{}}
+}
+
+// Set makes one unit of from buy rate units of to.
+func (r *MemoryRates) Set(from, to string, rate decimal.Decimal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rates[from+"/"+to] = rate
+}
+
+func (r *MemoryRates) Rate(ctx context.Context, from, to string) (decimal.Decimal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rate, ok := r.rates[from+"/"+to]
+	if !ok {
+		return decimal.Decimal{}, fmt.Errorf("%w: %s/%s", ErrRateUnavailable, from, to)
+	}
+	return rate, nil
+}
+
+// Quote is a rate locked for one conversion until ExpiresAt.
+type Quote struct {
+	ID           string          `json:"id"`
+	FromCurrency string          `json:"from_currency"`
+	ToCurrency   string          `json:"to_currency"`
+	Rate         decimal.Decimal `json:"rate"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+}
+
+// CreateQuote asks the rate provider for the from/to rate and stores it so
+// a ConvertAndTransfer within the quote TTL is booked at exactly that rate.
+// The quote belongs to the caller of ctx, nobody else can convert with it.
+func (s *WalletService) CreateQuote(ctx context.Context, from, to string) (Quote, error) {
+	var quote Quote
+	from, err := NormalizeCurrency(from)
+	if err != nil {
+		return quote, err
+	}
+	to, err = NormalizeCurrency(to)
+	if err != nil {
+		return quote, err
+	}
+	if from == to {
+		return quote, fmt.Errorf("%w: cannot quote %s against itself", ErrCurrencyMismatch, from)
+	}
+	if s.FX == nil {
+		return quote, fmt.Errorf("%w: no rate provider configured", ErrRateUnavailable)
+	}
+
+	rate, err := s.FX.Rate(ctx, from, to)
+	if err != nil {
+		return quote, err
+	}
+
+	ttl := s.QuoteTTL
+	if ttl <= 0 {
+		ttl = DefaultQuoteTTL
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return quote, err
+	}
+
+	quote = Quote{ID: hex.EncodeToString(buf), FromCurrency: from, ToCurrency: to, Rate: rate}
+	//expiry is taken from the db clock, the same one ConvertAndTransfer checks it against
+	err = s.DB.QueryRowContext(ctx, `INSERT INTO fx_quotes (id, from_currency, to_currency, rate, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5), $6) RETURNING expires_at`,
+		quote.ID, from, to, rate, ttl.Seconds(), quoteOwner(ctx)).Scan(&quote.ExpiresAt)
+	return quote, err
+}
+
+/*
+ConvertAndTransfer moves amount in the sender's currency to a wallet holding
+another currency at the rate locked by quoteID. The receiver gets amount
+times the rate, truncated to the minor units of its currency. Both legs are
+booked against the fx:conversion account so each currency balances on its
+own, and the quote is used up in the same db transaction. It returns the id
+of the transaction booked.
+*/
+func (s *WalletService) ConvertAndTransfer(ctx context.Context, fromWalletID, toWalletID int, amountStr, quoteID string) (int64, error) {
+	amount, err := ParseAmount(amountStr)
+	if err != nil {
+		return 0, err
+	}
+	if fromWalletID == toWalletID {
+		return 0, ErrSameWallet
+	}
+
+	var txID int64
+	err = retryTx(ctx, func() error {
+		var err error
+		txID, err = s.convert(ctx, fromWalletID, toWalletID, amount, quoteID)
+		return err
+	})
+	return txID, err
+}
+
+func (s *WalletService) convert(ctx context.Context, fromWalletID, toWalletID int, amount decimal.Decimal, quoteID string) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := claimIdempotencyKey(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	from, to, err := lockWalletPair(tx, fromWalletID, toWalletID)
+	if err != nil {
+		return 0, err
+	}
+	if err := from.canSend(); err != nil {
+		return 0, err
+	}
+	if err := to.canReceive(); err != nil {
+		return 0, err
+	}
+
+	quote, err := useQuote(tx, quoteID, quoteOwner(ctx))
+	if err != nil {
+		return 0, err
+	}
+	if quote.FromCurrency != from.Currency || quote.ToCurrency != to.Currency {
+		return 0, fmt.Errorf("%w: quote is for %s to %s, wallets hold %s and %s",
+			ErrCurrencyMismatch, quote.FromCurrency, quote.ToCurrency, from.Currency, to.Currency)
+	}
+	if err := ValidateAmount(from.Currency, amount); err != nil {
+		return 0, err
+	}
+
+	units, err := MinorUnits(to.Currency)
+	if err != nil {
+		return 0, err
+	}
+	toAmount := amount.Mul(quote.Rate).Truncate(units)
+	if toAmount.Sign() <= 0 {
+		return 0, fmt.Errorf("%w: %s %s converts to nothing", ErrInvalidAmount, amount, from.Currency)
+	}
+
+	//a conversion is priced as a transfer, the fee is paid in the source
+	//currency on top of the amount
+	fee, err := s.fee(ctx, FeeTransfer, from.Currency, amount)
+	if err != nil {
+		return 0, err
+	}
+	if from.Available().LessThan(amount.Add(fee)) {
+		return 0, ErrInsufficientFunds
+	}
+	if err := checkLimits(ctx, tx, from, amount); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE id = $2", from.Balance.Sub(amount).Sub(fee), from.ID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE id = $2", to.Balance.Add(toAmount), to.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	var txID int64
+	err = tx.QueryRow(`INSERT INTO transactions (user_id, wallet_id, type, amount, currency, fee, to_user_id, to_wallet_id, to_amount, to_currency, fx_rate, quote_id)
+		VALUES ($1, $2, 'transfer', $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		from.UserID, from.ID, amount, from.Currency, nullFee(fee), to.UserID, to.ID, toAmount, to.Currency, quote.Rate, quote.ID).Scan(&txID)
+	if err != nil {
+		return 0, err
+	}
+
+	fromAccount, err := walletAccountID(tx, int64(from.ID))
+	if err != nil {
+		return 0, err
+	}
+	toAccount, err := walletAccountID(tx, int64(to.ID))
+	if err != nil {
+		return 0, err
+	}
+	fxAccount, err := systemAccountID(tx, AccountFXConversion)
+	if err != nil {
+		return 0, err
+	}
+	postings, err := withFee(tx, []Posting{
+		{AccountID: fromAccount, Amount: amount.Neg(), Currency: from.Currency},
+		{AccountID: fxAccount, Amount: amount, Currency: from.Currency},
+		{AccountID: fxAccount, Amount: toAmount.Neg(), Currency: to.Currency},
+		{AccountID: toAccount, Amount: toAmount, Currency: to.Currency},
+	}, fee)
+	if err != nil {
+		return 0, err
+	}
+	if err := postJournal(tx, txID, postings); err != nil {
+		return 0, err
+	}
+
+	if err := storeIdempotentResult(ctx, tx, txID); err != nil {
+		return 0, err
+	}
+	err = recordAudit(ctx, tx, AuditConvert, auditDetails{
+		"transaction_id": txID, "amount": amount, "fee": fee, "to_amount": toAmount, "quote_id": quote.ID, "rate": quote.Rate,
+	}, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return txID, tx.Commit()
+}
+
+// quoteOwner is who a quote made or used with ctx belongs to, the caller
+// the handlers attached to it
+func quoteOwner(ctx context.Context) string {
+	return truncateAuditField(auditContextFrom(ctx).Actor)
+}
+
+// useQuote locks the quote and marks it used, failing for unknown, expired
+// or already used quotes. The quote of another owner is reported as unknown.
+func useQuote(tx *sql.Tx, quoteID, owner string) (Quote, error) {
+	var quote Quote
+	var usable bool
+	err := tx.QueryRow(`SELECT id, from_currency, to_currency, rate, expires_at, used_at IS NULL AND expires_at > NOW()
+		FROM fx_quotes WHERE id = $1 AND created_by = $2 FOR UPDATE`, quoteID, owner).
+		Scan(&quote.ID, &quote.FromCurrency, &quote.ToCurrency, &quote.Rate, &quote.ExpiresAt, &usable)
+	if errors.Is(err, sql.ErrNoRows) {
+		return quote, ErrQuoteNotFound
+	}
+	if err != nil {
+		return quote, err
+	}
+	if !usable {
+		return quote, ErrQuoteExpired
+	}
+
+	_, err = tx.Exec("UPDATE fx_quotes SET used_at = NOW() WHERE id = $1", quote.ID)
+	return quote, err
+}
@@ -2,4 +2,7 @@ module services
 
 go 1.19
 
-require github.com/shopspring/decimal v1.4.0
+require (
+	github.com/lib/pq v1.10.9
+	github.com/shopspring/decimal v1.4.0
+)
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// Page sizes of the transaction history.
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 200
+)
+
+// HistoryFilter narrows and pages a transaction history, zero values mean no filter.
+type HistoryFilter struct {
+	// Types keeps only transactions of these types, e.g. deposit or transfer
+	Types []string
+	// MinAmount and MaxAmount bound the amount that entered or left the wallet
+	MinAmount *decimal.Decimal
+	MaxAmount *decimal.Decimal
+	// From is inclusive and To exclusive
+	From *time.Time
+	To   *time.Time
+	// CounterpartyUserID and CounterpartyWalletID match the other side of a transfer
+	CounterpartyUserID   int
+	CounterpartyWalletID int
+	// Ascending returns the oldest transactions first, the default is newest first
+	Ascending bool
+	// Limit is the page size, DefaultHistoryLimit when zero
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// Directions of a history entry, seen from the wallet whose history it is.
+const (
+	DirectionDebit  = "debit"
+	DirectionCredit = "credit"
+)
+
+// HistoryPage is one page of a transaction history, NextCursor is empty on the last page.
+type HistoryPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
+
+/*
+A cursor is the created_at and id of the last transaction of a page, encoded
+so clients treat it as opaque. The next page continues strictly after that
+pair in the sort order, so rows inserted meanwhile never shift the pages.
+*/
+func encodeCursor(t Transaction) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.CreatedAt + "," + strconv.Itoa(t.ID)))
+}
+
+func decodeCursor(cursor string) (time.Time, int, error) {
+	invalid := fmt.Errorf("%w: malformed cursor", ErrInvalidFilter)
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, invalid
+	}
+	at, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return time.Time{}, 0, invalid
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, 0, invalid
+	}
+	txID, err := strconv.Atoi(id)
+	if err != nil {
+		return time.Time{}, 0, invalid
+	}
+	return createdAt, txID, nil
+}
+
+// GetTransactionHistory returns a page of the history of the default wallet of given user
+func (s *WalletService) GetTransactionHistory(ctx context.Context, userID int, filter HistoryFilter) (HistoryPage, error) {
+	walletID, err := s.DefaultWalletID(ctx, userID)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	return s.GetWalletTransactionHistory(ctx, walletID, filter)
+}
+
+/*
+GetWalletTransactionHistory returns a page of the history of given wallet.
+The history is read from the postings on the wallet's ledger account, so it
+holds money sent and received alike. Each entry carries its direction, the
+other side of a transfer and the balance right after it, computed over the
+whole account before filters apply, so a statement reconciles to the wallet
+balance whatever page or filter it was read with.
+
+The page is selected first, on the index of the account's postings. Balances
+are then anchored on the cached wallet balance, which CheckLedger holds equal
+to the postings: one sum of the postings newer than the page plus a running
+sum across the page's time span, whatever the length of the history.
+*/
+func (s *WalletService) GetWalletTransactionHistory(ctx context.Context, walletID int, filter HistoryFilter) (HistoryPage, error) {
+	page := HistoryPage{Transactions: []Transaction{}}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit < 0 || limit > MaxHistoryLimit {
+		return page, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxHistoryLimit)
+	}
+
+	if _, err := s.GetWallet(ctx, walletID); err != nil {
+		return page, err
+	}
+
+	args := []interface{}{walletID}
+	var where []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(filter.Types) > 0 {
+		placeholders := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			placeholders[i] = arg(t)
+		}
+		where = append(where, "t.type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.MinAmount != nil {
+		where = append(where, "ABS(p.amount) >= "+arg(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		where = append(where, "ABS(p.amount) <= "+arg(*filter.MaxAmount))
+	}
+	if filter.From != nil {
+		where = append(where, "p.created_at >= "+arg(filter.From.UTC()))
+	}
+	if filter.To != nil {
+		where = append(where, "p.created_at < "+arg(filter.To.UTC()))
+	}
+	if filter.CounterpartyUserID != 0 {
+		where = append(where, "CASE WHEN t.wallet_id = $1 THEN t.to_user_id ELSE t.user_id END = "+arg(filter.CounterpartyUserID))
+	}
+	if filter.CounterpartyWalletID != 0 {
+		where = append(where, "CASE WHEN t.wallet_id = $1 THEN t.to_wallet_id ELSE t.wallet_id END = "+arg(filter.CounterpartyWalletID))
+	}
+
+	order, after := "DESC", "<"
+	if filter.Ascending {
+		order, after = "ASC", ">"
+	}
+	if filter.Cursor != "" {
+		createdAt, id, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return page, err
+		}
+		where = append(where, fmt.Sprintf("(p.created_at, p.transaction_id) %s (%s, %s)", after, arg(createdAt), arg(id)))
+	}
+
+	//the page is selected on the postings of the account alone, one row more
+	//than asked for tells whether there is a next page. Entries are keyed by
+	//created_at and transaction id, an account has one posting per transaction
+	where = append([]string{"p.account_id = (SELECT id FROM accounts WHERE wallet_id = $1)"}, where...)
+	query := fmt.Sprintf(`WITH page AS (
+			SELECT t.id, t.user_id, t.wallet_id, t.type, t.amount, t.currency, t.fee, t.to_user_id, t.to_wallet_id,
+				t.to_amount, t.to_currency, t.fx_rate, t.quote_id, t.reverses_id, t.reason, p.created_at, p.amount AS change,
+				CASE WHEN t.wallet_id = $1 THEN t.to_user_id ELSE t.user_id END AS counterparty_user_id,
+				CASE WHEN t.wallet_id = $1 THEN t.to_wallet_id ELSE t.wallet_id END AS counterparty_wallet_id
+			FROM postings p
+			JOIN transactions t ON t.id = p.transaction_id
+			WHERE %s
+			ORDER BY p.created_at %s, p.transaction_id %s
+			LIMIT %s
+		),
+		span AS (
+			SELECT p.transaction_id,
+				SUM(p.amount) OVER (ORDER BY p.created_at DESC, p.transaction_id DESC) - p.amount AS newer
+			FROM postings p
+			WHERE p.account_id = (SELECT id FROM accounts WHERE wallet_id = $1)
+				AND p.created_at BETWEEN (SELECT MIN(created_at) FROM page) AND (SELECT MAX(created_at) FROM page)
+		),
+		anchor AS (
+			SELECT w.balance - COALESCE((
+				SELECT SUM(p.amount) FROM postings p
+				WHERE p.account_id = (SELECT id FROM accounts WHERE wallet_id = $1) AND p.created_at > (SELECT MAX(created_at) FROM page)
+			), 0) AS balance
+			FROM wallets w WHERE w.id = $1
+		)
+		SELECT page.id, page.user_id, page.wallet_id, page.type, page.amount, page.currency, page.fee, page.to_user_id, page.to_wallet_id,
+			page.to_amount, page.to_currency, page.fx_rate, page.quote_id, page.reverses_id, page.reason,
+			ARRAY(SELECT r.id FROM transactions r WHERE r.reverses_id = page.id ORDER BY r.id) AS reversed_by,
+			page.created_at, page.change, page.counterparty_user_id, page.counterparty_wallet_id,
+			anchor.balance - span.newer AS balance_after
+		FROM page
+		JOIN span ON span.transaction_id = page.id
+		CROSS JOIN anchor
+		ORDER BY page.created_at %s, page.id %s`,
+		strings.Join(where, " AND "), order, order, arg(limit+1), order, order)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transaction Transaction
+		var amount decimal.Decimal
+		var fee decimal.NullDecimal
+		//deposits and withdrawals have no receiver
+		var toUserID, toWalletID sql.NullInt64
+		//only conversions carry a target amount, currency, rate and quote
+		var toAmount, fxRate decimal.NullDecimal
+		var toCurrency, quoteID sql.NullString
+		//reversals point at their original, originals list their reversals
+		var reversesID sql.NullInt64
+		var reason sql.NullString
+		var reversedBy pq.Int64Array
+		var change decimal.Decimal
+		var counterpartyUserID, counterpartyWalletID sql.NullInt64
+		err := rows.Scan(&transaction.ID, &transaction.UserID, &transaction.WalletID, &transaction.Type, &amount, &transaction.Currency, &fee,
+			&toUserID, &toWalletID, &toAmount, &toCurrency, &fxRate, &quoteID,
+			&reversesID, &reason, &reversedBy, &transaction.CreatedAt,
+			&change, &counterpartyUserID, &counterpartyWalletID, &transaction.BalanceAfter)
+		if err != nil {
+			return page, err
+		}
+		transaction.Amount = amount
+		if fee.Valid {
+			transaction.Fee = &fee.Decimal
+		}
+		transaction.ToUserID = int(toUserID.Int64)
+		transaction.ToWalletID = int(toWalletID.Int64)
+		if toAmount.Valid {
+			transaction.ToAmount = &toAmount.Decimal
+			transaction.FXRate = &fxRate.Decimal
+		}
+		transaction.ToCurrency = toCurrency.String
+		transaction.QuoteID = quoteID.String
+		transaction.ReversesID = int(reversesID.Int64)
+		transaction.Reason = reason.String
+		transaction.ReversedBy = reversedBy
+		transaction.Direction = DirectionCredit
+		if change.Sign() < 0 {
+			transaction.Direction = DirectionDebit
+		}
+		transaction.CounterpartyUserID = int(counterpartyUserID.Int64)
+		transaction.CounterpartyWalletID = int(counterpartyWalletID.Int64)
+		page.Transactions = append(page.Transactions, transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Transactions) > limit {
+		page.Transactions = page.Transactions[:limit]
+		page.NextCursor = encodeCursor(page.Transactions[limit-1])
+	}
+	return page, nil
+}
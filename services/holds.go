@@ -0,0 +1,384 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+// Statuses a hold goes through, only an active hold reserves money.
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+// DefaultHoldTTL is how long a hold placed without an explicit ttl lasts.
+const DefaultHoldTTL = 7 * 24 * time.Hour
+
+/*
+Hold reserves Amount of a wallet balance. While active it lowers the
+available balance but not the ledger balance; capturing it books a
+withdrawal or transfer of at most Amount and releases the rest, voiding or
+expiring it releases everything.
+*/
+type Hold struct {
+	ID             int              `json:"id"`
+	WalletID       int              `json:"wallet_id"`
+	Amount         decimal.Decimal  `json:"amount"`
+	CapturedAmount *decimal.Decimal `json:"captured_amount,omitempty"`
+	Status         string           `json:"status"`
+	ExpiresAt      time.Time        `json:"expires_at"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+}
+
+const holdColumns = "id, wallet_id, amount, captured_amount, status, expires_at, created_at, updated_at"
+
+// scanHold scans the holdColumns of row into hold, extra receives the columns
+// selected after them
+func scanHold(row interface{ Scan(...interface{}) error }, hold *Hold, extra ...interface{}) error {
+	var captured decimal.NullDecimal
+	dest := []interface{}{&hold.ID, &hold.WalletID, &hold.Amount, &captured, &hold.Status, &hold.ExpiresAt, &hold.CreatedAt, &hold.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	if captured.Valid {
+		hold.CapturedAmount = &captured.Decimal
+	}
+	return err
+}
+
+// holdWalletID returns the wallet of a hold without locking anything, so the
+// wallet can be locked before the hold like every other operation does
+func holdWalletID(tx *sql.Tx, holdID int) (int, error) {
+	var walletID int
+	err := tx.QueryRow("SELECT wallet_id FROM holds WHERE id = $1", holdID).Scan(&walletID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %d", ErrHoldNotFound, holdID)
+	}
+	return walletID, err
+}
+
+// lockActiveHold locks a hold and fails unless it still reserves money,
+// an active hold past its expiry counts as expired even before the sweep
+func lockActiveHold(tx *sql.Tx, holdID int) (Hold, error) {
+	var hold Hold
+	var expired bool
+	err := scanHold(tx.QueryRow("SELECT "+holdColumns+", expires_at <= NOW() FROM holds WHERE id = $1 FOR UPDATE", holdID), &hold, &expired)
+	if err == sql.ErrNoRows {
+		return hold, fmt.Errorf("%w: %d", ErrHoldNotFound, holdID)
+	}
+	if err != nil {
+		return hold, err
+	}
+	if hold.Status != HoldActive || expired {
+		status := hold.Status
+		if status == HoldActive {
+			status = HoldExpired
+		}
+		return hold, fmt.Errorf("%w: hold %d is %s", ErrHoldNotActive, holdID, status)
+	}
+	return hold, nil
+}
+
+// releaseHold gives the reserved amount of hold back to the available balance of its wallet
+func releaseHold(tx *sql.Tx, hold Hold, status string, captured *decimal.Decimal) error {
+	_, err := tx.Exec("UPDATE wallets SET held = held - $1 WHERE id = $2", hold.Amount, hold.WalletID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE holds SET status = $1, captured_amount = $2, updated_at = NOW() WHERE id = $3", status, captured, hold.ID)
+	return err
+}
+
+// PlaceHold reserves amount of given wallet for ttl, DefaultHoldTTL when zero
+func (s *WalletService) PlaceHold(ctx context.Context, walletID int, amountStr string, ttl time.Duration) (Hold, error) {
+	var hold Hold
+	amount, err := ParseAmount(amountStr)
+	if err != nil {
+		return hold, err
+	}
+	if ttl < 0 {
+		return hold, fmt.Errorf("%w: hold ttl must not be negative", ErrInvalidAmount)
+	}
+	if ttl == 0 {
+		ttl = DefaultHoldTTL
+	}
+
+	err = retryTx(ctx, func() error {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := claimIdempotencyKey(ctx, tx); err != nil {
+			return err
+		}
+
+		wallet, err := lockWallet(tx, walletID)
+		if err != nil {
+			return err
+		}
+		//holding money reserves it for spending, which a frozen wallet may not do
+		if err := wallet.canSend(); err != nil {
+			return err
+		}
+		if err := ValidateAmount(wallet.Currency, amount); err != nil {
+			return err
+		}
+		if wallet.Available().LessThan(amount) {
+			return ErrInsufficientFunds
+		}
+
+		_, err = tx.Exec("UPDATE wallets SET held = $1 WHERE id = $2", wallet.Held.Add(amount), wallet.ID)
+		if err != nil {
+			return err
+		}
+		err = scanHold(tx.QueryRow(`INSERT INTO holds (wallet_id, amount, expires_at)
+			VALUES ($1, $2, NOW() + make_interval(secs => $3)) RETURNING `+holdColumns, wallet.ID, amount, ttl.Seconds()), &hold)
+		if err != nil {
+			return err
+		}
+		if err := storeIdempotentResult(ctx, tx, hold); err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, tx, AuditHoldPlace, auditDetails{"hold_id": hold.ID, "amount": amount}, wallet); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	return hold, err
+}
+
+func (s *WalletService) GetHold(ctx context.Context, holdID int) (Hold, error) {
+	var hold Hold
+	err := scanHold(s.DB.QueryRowContext(ctx, "SELECT "+holdColumns+" FROM holds WHERE id = $1", holdID), &hold)
+	if err == sql.ErrNoRows {
+		return hold, fmt.Errorf("%w: %d", ErrHoldNotFound, holdID)
+	}
+	return hold, err
+}
+
+/*
+CaptureHold turns an active hold into a withdrawal of amount, or into a
+transfer of amount to toWalletID when that is not zero. amount may be lower
+than the hold, the rest is released, a hold is captured only once.
+*/
+func (s *WalletService) CaptureHold(ctx context.Context, holdID int, amountStr string, toWalletID int) error {
+	amount, err := ParseAmount(amountStr)
+	if err != nil {
+		return err
+	}
+
+	return retryTx(ctx, func() error {
+		return s.captureHold(ctx, holdID, amount, toWalletID)
+	})
+}
+
+func (s *WalletService) captureHold(ctx context.Context, holdID int, amount decimal.Decimal, toWalletID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := claimIdempotencyKey(ctx, tx); err != nil {
+		return err
+	}
+
+	walletID, err := holdWalletID(tx, holdID)
+	if err != nil {
+		return err
+	}
+
+	//wallets are locked before the hold, the same order placing a hold uses
+	var from, to Wallet
+	if toWalletID == 0 {
+		from, err = lockWallet(tx, walletID)
+	} else if toWalletID == walletID {
+		return ErrSameWallet
+	} else {
+		from, to, err = lockWalletPair(tx, walletID, toWalletID)
+	}
+	if err != nil {
+		return err
+	}
+	//a capture spends the money, so a frozen wallet can only void its holds
+	if err := from.canSend(); err != nil {
+		return err
+	}
+	if toWalletID != 0 {
+		if err := to.canReceive(); err != nil {
+			return err
+		}
+	}
+	if toWalletID != 0 && from.Currency != to.Currency {
+		return fmt.Errorf("%w: %s to %s", ErrCurrencyMismatch, from.Currency, to.Currency)
+	}
+
+	hold, err := lockActiveHold(tx, holdID)
+	if err != nil {
+		return err
+	}
+	if err := ValidateAmount(from.Currency, amount); err != nil {
+		return err
+	}
+	if amount.GreaterThan(hold.Amount) {
+		return fmt.Errorf("%w: capture of %s exceeds hold of %s", ErrInvalidAmount, amount, hold.Amount)
+	}
+	//the money is spent when the hold is captured, so that is when limits apply
+	if err := checkLimits(ctx, tx, from, amount); err != nil {
+		return err
+	}
+
+	//the held money was already set aside, only the fee needs funds of its
+	//own, taken from what is available once the hold is released
+	operation := FeeWithdraw
+	if toWalletID != 0 {
+		operation = FeeTransfer
+	}
+	fee, err := s.fee(ctx, operation, from.Currency, amount)
+	if err != nil {
+		return err
+	}
+	if from.Available().Add(hold.Amount).Sub(amount).LessThan(fee) {
+		return ErrInsufficientFunds
+	}
+
+	if err := releaseHold(tx, hold, HoldCaptured, &amount); err != nil {
+		return err
+	}
+
+	link := sql.NullInt64{Int64: int64(hold.ID), Valid: true}
+	var txID int64
+	wallets := []Wallet{from}
+	if toWalletID == 0 {
+		txID, err = bookWithdrawal(tx, from, amount, fee, link)
+	} else {
+		txID, err = bookTransfer(tx, from, to, amount, fee, link)
+		wallets = append(wallets, to)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = recordAudit(ctx, tx, AuditHoldCapture, auditDetails{"hold_id": hold.ID, "transaction_id": txID, "amount": amount, "fee": fee}, wallets...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VoidHold releases an active hold without moving any money
+func (s *WalletService) VoidHold(ctx context.Context, holdID int) error {
+	return retryTx(ctx, func() error {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := claimIdempotencyKey(ctx, tx); err != nil {
+			return err
+		}
+
+		walletID, err := holdWalletID(tx, holdID)
+		if err != nil {
+			return err
+		}
+		wallet, err := lockWallet(tx, walletID)
+		if err != nil {
+			return err
+		}
+		hold, err := lockActiveHold(tx, holdID)
+		if err != nil {
+			return err
+		}
+
+		if err := releaseHold(tx, hold, HoldVoided, nil); err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, tx, AuditHoldVoid, auditDetails{"hold_id": hold.ID, "amount": hold.Amount}, wallet); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+/*
+ExpireHolds releases every active hold past its expiry and returns how many
+it released. Like capture and void it locks the wallets before their holds,
+in id order; wallets locked by an operation in flight are skipped, the next
+run picks up their holds if they are still active. A sweep that released
+anything is one audit event, with the balances of the wallets it released.
+*/
+func (s *WalletService) ExpireHolds(ctx context.Context) (int, error) {
+	var expired int
+	err := retryTx(ctx, func() error {
+		expired = 0
+		return inTx(ctx, s.DB, func(tx *sql.Tx) error {
+			rows, err := tx.QueryContext(ctx, `SELECT `+walletColumns+` FROM wallets
+				WHERE id IN (SELECT wallet_id FROM holds WHERE status = 'active' AND expires_at <= NOW())
+				ORDER BY id FOR UPDATE SKIP LOCKED`)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			var wallets []Wallet
+			var walletIDs []int64
+			for rows.Next() {
+				var wallet Wallet
+				if err := scanWallet(rows, &wallet); err != nil {
+					return err
+				}
+				wallets = append(wallets, wallet)
+				walletIDs = append(walletIDs, int64(wallet.ID))
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			if len(wallets) == 0 {
+				return nil
+			}
+
+			//nothing else locks holds of a wallet without the wallet, so these
+			//locks are free; the expiry is read again now that it is settled
+			var holdIDs, releasedIDs pq.Int64Array
+			err = tx.QueryRowContext(ctx, `WITH expired AS (
+					UPDATE holds SET status = 'expired', updated_at = NOW()
+					WHERE id IN (
+						SELECT id FROM holds WHERE wallet_id = ANY($1) AND status = 'active' AND expires_at <= NOW()
+						ORDER BY id FOR UPDATE
+					)
+					RETURNING id, wallet_id, amount
+				), released AS (
+					UPDATE wallets w SET held = w.held - e.total
+					FROM (SELECT wallet_id, SUM(amount) AS total FROM expired GROUP BY wallet_id) e
+					WHERE w.id = e.wallet_id
+				)
+				SELECT COUNT(*), COALESCE(array_agg(id ORDER BY id), '{}'), COALESCE(array_agg(DISTINCT wallet_id), '{}') FROM expired`,
+				pq.Array(walletIDs)).Scan(&expired, &holdIDs, &releasedIDs)
+			if err != nil || expired == 0 {
+				return err
+			}
+			//a wallet whose holds were captured meanwhile had nothing released
+			released := map[int64]bool{}
+			for _, id := range releasedIDs {
+				released[id] = true
+			}
+			var before []Wallet
+			for _, wallet := range wallets {
+				if released[int64(wallet.ID)] {
+					before = append(before, wallet)
+				}
+			}
+			return recordAudit(ctx, tx, AuditHoldsExpire, auditDetails{"hold_ids": []int64(holdIDs)}, before...)
+		})
+	})
+	return expired, err
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrIdempotencyKeyReused is returned when a key comes back with a different request.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// IdempotencyKey is what a caller wants stored alongside an operation so a
+// retry with the same key gets the same answer instead of running it again.
+type IdempotencyKey struct {
+	// Scope separates the keys of different callers, a key is only looked
+	// up within its scope. Scopes starting with "internal:" are the server's own
+	Scope string
+	Key   string
+	// Fingerprint identifies the request the key was first used for
+	Fingerprint string
+	// Status and Body are the response returned once the operation commits
+	Status int
+	Body   []byte
+	// Render, when set, builds the stored body from the outcome of the
+	// operation instead, for responses that are only known once it ran
+	Render func(result interface{}) ([]byte, error)
+}
+
+// IdempotentReplay is returned instead of running an operation whose key
+// has already been committed, it carries the response stored back then.
+type IdempotentReplay struct {
+	Status int
+	Body   []byte
+}
+
+func (r *IdempotentReplay) Error() string {
+	return fmt.Sprintf("request already processed with status %d", r.Status)
+}
+
+type idempotencyCtxKey struct{}
+
+// WithIdempotencyKey attaches key to ctx, Deposit, Withdraw and Transfer
+// persist it in the same db transaction as the balance change.
+func WithIdempotencyKey(ctx context.Context, key *IdempotencyKey) context.Context {
+	return context.WithValue(ctx, idempotencyCtxKey{}, key)
+}
+
+func idempotencyKeyFrom(ctx context.Context) *IdempotencyKey {
+	key, _ := ctx.Value(idempotencyCtxKey{}).(*IdempotencyKey)
+	return key
+}
+
+/*
+claimIdempotencyKey stores the key from ctx inside tx before anything else
+happens. If another transaction holds the same key, postgres makes the insert
+wait until that one finishes, so two concurrent retries can never both run.
+When the key is already committed the stored response is returned as an
+*IdempotentReplay error, or ErrIdempotencyKeyReused if the request differs.
+*/
+func claimIdempotencyKey(ctx context.Context, tx *sql.Tx) error {
+	key := idempotencyKeyFrom(ctx)
+	if key == nil {
+		return nil
+	}
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO idempotency_keys (scope, key, fingerprint, response_status, response_body)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (scope, key) DO NOTHING`, key.Scope, key.Key, key.Fingerprint, key.Status, key.Body)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 1 {
+		return nil
+	}
+
+	var fingerprint string
+	replay := &IdempotentReplay{}
+	err = tx.QueryRowContext(ctx, "SELECT fingerprint, response_status, response_body FROM idempotency_keys WHERE scope = $1 AND key = $2", key.Scope, key.Key).
+		Scan(&fingerprint, &replay.Status, &replay.Body)
+	if err != nil {
+		return err
+	}
+	if fingerprint != key.Fingerprint {
+		return ErrIdempotencyKeyReused
+	}
+	return replay
+}
+
+// storeIdempotentResult replaces the response stored with the key from ctx by
+// the one its Render makes of result, inside the tx that claimed the key
+func storeIdempotentResult(ctx context.Context, tx *sql.Tx, result interface{}) error {
+	key := idempotencyKeyFrom(ctx)
+	if key == nil || key.Render == nil {
+		return nil
+	}
+	body, err := key.Render(result)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE idempotency_keys SET response_body = $1 WHERE scope = $2 AND key = $3", body, key.Scope, key.Key)
+	return err
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+/*
+Every balance change is booked as a journal: the transactions row is the
+journal header and its postings are the legs. A posting with a positive
+amount increases the balance of its account and a negative one decreases
+it. Postings carry the currency they were booked in and the postings of one
+transaction, and therefore of the whole ledger, always add up to zero in
+each currency. wallets.balance is a cache of the sum of the postings on the
+wallet's account and is updated in the same db transaction.
+*/
+
+// System accounts for money entering or leaving the wallets.
+const (
+	AccountExternalDeposits    = "external:deposits"
+	AccountExternalWithdrawals = "external:withdrawals"
+	AccountOpeningBalances     = "equity:opening_balances"
+)
+
+// Posting is one leg of a journal entry.
+type Posting struct {
+	AccountID int64           `json:"account_id"`
+	Amount    decimal.Decimal `json:"amount"`
+	Currency  string          `json:"currency"`
+}
+
+// LedgerReport is the result of checking the ledger invariants.
+type LedgerReport struct {
+	// Totals is the sum of every posting per currency, each must be zero
+	Totals map[string]decimal.Decimal `json:"totals"`
+	// UnbalancedTransactions lists journals whose postings do not sum to zero
+	UnbalancedTransactions []int64 `json:"unbalanced_transactions"`
+	// MismatchedWallets lists wallets whose cached balance differs from their postings
+	MismatchedWallets []WalletMismatch `json:"mismatched_wallets"`
+}
+
+type WalletMismatch struct {
+	WalletID      int64           `json:"wallet_id"`
+	Balance       decimal.Decimal `json:"balance"`
+	LedgerBalance decimal.Decimal `json:"ledger_balance"`
+}
+
+// OK reports whether every invariant holds.
+func (r *LedgerReport) OK() bool {
+	for _, total := range r.Totals {
+		if !total.IsZero() {
+			return false
+		}
+	}
+	return len(r.UnbalancedTransactions) == 0 && len(r.MismatchedWallets) == 0
+}
+
+func walletAccountID(tx *sql.Tx, walletID int64) (int64, error) {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM accounts WHERE wallet_id = $1", walletID).Scan(&id)
+	return id, err
+}
+
+func systemAccountID(tx *sql.Tx, code string) (int64, error) {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM accounts WHERE code = $1", code).Scan(&id)
+	return id, err
+}
+
+// postJournal writes the postings of one transaction, refusing any set of
+// legs that would leave the ledger unbalanced in one of its currencies
+func postJournal(tx *sql.Tx, transactionID int64, postings []Posting) error {
+	sums := map[string]decimal.Decimal{}
+	for _, p := range postings {
+		sums[p.Currency] = sums[p.Currency].Add(p.Amount)
+	}
+	for currency, sum := range sums {
+		if !sum.IsZero() {
+			return fmt.Errorf("unbalanced journal for transaction %d: %s postings sum to %s", transactionID, currency, sum)
+		}
+	}
+
+	for _, p := range postings {
+		_, err := tx.Exec("INSERT INTO postings (transaction_id, account_id, amount, currency) VALUES ($1, $2, $3, $4)", transactionID, p.AccountID, p.Amount, p.Currency)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckLedger verifies that all postings sum to zero per currency, that every
+// journal is balanced and that every cached wallet balance equals its postings.
+func (s *WalletService) CheckLedger() (*LedgerReport, error) {
+	report := &LedgerReport{Totals: map[string]decimal.Decimal{}}
+
+	rows, err := s.DB.Query("SELECT currency, SUM(amount) FROM postings GROUP BY currency ORDER BY currency")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var total decimal.Decimal
+		if err := rows.Scan(&currency, &total); err != nil {
+			return nil, err
+		}
+		report.Totals[currency] = total
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.DB.Query("SELECT DISTINCT transaction_id FROM postings GROUP BY transaction_id, currency HAVING SUM(amount) <> 0 ORDER BY transaction_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		report.UnbalancedTransactions = append(report.UnbalancedTransactions, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.DB.Query(`
+		SELECT w.id, w.balance, COALESCE(SUM(p.amount), 0)
+		FROM wallets w
+		JOIN accounts a ON a.wallet_id = w.id
+		LEFT JOIN postings p ON p.account_id = a.id
+		GROUP BY w.id, w.balance
+		HAVING w.balance <> COALESCE(SUM(p.amount), 0)
+		ORDER BY w.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m WalletMismatch
+		if err := rows.Scan(&m.WalletID, &m.Balance, &m.LedgerBalance); err != nil {
+			return nil, err
+		}
+		report.MismatchedWallets = append(report.MismatchedWallets, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
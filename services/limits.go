@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultTier is the tier every wallet starts in.
+const DefaultTier = "standard"
+
+// Limit kinds, LimitExceededError names the one that was hit.
+const (
+	LimitPerTransaction = "per_transaction"
+	LimitDailyAmount    = "daily_amount"
+	LimitMonthlyAmount  = "monthly_amount"
+	LimitDailyCount     = "daily_count"
+	LimitMonthlyCount   = "monthly_count"
+)
+
+// Where the limits of a wallet come from.
+const (
+	LimitSourceWallet = "wallet"
+	LimitSourceTier   = "tier"
+	LimitSourceNone   = "none"
+)
+
+var tierName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+/*
+Limits caps the money a wallet sends through withdrawals and transfers,
+conversions and hold captures included. A nil field is not enforced. The
+daily and monthly windows roll, they cover the last 24 hours and 30 days
+rather than calendar days, and money given back by reversals does not free
+up allowance.
+*/
+type Limits struct {
+	MaxPerTransaction *decimal.Decimal `json:"max_per_transaction"`
+	DailyAmount       *decimal.Decimal `json:"daily_amount"`
+	MonthlyAmount     *decimal.Decimal `json:"monthly_amount"`
+	DailyCount        *int             `json:"daily_count"`
+	MonthlyCount      *int             `json:"monthly_count"`
+}
+
+// LimitUsage is what a wallet sent within the rolling windows.
+type LimitUsage struct {
+	DailyAmount   decimal.Decimal `json:"daily_amount"`
+	MonthlyAmount decimal.Decimal `json:"monthly_amount"`
+	DailyCount    int             `json:"daily_count"`
+	MonthlyCount  int             `json:"monthly_count"`
+}
+
+// WalletLimits reports the limits in force for a wallet and how much of them is used.
+type WalletLimits struct {
+	WalletID int    `json:"wallet_id"`
+	Tier     string `json:"tier"`
+	Currency string `json:"currency"`
+	// Source is LimitSourceWallet, LimitSourceTier or LimitSourceNone
+	Source string     `json:"source"`
+	Limits Limits     `json:"limits"`
+	Used   LimitUsage `json:"used"`
+}
+
+// LimitExceededError is returned when a withdrawal or transfer would break
+// a limit, errors.Is matches it with ErrLimitExceeded.
+type LimitExceededError struct {
+	WalletID int
+	Limit    string
+	// Remaining is the allowance left under Limit, an amount in Currency or
+	// a number of transactions for the count limits
+	Remaining decimal.Decimal
+	Currency  string
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Limit == LimitDailyCount || e.Limit == LimitMonthlyCount {
+		return fmt.Sprintf("%v: wallet %d reached its %s limit, %s transactions remaining", ErrLimitExceeded, e.WalletID, e.Limit, e.Remaining)
+	}
+	return fmt.Sprintf("%v: wallet %d would exceed its %s limit, %s %s remaining",
+		ErrLimitExceeded, e.WalletID, e.Limit, FormatAmount(e.Currency, e.Remaining), e.Currency)
+}
+
+func (e *LimitExceededError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func (l Limits) validate() error {
+	for _, v := range []*decimal.Decimal{l.MaxPerTransaction, l.DailyAmount, l.MonthlyAmount} {
+		if v != nil && v.Sign() < 0 {
+			return fmt.Errorf("%w: limits must not be negative", ErrInvalidLimits)
+		}
+	}
+	for _, v := range []*int{l.DailyCount, l.MonthlyCount} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%w: limits must not be negative", ErrInvalidLimits)
+		}
+	}
+	return nil
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// limitsFor returns the limits in force for wallet, its own ones win over those of its tier
+func limitsFor(ctx context.Context, q querier, wallet Wallet) (Limits, string, error) {
+	var limits Limits
+	var walletID sql.NullInt64
+	var perTx, daily, monthly decimal.NullDecimal
+	var dailyCount, monthlyCount sql.NullInt32
+	err := q.QueryRowContext(ctx, `SELECT wallet_id, max_per_transaction, daily_amount, monthly_amount, daily_count, monthly_count
+		FROM wallet_limits WHERE wallet_id = $1 OR (tier = $2 AND currency = $3)
+		ORDER BY wallet_id NULLS LAST LIMIT 1`, wallet.ID, wallet.Tier, wallet.Currency).
+		Scan(&walletID, &perTx, &daily, &monthly, &dailyCount, &monthlyCount)
+	if err == sql.ErrNoRows {
+		return limits, LimitSourceNone, nil
+	}
+	if err != nil {
+		return limits, "", err
+	}
+
+	if perTx.Valid {
+		limits.MaxPerTransaction = &perTx.Decimal
+	}
+	if daily.Valid {
+		limits.DailyAmount = &daily.Decimal
+	}
+	if monthly.Valid {
+		limits.MonthlyAmount = &monthly.Decimal
+	}
+	if dailyCount.Valid {
+		n := int(dailyCount.Int32)
+		limits.DailyCount = &n
+	}
+	if monthlyCount.Valid {
+		n := int(monthlyCount.Int32)
+		limits.MonthlyCount = &n
+	}
+	if walletID.Valid {
+		return limits, LimitSourceWallet, nil
+	}
+	return limits, LimitSourceTier, nil
+}
+
+// limitUsage sums what wallet sent within the rolling windows
+func limitUsage(ctx context.Context, q querier, walletID int) (LimitUsage, error) {
+	var used LimitUsage
+	err := q.QueryRowContext(ctx, `SELECT
+			COALESCE(SUM(amount) FILTER (WHERE created_at > NOW() - INTERVAL '1 day'), 0),
+			COALESCE(SUM(amount), 0),
+			COUNT(*) FILTER (WHERE created_at > NOW() - INTERVAL '1 day'),
+			COUNT(*)
+		FROM transactions
+		WHERE wallet_id = $1 AND type IN ('withdraw', 'transfer') AND created_at > NOW() - INTERVAL '30 days'`, walletID).
+		Scan(&used.DailyAmount, &used.MonthlyAmount, &used.DailyCount, &used.MonthlyCount)
+	return used, err
+}
+
+/*
+checkLimits is called with the sending wallet locked, so concurrent sends
+from one wallet queue up on that lock and each one sees the totals of those
+committed before it. The per-transaction maximum is checked first as it
+needs no query.
+*/
+func checkLimits(ctx context.Context, tx *sql.Tx, wallet Wallet, amount decimal.Decimal) error {
+	limits, source, err := limitsFor(ctx, tx, wallet)
+	if err != nil || source == LimitSourceNone {
+		return err
+	}
+
+	exceeded := func(limit string, remaining decimal.Decimal) error {
+		if remaining.Sign() < 0 {
+			remaining = decimal.Zero
+		}
+		return &LimitExceededError{WalletID: wallet.ID, Limit: limit, Remaining: remaining, Currency: wallet.Currency}
+	}
+	if limits.MaxPerTransaction != nil && amount.GreaterThan(*limits.MaxPerTransaction) {
+		return exceeded(LimitPerTransaction, *limits.MaxPerTransaction)
+	}
+	if limits.DailyAmount == nil && limits.MonthlyAmount == nil && limits.DailyCount == nil && limits.MonthlyCount == nil {
+		return nil
+	}
+
+	used, err := limitUsage(ctx, tx, wallet.ID)
+	if err != nil {
+		return err
+	}
+	if limits.DailyCount != nil && used.DailyCount >= *limits.DailyCount {
+		return exceeded(LimitDailyCount, decimal.NewFromInt(int64(*limits.DailyCount-used.DailyCount)))
+	}
+	if limits.MonthlyCount != nil && used.MonthlyCount >= *limits.MonthlyCount {
+		return exceeded(LimitMonthlyCount, decimal.NewFromInt(int64(*limits.MonthlyCount-used.MonthlyCount)))
+	}
+	if limits.DailyAmount != nil && used.DailyAmount.Add(amount).GreaterThan(*limits.DailyAmount) {
+		return exceeded(LimitDailyAmount, limits.DailyAmount.Sub(used.DailyAmount))
+	}
+	if limits.MonthlyAmount != nil && used.MonthlyAmount.Add(amount).GreaterThan(*limits.MonthlyAmount) {
+		return exceeded(LimitMonthlyAmount, limits.MonthlyAmount.Sub(used.MonthlyAmount))
+	}
+	return nil
+}
+
+// GetWalletLimits returns the limits in force for given wallet and what it sent within them
+func (s *WalletService) GetWalletLimits(ctx context.Context, walletID int) (WalletLimits, error) {
+	wallet, err := s.GetWallet(ctx, walletID)
+	if err != nil {
+		return WalletLimits{}, err
+	}
+	result := WalletLimits{WalletID: wallet.ID, Tier: wallet.Tier, Currency: wallet.Currency}
+	result.Limits, result.Source, err = limitsFor(ctx, s.DB, wallet)
+	if err != nil {
+		return result, err
+	}
+	result.Used, err = limitUsage(ctx, s.DB, wallet.ID)
+	return result, err
+}
+
+// SetWalletLimits gives a wallet limits of its own, they replace those of its tier as a whole
+func (s *WalletService) SetWalletLimits(ctx context.Context, walletID int, limits Limits) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	return inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO wallet_limits (wallet_id, max_per_transaction, daily_amount, monthly_amount, daily_count, monthly_count)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (wallet_id) WHERE wallet_id IS NOT NULL DO UPDATE SET
+				max_per_transaction = EXCLUDED.max_per_transaction, daily_amount = EXCLUDED.daily_amount,
+				monthly_amount = EXCLUDED.monthly_amount, daily_count = EXCLUDED.daily_count,
+				monthly_count = EXCLUDED.monthly_count, updated_at = CURRENT_TIMESTAMP`,
+			walletID, limits.MaxPerTransaction, limits.DailyAmount, limits.MonthlyAmount, limits.DailyCount, limits.MonthlyCount)
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: %d", ErrWalletNotFound, walletID)
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditWalletLimits, auditDetails{"wallet_id": walletID, "limits": limits})
+	})
+}
+
+// ClearWalletLimits drops the limits of a wallet, those of its tier apply again
+func (s *WalletService) ClearWalletLimits(ctx context.Context, walletID int) error {
+	if _, err := s.GetWallet(ctx, walletID); err != nil {
+		return err
+	}
+	return inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM wallet_limits WHERE wallet_id = $1", walletID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditWalletLimits, auditDetails{"wallet_id": walletID, "limits": nil})
+	})
+}
+
+// SetTierLimits sets the limits of every wallet of tier in currency that has none of its own
+func (s *WalletService) SetTierLimits(ctx context.Context, tier, currency string, limits Limits) error {
+	if !tierName.MatchString(tier) {
+		return ErrInvalidTier
+	}
+	currency, err := NormalizeCurrency(currency)
+	if err != nil {
+		return err
+	}
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	return inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO wallet_limits (tier, currency, max_per_transaction, daily_amount, monthly_amount, daily_count, monthly_count)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (tier, currency) WHERE tier IS NOT NULL DO UPDATE SET
+				max_per_transaction = EXCLUDED.max_per_transaction, daily_amount = EXCLUDED.daily_amount,
+				monthly_amount = EXCLUDED.monthly_amount, daily_count = EXCLUDED.daily_count,
+				monthly_count = EXCLUDED.monthly_count, updated_at = CURRENT_TIMESTAMP`,
+			tier, currency, limits.MaxPerTransaction, limits.DailyAmount, limits.MonthlyAmount, limits.DailyCount, limits.MonthlyCount)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditTierLimits, auditDetails{"tier": tier, "currency": currency, "limits": limits})
+	})
+}
+
+// SetWalletTier moves a wallet to another tier
+func (s *WalletService) SetWalletTier(ctx context.Context, walletID int, tier string) error {
+	if !tierName.MatchString(tier) {
+		return ErrInvalidTier
+	}
+	return inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		var previous string
+		err := tx.QueryRowContext(ctx, "SELECT tier FROM wallets WHERE id = $1 FOR UPDATE", walletID).Scan(&previous)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrWalletNotFound, walletID)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE wallets SET tier = $1 WHERE id = $2", tier, walletID); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditWalletTier, auditDetails{"wallet_id": walletID, "from_tier": previous, "to_tier": tier})
+	})
+}
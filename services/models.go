@@ -1,25 +1,89 @@
 package services
 
-import "github.com/shopspring/decimal"
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// SchemaVersion is the migration version the queries in this package are
+// written against, main refuses to start when the database is at another one.
+const SchemaVersion = 20
+
+type User struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
 
 type Wallet struct {
 	ID      int             `json:"id"`
 	UserID  int             `json:"user_id"`
 	Balance decimal.Decimal `json:"balance"`
+	// Held is the part of Balance reserved by active holds
+	Held      decimal.Decimal `json:"held"`
+	Currency  string          `json:"currency"`
+	IsDefault bool            `json:"is_default"`
+	// Status is one of WalletActive, WalletFrozen and WalletClosed
+	Status string `json:"status"`
+	// Tier picks the limits that apply when the wallet has none of its own
+	Tier string `json:"tier"`
+}
+
+// Available is the balance that can still be withdrawn, sent or held.
+func (w Wallet) Available() decimal.Decimal {
+	return w.Balance.Sub(w.Held)
+}
+
+// Balance reports the ledger balance of a wallet next to the part of it
+// that is not reserved by holds.
+type Balance struct {
+	Total     decimal.Decimal `json:"total"`
+	Available decimal.Decimal `json:"available"`
+	Currency  string          `json:"currency"`
 }
 
 type Transaction struct {
-	ID        int             `json:"id"`
-	UserID    int             `json:"user_id"`
-	Type      string          `json:"type"`
-	Amount    decimal.Decimal `json:"amount"`
-	ToUserID  int             `json:"to_user_id,omitempty"`
-	CreatedAt string          `json:"created_at"`
+	ID         int             `json:"id"`
+	UserID     int             `json:"user_id"`
+	WalletID   int             `json:"wallet_id"`
+	Type       string          `json:"type"`
+	Amount     decimal.Decimal `json:"amount"`
+	Currency   string          `json:"currency"`
+	ToUserID   int             `json:"to_user_id,omitempty"`
+	ToWalletID int             `json:"to_wallet_id,omitempty"`
+	// ToAmount, ToCurrency, FXRate and QuoteID are set for converting transfers
+	ToAmount   *decimal.Decimal `json:"to_amount,omitempty"`
+	ToCurrency string           `json:"to_currency,omitempty"`
+	FXRate     *decimal.Decimal `json:"fx_rate,omitempty"`
+	QuoteID    string           `json:"quote_id,omitempty"`
+	// Fee is charged on top of Amount, on a reversal it is the part of the fee given back
+	Fee *decimal.Decimal `json:"fee,omitempty"`
+	// ReversesID and Reason are set on reversals, ReversedBy lists the reversals of a transaction
+	ReversesID int     `json:"reverses_id,omitempty"`
+	Reason     string  `json:"reason,omitempty"`
+	ReversedBy []int64 `json:"reversed_by,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+	// Direction, the counterparty and BalanceAfter are relative to the wallet whose history is read
+	Direction            string          `json:"direction"`
+	CounterpartyUserID   int             `json:"counterparty_user_id,omitempty"`
+	CounterpartyWalletID int             `json:"counterparty_wallet_id,omitempty"`
+	BalanceAfter         decimal.Decimal `json:"balance_after"`
 }
 
 func ParseAmount(amountStr string) (decimal.Decimal, error) {
 	/*
-		convert decimal string to decimal value
+		convert decimal string to decimal value, the amount of money moved
+		by any operation must be a positive number
 	*/
-	return decimal.NewFromString(amountStr)
+	amount, err := decimal.NewFromString(amountStr)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: %q is not a number", ErrInvalidAmount, amountStr)
+	}
+	if amount.Sign() <= 0 {
+		return decimal.Zero, fmt.Errorf("%w: amount must be greater than 0", ErrInvalidAmount)
+	}
+	return amount, nil
 }
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Resources whose owner ResourceOwner can tell.
+const (
+	ResourceWallet   = "wallet"
+	ResourceHold     = "hold"
+	ResourceSchedule = "schedule"
+)
+
+// ownerQueries find the user a resource belongs to, a hold or schedule
+// belongs to the owner of the wallet it takes money from
+var ownerQueries = map[string]struct {
+	query    string
+	notFound error
+}{
+	ResourceWallet:   {"SELECT user_id FROM wallets WHERE id = $1", ErrWalletNotFound},
+	ResourceHold:     {"SELECT w.user_id FROM holds h JOIN wallets w ON w.id = h.wallet_id WHERE h.id = $1", ErrHoldNotFound},
+	ResourceSchedule: {"SELECT w.user_id FROM scheduled_transfers st JOIN wallets w ON w.id = st.from_wallet_id WHERE st.id = $1", ErrScheduleNotFound},
+}
+
+// ResourceOwner returns the id of the user who owns the resource of given
+// kind, so callers can check it is the one acting on it
+func (s *WalletService) ResourceOwner(ctx context.Context, resource string, id int) (int, error) {
+	q, ok := ownerQueries[resource]
+	if !ok {
+		return 0, fmt.Errorf("unknown resource %q", resource)
+	}
+	var userID int
+	err := s.DB.QueryRowContext(ctx, q.query, id).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %d", q.notFound, id)
+	}
+	return userID, err
+}
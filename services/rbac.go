@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Roles a principal can hold. Every user is a customer, the others are
+// assigned to users with AssignRole or given to API keys and tokens as scopes.
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleFinance  = "finance"
+	RoleAdmin    = "admin"
+)
+
+// Permissions checked by the routes. PermAnyAccount decides whose wallets a
+// principal may act on, the others what it may do with them. Only what a role
+// granting PermAnyAccount grants as well counts on the wallets of others, a
+// support agent moves money on their own wallets as a customer but on nobody
+// else's.
+const (
+	PermWalletsRead         = "wallets:read"
+	PermWalletsWrite        = "wallets:write"
+	PermProfileRead         = "profile:read"
+	PermProfileWrite        = "profile:write"
+	PermAnyAccount          = "accounts:any"
+	PermUsersList           = "users:list"
+	PermUsersCreate         = "users:create"
+	PermWalletsFreeze       = "wallets:freeze"
+	PermWalletsClose        = "wallets:close"
+	PermLimitsManage        = "limits:manage"
+	PermTransactionsReverse = "transactions:reverse"
+	PermAPIKeysManage       = "api_keys:manage"
+	PermRolesManage         = "roles:manage"
+)
+
+// rolePermissions is what each role may do. Support looks into and freezes
+// accounts, finance manages limits and reversals, admin does everything.
+var rolePermissions = map[string][]string{
+	RoleCustomer: {PermWalletsRead, PermWalletsWrite, PermProfileRead, PermProfileWrite},
+	RoleSupport:  {PermWalletsRead, PermProfileRead, PermAnyAccount, PermUsersList, PermWalletsFreeze},
+	RoleFinance:  {PermWalletsRead, PermProfileRead, PermAnyAccount, PermUsersList, PermLimitsManage, PermTransactionsReverse},
+	RoleAdmin: {PermWalletsRead, PermWalletsWrite, PermProfileRead, PermProfileWrite, PermAnyAccount, PermUsersList, PermUsersCreate,
+		PermWalletsFreeze, PermWalletsClose, PermLimitsManage, PermTransactionsReverse, PermAPIKeysManage, PermRolesManage},
+}
+
+// IsRole reports whether role is one of the known roles.
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Permissions returns the set of permissions roles grant together, unknown roles grant nothing.
+func Permissions(roles []string) map[string]bool {
+	perms := map[string]bool{}
+	for _, role := range roles {
+		for _, perm := range rolePermissions[role] {
+			perms[perm] = true
+		}
+	}
+	return perms
+}
+
+// RoleAssignment is a role given to a user.
+type RoleAssignment struct {
+	UserID    int       `json:"user_id"`
+	Role      string    `json:"role"`
+	GrantedBy string    `json:"granted_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserRoles returns the roles of a user, customer included, in name order
+func (s *UserService) UserRoles(ctx context.Context, userID int) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT role FROM role_assignments WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{RoleCustomer}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles, rows.Err()
+}
+
+// AssignRole gives a user a role, actor is recorded as who granted it.
+// Assigning a role the user already holds changes nothing, the assignment
+// keeps who granted it first and no audit event is written.
+func (s *UserService) AssignRole(ctx context.Context, userID int, role, actor string) (RoleAssignment, error) {
+	assignment := RoleAssignment{UserID: userID, Role: role}
+	if !IsRole(role) || role == RoleCustomer {
+		return assignment, fmt.Errorf("%w: %q, assignable roles are %s, %s and %s", ErrInvalidRole, role, RoleSupport, RoleFinance, RoleAdmin)
+	}
+	actor = strings.TrimSpace(actor)
+	if actor == "" || utf8.RuneCountInString(actor) > MaxStatusActor {
+		return assignment, fmt.Errorf("%w: must be 1 to %d characters", ErrInvalidActor, MaxStatusActor)
+	}
+
+	err := inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		//the no-op update makes RETURNING yield the existing row as well,
+		//xmax is only set on a row that was there already
+		var existed bool
+		err := tx.QueryRowContext(ctx, `INSERT INTO role_assignments (user_id, role, granted_by) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, role) DO UPDATE SET role = EXCLUDED.role
+			RETURNING granted_by, created_at, xmax <> 0`, userID, role, actor).Scan(&assignment.GrantedBy, &assignment.CreatedAt, &existed)
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+		}
+		if err != nil || existed {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditRoleAssign, auditDetails{"user_id": userID, "role": role, "granted_by": assignment.GrantedBy})
+	})
+	return assignment, err
+}
+
+// RevokeRole takes a role from a user, revoking a role it does not hold changes nothing
+func (s *UserService) RevokeRole(ctx context.Context, userID int, role string) error {
+	if !IsRole(role) || role == RoleCustomer {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	return inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM role_assignments WHERE user_id = $1 AND role = $2", userID, role)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditRoleRevoke, auditDetails{"user_id": userID, "role": role})
+	})
+}
+
+// AnyAccountPermissions returns the permissions roles grant on the accounts
+// of every user, those of the roles that grant PermAnyAccount.
+func AnyAccountPermissions(roles []string) map[string]bool {
+	perms := map[string]bool{}
+	for _, role := range roles {
+		granted := Permissions([]string{role})
+		if !granted[PermAnyAccount] {
+			continue
+		}
+		for perm := range granted {
+			perms[perm] = true
+		}
+	}
+	return perms
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrences a schedule can be created with, anything else is read as a
+// five field cron expression.
+const (
+	RecurOnce    = "once"
+	RecurDaily   = "daily"
+	RecurWeekly  = "weekly"
+	RecurMonthly = "monthly"
+)
+
+// cronSearchLimit bounds the search for the next match of a cron
+// expression, one that matches nothing within it (like "0 0 31 2 *") is
+// rejected when the schedule is created.
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// recurrence tells when the runs of a schedule fall. daily, weekly and
+// monthly repeat the first run at the same time of day, monthly on the same
+// day of the month or on the last day of shorter months. A cron expression
+// has the fields minute, hour, day of month, month and day of week (0 or 7
+// is Sunday), each "*", a number, a range "1-5", a list "1,15" or a step
+// "*/10" or "0-30/5". When both day fields are restricted a day matching
+// either one is taken, like cron does. Every time is UTC.
+type recurrence struct {
+	keyword string
+	cron    *cronSpec
+}
+
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field, which cron treats differently
+	domAny, dowAny bool
+}
+
+func parseRecurrence(s string) (recurrence, error) {
+	switch s {
+	case RecurOnce, RecurDaily, RecurWeekly, RecurMonthly:
+		return recurrence{keyword: s}, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return recurrence{}, fmt.Errorf("%w: recurrence must be once, daily, weekly, monthly or a five field cron expression", ErrInvalidSchedule)
+	}
+	var spec cronSpec
+	bounds := []struct {
+		dst      *uint64
+		min, max int
+	}{
+		{&spec.minute, 0, 59},
+		{&spec.hour, 0, 23},
+		{&spec.dom, 1, 31},
+		{&spec.month, 1, 12},
+		{&spec.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return recurrence{}, fmt.Errorf("%w: cron field %q: %v", ErrInvalidSchedule, fields[i], err)
+		}
+		*b.dst = bits
+	}
+	//7 is another name for sunday
+	if spec.dow&(1<<7) != 0 {
+		spec.dow = spec.dow&^(1<<7) | 1
+	}
+	spec.domAny = fields[2] == "*"
+	spec.dowAny = fields[4] == "*"
+	return recurrence{cron: &spec}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", stepStr)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("bad value %q", loStr)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("bad value %q", hiStr)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("values must be between %d and %d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first run after prev of a schedule whose first run was
+// first, false when there is none
+func (r recurrence) next(first, prev time.Time) (time.Time, bool) {
+	first, prev = first.UTC(), prev.UTC()
+	switch r.keyword {
+	case RecurOnce:
+		return time.Time{}, false
+	case RecurDaily, RecurWeekly:
+		days := 1
+		if r.keyword == RecurWeekly {
+			days = 7
+		}
+		if prev.Before(first) {
+			return first, true
+		}
+		n := int(prev.Sub(first)/(time.Duration(days)*24*time.Hour)) + 1
+		return first.AddDate(0, 0, n*days), true
+	case RecurMonthly:
+		if prev.Before(first) {
+			return first, true
+		}
+		months := (prev.Year()-first.Year())*12 + int(prev.Month()-first.Month())
+		for ; ; months++ {
+			if t := addMonthsClamped(first, months); t.After(prev) {
+				return t, true
+			}
+		}
+	}
+	return r.cron.next(prev)
+}
+
+// addMonthsClamped moves t by months, keeping its day unless the target month is shorter
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	day := t.Day()
+	if last := firstOfMonth.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+func (c *cronSpec) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next walks forward from the minute after prev, skipping whole months,
+// days and hours that can not match
+func (c *cronSpec) next(prev time.Time) (time.Time, bool) {
+	t := prev.Truncate(time.Minute).Add(time.Minute)
+	limit := prev.Add(cronSearchLimit)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgres error codes that mean "nothing was wrong with the request, run it again"
+const (
+	pqDeadlockDetected     = "40P01"
+	pqSerializationFailure = "40001"
+)
+
+const (
+	maxTxAttempts  = 5
+	baseRetryDelay = 10 * time.Millisecond
+	maxRetryDelay  = 200 * time.Millisecond
+)
+
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == pqDeadlockDetected || pqErr.Code == pqSerializationFailure
+}
+
+/*
+retryTx runs fn, which must open and finish its own db transaction, again
+when postgres aborted it because of a deadlock or a serialization failure.
+It gives up with ErrConflict after maxTxAttempts and sleeps an exponential,
+jittered delay between attempts so the competing transactions do not collide
+again.
+*/
+func retryTx(ctx context.Context, fn func() error) error {
+	delay := baseRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt == maxTxAttempts {
+			return fmt.Errorf("%w: %v", ErrConflict, err)
+		}
+
+		sleep := delay/2 + time.Duration(rand.Int63n(int64(delay)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+// MaxReversalReason is the longest reason a reversal accepts, in characters.
+const MaxReversalReason = 500
+
+// reversibleTypes are the transaction types money can be given back for.
+var reversibleTypes = map[string]bool{
+	"deposit":  true,
+	"withdraw": true,
+	"transfer": true,
+}
+
+// Reverse gives back whatever is left of transaction transactionID, so after
+// it the original and all of its reversals cancel out. It returns the id of
+// the reversal transaction.
+func (s *WalletService) Reverse(ctx context.Context, transactionID int, reason string) (int64, error) {
+	return s.refund(ctx, transactionID, nil, reason)
+}
+
+// Refund gives back amountStr of transaction transactionID, refunds of one
+// transaction may add up to at most its amount
+func (s *WalletService) Refund(ctx context.Context, transactionID int, amountStr, reason string) (int64, error) {
+	amount, err := ParseAmount(amountStr)
+	if err != nil {
+		return 0, err
+	}
+	return s.refund(ctx, transactionID, &amount, reason)
+}
+
+func (s *WalletService) refund(ctx context.Context, transactionID int, amount *decimal.Decimal, reason string) (int64, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || utf8.RuneCountInString(reason) > MaxReversalReason {
+		return 0, ErrInvalidReason
+	}
+
+	var reversalID int64
+	err := retryTx(ctx, func() error {
+		var err error
+		reversalID, err = s.reverse(ctx, transactionID, amount, reason)
+		return err
+	})
+	return reversalID, err
+}
+
+/*
+reverse books the reversal. The original row is locked first so concurrent
+refunds of one transaction queue up and can never give back more than it
+moved. Every posting of the original is mirrored with the opposite sign,
+scaled by the refunded share of the amount and truncated to the minor units
+of its currency, which keeps each currency of the journal balanced because
+the legs of a journal come in pairs of equal size. The fee charged on a
+transaction is the exception, it is given back in the same share.
+*/
+func (s *WalletService) reverse(ctx context.Context, transactionID int, amount *decimal.Decimal, reason string) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := claimIdempotencyKey(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	var original Transaction
+	var toUserID, toWalletID sql.NullInt64
+	var toAmount, fxRate decimal.NullDecimal
+	var toCurrency sql.NullString
+	err = tx.QueryRow(`SELECT id, user_id, wallet_id, type, amount, currency, to_user_id, to_wallet_id, to_amount, to_currency, fx_rate
+		FROM transactions WHERE id = $1 FOR UPDATE`, transactionID).
+		Scan(&original.ID, &original.UserID, &original.WalletID, &original.Type, &original.Amount, &original.Currency,
+			&toUserID, &toWalletID, &toAmount, &toCurrency, &fxRate)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %d", ErrTransactionNotFound, transactionID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !reversibleTypes[original.Type] {
+		return 0, fmt.Errorf("%w: a %s can not be reversed", ErrNotReversible, original.Type)
+	}
+
+	var reversed decimal.Decimal
+	err = tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE reverses_id = $1", original.ID).Scan(&reversed)
+	if err != nil {
+		return 0, err
+	}
+	remaining := original.Amount.Sub(reversed)
+	if remaining.Sign() <= 0 {
+		return 0, fmt.Errorf("%w: transaction %d", ErrAlreadyReversed, original.ID)
+	}
+
+	refund := remaining
+	if amount != nil {
+		if err := ValidateAmount(original.Currency, *amount); err != nil {
+			return 0, err
+		}
+		if amount.GreaterThan(remaining) {
+			return 0, fmt.Errorf("%w: only %s of transaction %d is left to refund", ErrInvalidAmount, remaining, original.ID)
+		}
+		refund = *amount
+	}
+	//multiplying before dividing keeps legs of exactly the original amount exact
+	scale := func(v decimal.Decimal) decimal.Decimal {
+		return v.Mul(refund).Div(original.Amount)
+	}
+
+	rows, err := tx.Query(`SELECT p.account_id, p.amount, p.currency, a.wallet_id, a.code
+		FROM postings p JOIN accounts a ON a.id = p.account_id
+		WHERE p.transaction_id = $1 ORDER BY p.id`, original.ID)
+	if err != nil {
+		return 0, err
+	}
+	var postings []Posting
+	walletAccounts := map[int64]int64{}
+	feeLeg := -1
+	sums := map[string]decimal.Decimal{}
+	for rows.Next() {
+		var p Posting
+		var walletID sql.NullInt64
+		var code sql.NullString
+		if err := rows.Scan(&p.AccountID, &p.Amount, &p.Currency, &walletID, &code); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		units, err := MinorUnits(p.Currency)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		p.Amount = scale(p.Amount).Truncate(units).Neg()
+		sums[p.Currency] = sums[p.Currency].Add(p.Amount)
+		if code.String == AccountFeeRevenue {
+			feeLeg = len(postings)
+		}
+		postings = append(postings, p)
+		if walletID.Valid {
+			walletAccounts[p.AccountID] = walletID.Int64
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	//the fee leg has no leg of the same size to pair with, so truncating the
+	//scaled legs can leave a minor unit over, the fee revenue account takes it
+	if feeLeg >= 0 {
+		p := &postings[feeLeg]
+		p.Amount = p.Amount.Sub(sums[p.Currency])
+	}
+	var refundFee decimal.NullDecimal
+	legs := postings[:0]
+	for i, p := range postings {
+		if i == feeLeg {
+			refundFee = nullFee(p.Amount.Neg())
+		}
+		if !p.Amount.IsZero() {
+			legs = append(legs, p)
+		}
+	}
+	postings = legs
+
+	//lock the wallets the money goes back through in id order, like transfers do
+	walletIDs := make([]int64, 0, len(walletAccounts))
+	for _, id := range walletAccounts {
+		walletIDs = append(walletIDs, id)
+	}
+	wallets, err := lockWallets(tx, walletIDs)
+	if err != nil {
+		return 0, err
+	}
+	locked := make([]Wallet, 0, len(wallets))
+	for _, wallet := range wallets {
+		locked = append(locked, wallet)
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].ID < locked[j].ID })
+
+	//money already spent by the wallet it went to can not be taken back. A
+	//reversal is a correction rather than the owner sending money, so frozen
+	//wallets take part, closed ones do not
+	for _, p := range postings {
+		walletID, ok := walletAccounts[p.AccountID]
+		if !ok {
+			continue
+		}
+		wallet := wallets[walletID]
+		if err := wallet.canReceive(); err != nil {
+			return 0, err
+		}
+		if p.Amount.Sign() < 0 && wallet.Available().LessThan(p.Amount.Neg()) {
+			return 0, fmt.Errorf("%w: wallet %d can not give back %s", ErrInsufficientFunds, wallet.ID, p.Amount.Neg())
+		}
+		wallet.Balance = wallet.Balance.Add(p.Amount)
+		_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE id = $2", wallet.Balance, wallet.ID)
+		if err != nil {
+			return 0, err
+		}
+		wallets[walletID] = wallet
+	}
+
+	var refundTo decimal.NullDecimal
+	if toAmount.Valid {
+		units, err := MinorUnits(toCurrency.String)
+		if err != nil {
+			return 0, err
+		}
+		refundTo = decimal.NewNullDecimal(scale(toAmount.Decimal).Truncate(units))
+	}
+
+	var reversalID int64
+	err = tx.QueryRow(`INSERT INTO transactions (user_id, wallet_id, type, amount, currency, fee, to_user_id, to_wallet_id, to_amount, to_currency, fx_rate, reverses_id, reason)
+		VALUES ($1, $2, 'reversal', $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
+		original.UserID, original.WalletID, refund, original.Currency, refundFee, toUserID, toWalletID, refundTo, toCurrency, fxRate, original.ID, reason).Scan(&reversalID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := postJournal(tx, reversalID, postings); err != nil {
+		return 0, err
+	}
+
+	if err := storeIdempotentResult(ctx, tx, reversalID); err != nil {
+		return 0, err
+	}
+	err = recordAudit(ctx, tx, AuditReversal, auditDetails{
+		"transaction_id": reversalID, "reverses_id": original.ID, "amount": refund, "reason": reason,
+	}, locked...)
+	if err != nil {
+		return 0, err
+	}
+	return reversalID, tx.Commit()
+}
@@ -0,0 +1,502 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Schedule statuses, only active schedules run. Completed ones have no run
+// left, cancelled ones were stopped by their owner.
+const (
+	ScheduleActive    = "active"
+	SchedulePaused    = "paused"
+	ScheduleCompleted = "completed"
+	ScheduleCancelled = "cancelled"
+)
+
+// Outcomes of a run.
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// MaxRecurrenceLength is the longest recurrence a schedule accepts.
+const MaxRecurrenceLength = 64
+
+// ScheduledTransfer moves Amount from one wallet to another at NextRunAt and
+// then again as Recurrence says, until EndAt if set.
+type ScheduledTransfer struct {
+	ID           int             `json:"id"`
+	FromWalletID int             `json:"from_wallet_id"`
+	ToWalletID   int             `json:"to_wallet_id"`
+	Amount       decimal.Decimal `json:"amount"`
+	Recurrence   string          `json:"recurrence"`
+	StartAt      time.Time       `json:"start_at"`
+	EndAt        *time.Time      `json:"end_at,omitempty"`
+	// NextRunAt is unset once a schedule completed or was cancelled
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ScheduledRun records the outcome of one run of a schedule.
+type ScheduledRun struct {
+	ID           int       `json:"id"`
+	ScheduleID   int       `json:"schedule_id"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	Status       string    `json:"status"`
+	// TransactionID is the transfer booked by a successful run, unknown for
+	// a run that was replayed after its worker died before recording it
+	TransactionID int64     `json:"transaction_id,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	RanAt         time.Time `json:"ran_at"`
+}
+
+// NewSchedule is what CreateSchedule needs, a zero StartAt means now.
+type NewSchedule struct {
+	FromWalletID int
+	ToWalletID   int
+	Amount       string
+	Recurrence   string
+	StartAt      time.Time
+	EndAt        *time.Time
+}
+
+// ScheduleUpdate changes the fields that are set, Status may be SchedulePaused or ScheduleActive.
+type ScheduleUpdate struct {
+	Amount *string
+	Status *string
+	EndAt  *time.Time
+}
+
+const scheduleColumns = "id, from_wallet_id, to_wallet_id, amount, recurrence, start_at, end_at, next_run_at, status, created_at, updated_at"
+
+func scanSchedule(row interface{ Scan(...interface{}) error }, schedule *ScheduledTransfer) error {
+	var endAt, nextRunAt sql.NullTime
+	err := row.Scan(&schedule.ID, &schedule.FromWalletID, &schedule.ToWalletID, &schedule.Amount, &schedule.Recurrence,
+		&schedule.StartAt, &endAt, &nextRunAt, &schedule.Status, &schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	schedule.StartAt = schedule.StartAt.UTC()
+	schedule.EndAt, schedule.NextRunAt = nil, nil
+	if endAt.Valid {
+		t := endAt.Time.UTC()
+		schedule.EndAt = &t
+	}
+	if nextRunAt.Valid {
+		t := nextRunAt.Time.UTC()
+		schedule.NextRunAt = &t
+	}
+	return nil
+}
+
+// runAt returns the first run of schedule at or after t, false when there is
+// none left before its end
+func (schedule ScheduledTransfer) runAt(r recurrence, t time.Time) (time.Time, bool) {
+	first, ok := firstRun(r, schedule.StartAt)
+	if !ok {
+		return time.Time{}, false
+	}
+	next := first
+	if next.Before(t) {
+		next, ok = r.next(first, t.Add(-time.Nanosecond))
+	}
+	if !ok || (schedule.EndAt != nil && next.After(*schedule.EndAt)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// firstRun returns the first time at or after start that r matches, a cron
+// expression only matches on whole minutes
+func firstRun(r recurrence, start time.Time) (time.Time, bool) {
+	start = start.UTC()
+	if r.cron == nil {
+		return start, true
+	}
+	if rounded := start.Truncate(time.Minute); rounded.Before(start) {
+		start = rounded.Add(time.Minute)
+	}
+	return r.cron.next(start.Add(-time.Nanosecond))
+}
+
+// CreateSchedule stores a future-dated or recurring transfer, the wallets are
+// checked now so an obviously broken schedule is refused before its first run
+func (s *WalletService) CreateSchedule(ctx context.Context, req NewSchedule) (ScheduledTransfer, error) {
+	var schedule ScheduledTransfer
+	amount, err := ParseAmount(req.Amount)
+	if err != nil {
+		return schedule, err
+	}
+	if req.FromWalletID == req.ToWalletID {
+		return schedule, ErrSameWallet
+	}
+	if req.Recurrence == "" {
+		req.Recurrence = RecurOnce
+	}
+	if len(req.Recurrence) > MaxRecurrenceLength {
+		return schedule, fmt.Errorf("%w: recurrence is longer than %d characters", ErrInvalidSchedule, MaxRecurrenceLength)
+	}
+	r, err := parseRecurrence(req.Recurrence)
+	if err != nil {
+		return schedule, err
+	}
+
+	now := time.Now().UTC()
+	schedule = ScheduledTransfer{FromWalletID: req.FromWalletID, ToWalletID: req.ToWalletID, Amount: amount, Recurrence: req.Recurrence, StartAt: req.StartAt.UTC()}
+	if req.StartAt.IsZero() {
+		schedule.StartAt = now
+	}
+	if req.EndAt != nil {
+		endAt := req.EndAt.UTC()
+		schedule.EndAt = &endAt
+	}
+	nextRunAt, ok := schedule.runAt(r, schedule.StartAt)
+	if !ok {
+		return schedule, fmt.Errorf("%w: the schedule has no run before it ends", ErrInvalidSchedule)
+	}
+
+	from, err := s.GetWallet(ctx, req.FromWalletID)
+	if err != nil {
+		return schedule, err
+	}
+	to, err := s.GetWallet(ctx, req.ToWalletID)
+	if err != nil {
+		return schedule, err
+	}
+	if from.Currency != to.Currency {
+		return schedule, fmt.Errorf("%w: %s to %s", ErrCurrencyMismatch, from.Currency, to.Currency)
+	}
+	if err := ValidateAmount(from.Currency, amount); err != nil {
+		return schedule, err
+	}
+
+	err = inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		err := scanSchedule(tx.QueryRowContext(ctx, `INSERT INTO scheduled_transfers (from_wallet_id, to_wallet_id, amount, recurrence, start_at, end_at, next_run_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING `+scheduleColumns,
+			schedule.FromWalletID, schedule.ToWalletID, amount, schedule.Recurrence, schedule.StartAt, schedule.EndAt, nextRunAt), &schedule)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditScheduleCreate, scheduleDetails(schedule))
+	})
+	return schedule, err
+}
+
+func (s *WalletService) GetSchedule(ctx context.Context, scheduleID int) (ScheduledTransfer, error) {
+	var schedule ScheduledTransfer
+	err := scanSchedule(s.DB.QueryRowContext(ctx, "SELECT "+scheduleColumns+" FROM scheduled_transfers WHERE id = $1", scheduleID), &schedule)
+	if err == sql.ErrNoRows {
+		return schedule, fmt.Errorf("%w: %d", ErrScheduleNotFound, scheduleID)
+	}
+	return schedule, err
+}
+
+// ListSchedules returns the schedules sending money from given wallet, newest first
+func (s *WalletService) ListSchedules(ctx context.Context, walletID int) ([]ScheduledTransfer, error) {
+	if _, err := s.GetWallet(ctx, walletID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+scheduleColumns+" FROM scheduled_transfers WHERE from_wallet_id = $1 ORDER BY id DESC", walletID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []ScheduledTransfer{}
+	for rows.Next() {
+		var schedule ScheduledTransfer
+		if err := scanSchedule(rows, &schedule); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+/*
+UpdateSchedule changes the amount or end of a schedule, or pauses and
+resumes it. A resumed schedule does not catch up on the runs it missed while
+paused, it continues with the first run from now on. Only active and paused
+schedules can be changed.
+*/
+func (s *WalletService) UpdateSchedule(ctx context.Context, scheduleID int, update ScheduleUpdate) (ScheduledTransfer, error) {
+	var schedule ScheduledTransfer
+	err := retryTx(ctx, func() error {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		schedule, err = lockSchedule(tx, scheduleID)
+		if err != nil {
+			return err
+		}
+		if schedule.Status != ScheduleActive && schedule.Status != SchedulePaused {
+			return fmt.Errorf("%w: schedule %d is %s", ErrScheduleNotActive, schedule.ID, schedule.Status)
+		}
+
+		if update.Amount != nil {
+			amount, err := ParseAmount(*update.Amount)
+			if err != nil {
+				return err
+			}
+			var currency string
+			if err := tx.QueryRow("SELECT currency FROM wallets WHERE id = $1", schedule.FromWalletID).Scan(&currency); err != nil {
+				return err
+			}
+			if err := ValidateAmount(currency, amount); err != nil {
+				return err
+			}
+			schedule.Amount = amount
+		}
+		if update.EndAt != nil {
+			endAt := update.EndAt.UTC()
+			schedule.EndAt = &endAt
+		}
+		if update.Status != nil {
+			if *update.Status != ScheduleActive && *update.Status != SchedulePaused {
+				return fmt.Errorf("%w: status can only be changed to %s or %s", ErrInvalidSchedule, ScheduleActive, SchedulePaused)
+			}
+			resumed := schedule.Status == SchedulePaused && *update.Status == ScheduleActive
+			schedule.Status = *update.Status
+			if resumed {
+				schedule.NextRunAt = nil
+			}
+		}
+
+		//a new end or a resume can move the next run
+		r, err := parseRecurrence(schedule.Recurrence)
+		if err != nil {
+			return err
+		}
+		from := time.Now().UTC()
+		if schedule.NextRunAt != nil {
+			from = *schedule.NextRunAt
+		}
+		nextRunAt, ok := schedule.runAt(r, from)
+		if !ok {
+			return fmt.Errorf("%w: the schedule would have no run left", ErrInvalidSchedule)
+		}
+		schedule.NextRunAt = &nextRunAt
+
+		return scanAndCommit(ctx, tx, AuditScheduleUpdate, &schedule, `UPDATE scheduled_transfers SET amount = $1, end_at = $2, status = $3, next_run_at = $4, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $5 RETURNING `+scheduleColumns, schedule.Amount, schedule.EndAt, schedule.Status, nextRunAt, schedule.ID)
+	})
+	return schedule, err
+}
+
+// CancelSchedule stops a schedule for good, the runs it made are kept
+func (s *WalletService) CancelSchedule(ctx context.Context, scheduleID int) (ScheduledTransfer, error) {
+	var schedule ScheduledTransfer
+	err := retryTx(ctx, func() error {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		schedule, err = lockSchedule(tx, scheduleID)
+		if err != nil {
+			return err
+		}
+		if schedule.Status != ScheduleActive && schedule.Status != SchedulePaused {
+			return fmt.Errorf("%w: schedule %d is %s", ErrScheduleNotActive, schedule.ID, schedule.Status)
+		}
+		return scanAndCommit(ctx, tx, AuditScheduleCancel, &schedule, `UPDATE scheduled_transfers SET status = 'cancelled', next_run_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 RETURNING `+scheduleColumns, schedule.ID)
+	})
+	return schedule, err
+}
+
+func lockSchedule(tx *sql.Tx, scheduleID int) (ScheduledTransfer, error) {
+	var schedule ScheduledTransfer
+	err := scanSchedule(tx.QueryRow("SELECT "+scheduleColumns+" FROM scheduled_transfers WHERE id = $1 FOR UPDATE", scheduleID), &schedule)
+	if err == sql.ErrNoRows {
+		return schedule, fmt.Errorf("%w: %d", ErrScheduleNotFound, scheduleID)
+	}
+	return schedule, err
+}
+
+// scanAndCommit writes a schedule with query, records operation in the audit
+// log and commits
+func scanAndCommit(ctx context.Context, tx *sql.Tx, operation string, schedule *ScheduledTransfer, query string, args ...interface{}) error {
+	if err := scanSchedule(tx.QueryRow(query, args...), schedule); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, operation, scheduleDetails(*schedule)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scheduleDetails(schedule ScheduledTransfer) auditDetails {
+	return auditDetails{
+		"schedule_id": schedule.ID, "from_wallet_id": schedule.FromWalletID, "to_wallet_id": schedule.ToWalletID,
+		"amount": schedule.Amount, "recurrence": schedule.Recurrence, "status": schedule.Status,
+	}
+}
+
+// ScheduleRuns returns the runs of given schedule, newest first
+func (s *WalletService) ScheduleRuns(ctx context.Context, scheduleID int) ([]ScheduledRun, error) {
+	if _, err := s.GetSchedule(ctx, scheduleID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, schedule_id, scheduled_for, status, transaction_id, error, ran_at
+		FROM scheduled_transfer_runs WHERE schedule_id = $1 ORDER BY scheduled_for DESC`, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduledRun{}
+	for rows.Next() {
+		var run ScheduledRun
+		var transactionID sql.NullInt64
+		var message sql.NullString
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.Status, &transactionID, &message, &run.RanAt); err != nil {
+			return nil, err
+		}
+		run.ScheduledFor = run.ScheduledFor.UTC()
+		run.TransactionID = transactionID.Int64
+		run.Error = message.String
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// failedRunErrors are the transfer errors that fail a run for good, any
+// other error leaves the run due so the next pass of the worker retries it
+var failedRunErrors = []error{
+	ErrInsufficientFunds, ErrWalletFrozen, ErrWalletClosed, ErrWalletNotFound, ErrLimitExceeded,
+	ErrCurrencyMismatch, ErrInvalidAmount, ErrSameWallet,
+}
+
+// scheduleRunScope is the idempotency scope of the run keys, out of reach of
+// the keys clients send
+const scheduleRunScope = "internal:schedules"
+
+/*
+RunDueSchedules books up to batch scheduled transfers that are due at now
+and returns how many runs it recorded. The due schedules are claimed with
+FOR UPDATE SKIP LOCKED, so any number of instances can run the worker and a
+schedule is only ever picked up by one of them at a time.
+
+Every run transfers under an idempotency key made of the schedule id and the
+time the run was due, in a scope clients can not send keys to. Should a worker die after the transfer committed but
+before it recorded the run, the next worker to claim the schedule gets a
+replay instead of moving the money a second time. A schedule that missed
+several runs makes them one after another, one per pass.
+*/
+func (s *WalletService) RunDueSchedules(ctx context.Context, now time.Time, batch int) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+scheduleColumns+` FROM scheduled_transfers
+		WHERE status = 'active' AND next_run_at <= $1 ORDER BY next_run_at, id LIMIT $2 FOR UPDATE SKIP LOCKED`, now.UTC(), batch)
+	if err != nil {
+		return 0, err
+	}
+	var due []ScheduledTransfer
+	for rows.Next() {
+		var schedule ScheduledTransfer
+		if err := scanSchedule(rows, &schedule); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, schedule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	var firstErr error
+	for _, schedule := range due {
+		err := s.runSchedule(ctx, tx, schedule)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("schedule %d: %w", schedule.ID, err)
+			}
+			continue
+		}
+		ran++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return ran, firstErr
+}
+
+// runSchedule makes the due run of a claimed schedule and records it in tx,
+// the transfer itself commits in a db transaction of its own
+func (s *WalletService) runSchedule(ctx context.Context, tx *sql.Tx, schedule ScheduledTransfer) error {
+	scheduledFor := *schedule.NextRunAt
+	key := fmt.Sprintf("schedule:%d:%d", schedule.ID, scheduledFor.UnixNano())
+	fingerprint := sha256.Sum256([]byte(key))
+	runCtx := WithIdempotencyKey(ctx, &IdempotencyKey{Scope: scheduleRunScope, Key: key, Fingerprint: hex.EncodeToString(fingerprint[:]), Status: 200, Body: []byte("{}")})
+	runCtx = WithAuditContext(runCtx, AuditContext{Actor: AuditScheduler, RequestID: key})
+
+	status := RunSucceeded
+	var transactionID sql.NullInt64
+	var message sql.NullString
+	txID, err := s.transferBetweenWallets(runCtx, schedule.FromWalletID, schedule.ToWalletID, schedule.Amount)
+	var replay *IdempotentReplay
+	switch {
+	case err == nil:
+		transactionID = sql.NullInt64{Int64: txID, Valid: true}
+	case errors.As(err, &replay):
+		//booked by a worker that died before recording the run
+	case isFailedRun(err):
+		status = RunFailed
+		message = sql.NullString{String: err.Error(), Valid: true}
+	default:
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO scheduled_transfer_runs (schedule_id, scheduled_for, status, transaction_id, error)
+		VALUES ($1, $2, $3, $4, $5)`, schedule.ID, scheduledFor, status, transactionID, message)
+	if err != nil {
+		return err
+	}
+
+	r, err := parseRecurrence(schedule.Recurrence)
+	if err != nil {
+		return err
+	}
+	next, ok := r.next(schedule.StartAt, scheduledFor)
+	if ok && schedule.EndAt != nil && next.After(*schedule.EndAt) {
+		ok = false
+	}
+	if !ok {
+		_, err = tx.ExecContext(ctx, "UPDATE scheduled_transfers SET status = 'completed', next_run_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1", schedule.ID)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE scheduled_transfers SET next_run_at = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", next, schedule.ID)
+	return err
+}
+
+func isFailedRun(err error) bool {
+	for _, target := range failedRunErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
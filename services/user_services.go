@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const pqForeignKeyViolation = "23503"
+
+// MaxListUsers caps the page size of ListUsers.
+const MaxListUsers = 200
+
+type UserService struct {
+	DB *sql.DB
+}
+
+func validateUserName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 128 {
+		return "", ErrInvalidUserName
+	}
+	return name, nil
+}
+
+// CreateUser inserts a user together with a default wallet in given
+// currency in one transaction, so there is never a user that cannot
+// receive money.
+func (s *UserService) CreateUser(ctx context.Context, name, currency string) (User, int64, error) {
+	var user User
+	name, err := validateUserName(name)
+	if err != nil {
+		return user, 0, err
+	}
+	currency, err = NormalizeCurrency(currency)
+	if err != nil {
+		return user, 0, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return user, 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO users (name) VALUES ($1) RETURNING id, name, created_at, updated_at", name).
+		Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return user, 0, err
+	}
+
+	//the ledger account of the wallet is opened by the wallets_open_account trigger
+	var walletID int64
+	err = tx.QueryRow("INSERT INTO wallets (user_id, balance, currency) VALUES ($1, 0, $2) RETURNING id", user.ID, currency).Scan(&walletID)
+	if err != nil {
+		return user, 0, err
+	}
+
+	err = recordAudit(ctx, tx, AuditUserCreate, auditDetails{"user_id": user.ID, "name": user.Name, "wallet_id": walletID, "currency": currency})
+	if err != nil {
+		return user, 0, err
+	}
+	return user, walletID, tx.Commit()
+}
+
+func (s *UserService) GetUser(ctx context.Context, id int) (User, error) {
+	var user User
+	err := s.DB.QueryRowContext(ctx, "SELECT id, name, created_at, updated_at FROM users WHERE id = $1", id).
+		Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+	}
+	return user, err
+}
+
+// ListUsers returns users ordered by id, limit is clamped to 1..MaxListUsers.
+func (s *UserService) ListUsers(ctx context.Context, limit, offset int) ([]User, error) {
+	if limit <= 0 || limit > MaxListUsers {
+		limit = MaxListUsers
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, name, created_at, updated_at FROM users ORDER BY id LIMIT $1 OFFSET $2", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *UserService) UpdateUser(ctx context.Context, id int, name string) (User, error) {
+	var user User
+	name, err := validateUserName(name)
+	if err != nil {
+		return user, err
+	}
+
+	err = inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRow("UPDATE users SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING id, name, created_at, updated_at", name, id).
+			Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrUserNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditUserUpdate, auditDetails{"user_id": user.ID, "name": user.Name})
+	})
+	return user, err
+}
+
+// isForeignKeyViolation reports whether err is postgres refusing a row that
+// references a missing parent, e.g. a wallet for a user that does not exist
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqForeignKeyViolation
+}
@@ -1,28 +1,194 @@
 package services
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"fmt"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 )
 
 type WalletService struct {
 	DB *sql.DB
+	// FX prices conversions between currencies, quotes fail without it
+	FX FXRateProvider
+	// QuoteTTL is how long a quote is honoured, DefaultQuoteTTL when zero
+	QuoteTTL time.Duration
+	// Fees prices withdrawals and transfers, they are free without it
+	Fees FeeSchedule
 }
 
 /*
 In the following code, we use "FOR UPDATE" to lock resources at db level, and
-avoid critical resources deadlock
+avoid critical resources deadlock.
+
+Every operation works on wallet ids, the user id variants (Deposit, Withdraw,
+Transfer, GetBalance, GetTransactionHistory) are aliases that act on the
+default wallet of the user.
+*/
+
+const walletColumns = "id, user_id, balance, held, currency, is_default, status, tier"
+
+func scanWallet(row interface{ Scan(...interface{}) error }, wallet *Wallet) error {
+	return row.Scan(&wallet.ID, &wallet.UserID, &wallet.Balance, &wallet.Held, &wallet.Currency, &wallet.IsDefault, &wallet.Status, &wallet.Tier)
+}
+
+// lockWallet loads given wallet and holds its row lock until tx ends
+func lockWallet(tx *sql.Tx, walletID int) (Wallet, error) {
+	var wallet Wallet
+	err := scanWallet(tx.QueryRow("SELECT "+walletColumns+" FROM wallets WHERE id = $1 FOR UPDATE", walletID), &wallet)
+	if err == sql.ErrNoRows {
+		return wallet, fmt.Errorf("%w: %d", ErrWalletNotFound, walletID)
+	}
+	return wallet, err
+}
+
+/*
+lockWalletPair locks both wallets with a single query ordered by wallet id,
+postgres takes the row locks in that order so every transfer acquires them
+in the same sequence regardless of its direction
 */
+func lockWalletPair(tx *sql.Tx, fromWalletID, toWalletID int) (Wallet, Wallet, error) {
+	var from, to Wallet
+	rows, err := tx.Query("SELECT "+walletColumns+" FROM wallets WHERE id IN ($1, $2) ORDER BY id FOR UPDATE", fromWalletID, toWalletID)
+	if err != nil {
+		return from, to, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var wallet Wallet
+		if err := scanWallet(rows, &wallet); err != nil {
+			return from, to, err
+		}
+		if wallet.ID == fromWalletID {
+			from = wallet
+		} else {
+			to = wallet
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return from, to, err
+	}
+	if from.ID == 0 {
+		return from, to, fmt.Errorf("%w: %d", ErrWalletNotFound, fromWalletID)
+	}
+	if to.ID == 0 {
+		return from, to, fmt.Errorf("%w: %d", ErrWalletNotFound, toWalletID)
+	}
+	return from, to, nil
+}
+
+// lockWallets locks any number of wallets in id order like lockWalletPair,
+// ids that do not exist are missing from the result
+func lockWallets(tx *sql.Tx, walletIDs []int64) (map[int64]Wallet, error) {
+	wallets := map[int64]Wallet{}
+	rows, err := tx.Query("SELECT "+walletColumns+" FROM wallets WHERE id = ANY($1) ORDER BY id FOR UPDATE", pq.Array(walletIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var wallet Wallet
+		if err := scanWallet(rows, &wallet); err != nil {
+			return nil, err
+		}
+		wallets[int64(wallet.ID)] = wallet
+	}
+	return wallets, rows.Err()
+}
+
+// DefaultWalletID returns the id of the wallet the user id routes act on
+func (s *WalletService) DefaultWalletID(ctx context.Context, userID int) (int, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx, "SELECT id FROM wallets WHERE user_id = $1 AND is_default", userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: user %d has no wallet", ErrWalletNotFound, userID)
+	}
+	return id, err
+}
+
+// CreateWallet inserts an empty wallet in given currency for given user, its
+// ledger account is opened by the wallets_open_account trigger in the same
+// statement and the first wallet of a user is flagged as default by
+// wallets_default_flag
+func (s *WalletService) CreateWallet(ctx context.Context, userID int, currency string) (int64, error) {
+	currency, err := NormalizeCurrency(currency)
+	if err != nil {
+		return 0, err
+	}
 
-func (s *WalletService) CreateWallet() (int64, error) {
 	var id int64
-	err := s.DB.QueryRow("INSERT INTO wallets (balance) VALUES ($1) RETURNING id", 0).Scan(&id)
+	err = inTx(ctx, s.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRow("INSERT INTO wallets (user_id, balance, currency) VALUES ($1, $2, $3) RETURNING id", userID, 0, currency).Scan(&id)
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditWalletCreate, auditDetails{"user_id": userID, "wallet_id": id, "currency": currency})
+	})
 	return id, err
 }
 
-func (s *WalletService) Deposit(userID int, amountStr string) error {
+func (s *WalletService) GetWallet(ctx context.Context, walletID int) (Wallet, error) {
+	var wallet Wallet
+	err := scanWallet(s.DB.QueryRowContext(ctx, "SELECT "+walletColumns+" FROM wallets WHERE id = $1", walletID), &wallet)
+	if err == sql.ErrNoRows {
+		return wallet, fmt.Errorf("%w: %d", ErrWalletNotFound, walletID)
+	}
+	return wallet, err
+}
+
+// ListWallets returns every wallet of given user, the default one first
+func (s *WalletService) ListWallets(ctx context.Context, userID int) ([]Wallet, error) {
+	var exists bool
+	err := s.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+walletColumns+" FROM wallets WHERE user_id = $1 ORDER BY is_default DESC, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wallets := []Wallet{}
+	for rows.Next() {
+		var wallet Wallet
+		if err := scanWallet(rows, &wallet); err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// Deposit adds money to the default wallet of given user
+func (s *WalletService) Deposit(ctx context.Context, userID int, amountStr string) error {
+	//reject a bad amount before looking the wallet up
+	if _, err := ParseAmount(amountStr); err != nil {
+		return err
+	}
+	walletID, err := s.DefaultWalletID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.DepositToWallet(ctx, walletID, amountStr)
+}
+
+func (s *WalletService) DepositToWallet(ctx context.Context, walletID int, amountStr string) error {
 	/*
 		handle deposit request, parse the number string into decimal value,
 	*/
@@ -31,162 +197,356 @@ func (s *WalletService) Deposit(userID int, amountStr string) error {
 		return err
 	}
 
-	tx, err := s.DB.Begin()
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	//get the current balance of given user
-	var balance decimal.Decimal
-	err = tx.QueryRow("SELECT balance FROM wallets WHERE user_id = $1 FOR UPDATE", userID).Scan(&balance)
+	//a retried request stops here with the response stored the first time
+	if err := claimIdempotencyKey(ctx, tx); err != nil {
+		return err
+	}
+
+	//get the current balance of given wallet
+	wallet, err := lockWallet(tx, walletID)
 	if err != nil {
 		return err
 	}
+	if err := wallet.canReceive(); err != nil {
+		return err
+	}
+	if err := ValidateAmount(wallet.Currency, amount); err != nil {
+		return err
+	}
 
-	//should we check the amount is poisitive numbe before add to balance?
-	newBalance := balance.Add(amount)
-	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE user_id = $2", newBalance, userID)
+	//ParseAmount already rejected zero and negative amounts
+	newBalance := wallet.Balance.Add(amount)
+	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE id = $2", newBalance, wallet.ID)
 	if err != nil {
 		return err
 	}
 
 	//save current deposite as transaction record
-	_, err = tx.Exec("INSERT INTO transactions (user_id, type, amount) VALUES ($1, 'deposit', $2)", userID, amount)
+	var txID int64
+	err = tx.QueryRow("INSERT INTO transactions (user_id, wallet_id, type, amount, currency) VALUES ($1, $2, 'deposit', $3, $4) RETURNING id",
+		wallet.UserID, wallet.ID, amount, wallet.Currency).Scan(&txID)
 	if err != nil {
 		return err
 	}
 
+	//the money comes from outside, book it against the external deposits account
+	walletAccount, err := walletAccountID(tx, int64(wallet.ID))
+	if err != nil {
+		return err
+	}
+	external, err := systemAccountID(tx, AccountExternalDeposits)
+	if err != nil {
+		return err
+	}
+	err = postJournal(tx, txID, []Posting{
+		{AccountID: walletAccount, Amount: amount, Currency: wallet.Currency},
+		{AccountID: external, Amount: amount.Neg(), Currency: wallet.Currency},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, AuditDeposit, auditDetails{"transaction_id": txID, "amount": amount}, wallet); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
-func (s *WalletService) Withdraw(userID int, amountStr string) error {
+// Withdraw takes money out of the default wallet of given user
+func (s *WalletService) Withdraw(ctx context.Context, userID int, amountStr string) error {
+	//reject a bad amount before looking the wallet up
+	if _, err := ParseAmount(amountStr); err != nil {
+		return err
+	}
+	walletID, err := s.DefaultWalletID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.WithdrawFromWallet(ctx, walletID, amountStr)
+}
+
+func (s *WalletService) WithdrawFromWallet(ctx context.Context, walletID int, amountStr string) error {
 	amount, err := ParseAmount(amountStr)
 	if err != nil {
 		return err
 	}
 
-	tx, err := s.DB.Begin()
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	//a retried request stops here with the response stored the first time
+	if err := claimIdempotencyKey(ctx, tx); err != nil {
+		return err
+	}
+
 	//get the current balance for given account
-	var balance decimal.Decimal
-	err = tx.QueryRow("SELECT balance FROM wallets WHERE user_id = $1 FOR UPDATE", userID).Scan(&balance)
+	wallet, err := lockWallet(tx, walletID)
 	if err != nil {
 		return err
 	}
-
-	//make sure withdraw can't more than the amount of balance
-	if balance.LessThan(amount) {
-		return errors.New("Insufficient balance")
+	if err := wallet.canSend(); err != nil {
+		return err
+	}
+	if err := ValidateAmount(wallet.Currency, amount); err != nil {
+		return err
 	}
 
-	//reduce the amount from balance and set new balance
-	newBalance := balance.Sub(amount)
-	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE user_id = $2", newBalance, userID)
+	//the fee is paid on top of the amount, both must be covered
+	fee, err := s.fee(ctx, FeeWithdraw, wallet.Currency, amount)
 	if err != nil {
 		return err
 	}
 
-	//record current withdraw as a transaction record
-	_, err = tx.Exec("INSERT INTO transactions (user_id, type, amount) VALUES ($1, 'withdraw', $2)", userID, amount)
+	//make sure withdraw can't more than the available balance, held money included
+	if wallet.Available().LessThan(amount.Add(fee)) {
+		return ErrInsufficientFunds
+	}
+	if err := checkLimits(ctx, tx, wallet, amount); err != nil {
+		return err
+	}
+
+	txID, err := bookWithdrawal(tx, wallet, amount, fee, sql.NullInt64{})
 	if err != nil {
 		return err
 	}
 
+	if err := recordAudit(ctx, tx, AuditWithdraw, auditDetails{"transaction_id": txID, "amount": amount, "fee": fee}, wallet); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
-func (s *WalletService) Transfer(fromUserID, toUserID int, amountStr string) error {
-	amount, err := ParseAmount(amountStr)
+// Transfer moves money between the default wallets of two users
+func (s *WalletService) Transfer(ctx context.Context, fromUserID, toUserID int, amountStr string) error {
+	if _, err := ParseAmount(amountStr); err != nil {
+		return err
+	}
+	if fromUserID == toUserID {
+		return ErrSameWallet
+	}
+	fromWalletID, err := s.DefaultWalletID(ctx, fromUserID)
 	if err != nil {
 		return err
 	}
-
-	tx, err := s.DB.Begin()
+	toWalletID, err := s.DefaultWalletID(ctx, toUserID)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	return s.TransferBetweenWallets(ctx, fromWalletID, toWalletID, amountStr)
+}
 
-	//get balance for user who want to transfer money
-	var fromBalance decimal.Decimal
-	err = tx.QueryRow("SELECT balance FROM wallets WHERE user_id = $1 FOR UPDATE", fromUserID).Scan(&fromBalance)
+/*
+TransferBetweenWallets moves money from one wallet to another. Both rows are
+locked in wallet id order no matter which direction the money goes, so
+concurrent A->B and B->A transfers queue up behind each other instead of
+deadlocking. Should postgres still abort the transaction with a deadlock or
+serialization error, it is retried with backoff before the error reaches
+the caller.
+*/
+func (s *WalletService) TransferBetweenWallets(ctx context.Context, fromWalletID, toWalletID int, amountStr string) error {
+	amount, err := ParseAmount(amountStr)
 	if err != nil {
 		return err
 	}
-
-	//check given user has enough money to transfer
-	//should we check the amount is positive number?
-	if fromBalance.LessThan(amount) {
-		return errors.New("insufficient balance")
+	if fromWalletID == toWalletID {
+		return ErrSameWallet
 	}
 
-	//get the balance of the receiver
-	var toBalance decimal.Decimal
-	err = tx.QueryRow("SELECT balance FROM wallets WHERE user_id = $1 FOR UPDATE", toUserID).Scan(&toBalance)
-	if err != nil {
+	_, err = s.transferBetweenWallets(ctx, fromWalletID, toWalletID, amount)
+	return err
+}
+
+// transferBetweenWallets runs a transfer of a parsed amount with retries and
+// returns the id of the transaction it booked
+func (s *WalletService) transferBetweenWallets(ctx context.Context, fromWalletID, toWalletID int, amount decimal.Decimal) (int64, error) {
+	var txID int64
+	err := retryTx(ctx, func() error {
+		var err error
+		txID, err = s.transfer(ctx, fromWalletID, toWalletID, amount)
 		return err
+	})
+	return txID, err
+}
+
+func (s *WalletService) transfer(ctx context.Context, fromWalletID, toWalletID int, amount decimal.Decimal) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	//reduce the transfer amount from sender and add to receiver
-	newFromBalance := fromBalance.Sub(amount)
-	newToBalance := toBalance.Add(amount)
+	//a retried request stops here with the response stored the first time
+	if err := claimIdempotencyKey(ctx, tx); err != nil {
+		return 0, err
+	}
 
-	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE user_id = $2", newFromBalance, fromUserID)
+	//lock sender and receiver together, in a fixed order
+	from, to, err := lockWalletPair(tx, fromWalletID, toWalletID)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	//a frozen wallet can still be paid into, a closed one can not
+	if err := from.canSend(); err != nil {
+		return 0, err
+	}
+	if err := to.canReceive(); err != nil {
+		return 0, err
+	}
+	if from.Currency != to.Currency {
+		return 0, fmt.Errorf("%w: %s to %s", ErrCurrencyMismatch, from.Currency, to.Currency)
+	}
+	if err := ValidateAmount(from.Currency, amount); err != nil {
+		return 0, err
 	}
 
-	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE user_id = $2", newToBalance, toUserID)
+	//the sender pays the fee on top of the amount the receiver gets
+	fee, err := s.fee(ctx, FeeTransfer, from.Currency, amount)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	//record this transfer as a transaction record
-	_, err = tx.Exec("INSERT INTO transactions (user_id, type, amount, to_user_id) VALUES ($1, 'transfer', $2, $3)", fromUserID, amount, toUserID)
+	//check given wallet has enough money to transfer, ParseAmount made sure it is positive
+	if from.Available().LessThan(amount.Add(fee)) {
+		return 0, ErrInsufficientFunds
+	}
+	if err := checkLimits(ctx, tx, from, amount); err != nil {
+		return 0, err
+	}
+
+	txID, err := bookTransfer(tx, from, to, amount, fee, sql.NullInt64{})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return tx.Commit()
+	if err := recordAudit(ctx, tx, AuditTransfer, auditDetails{"transaction_id": txID, "amount": amount, "fee": fee}, from, to); err != nil {
+		return 0, err
+	}
+	return txID, tx.Commit()
 }
 
-func (s *WalletService) GetBalance(userID int) (decimal.Decimal, error) {
-	var balance decimal.Decimal
-	err := s.DB.QueryRow("SELECT balance FROM wallets WHERE user_id = $1", userID).Scan(&balance)
+/*
+bookWithdrawal takes amount and fee out of the locked wallet, books the amount
+against the external withdrawals account and the fee against the fee revenue
+account, returning the transaction id. The caller has checked the funds,
+holdID links a withdrawal that captures a hold.
+*/
+func bookWithdrawal(tx *sql.Tx, wallet Wallet, amount, fee decimal.Decimal, holdID sql.NullInt64) (int64, error) {
+	//reduce the amount and fee from balance and set new balance
+	newBalance := wallet.Balance.Sub(amount).Sub(fee)
+	_, err := tx.Exec("UPDATE wallets SET balance = $1 WHERE id = $2", newBalance, wallet.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	//record current withdraw as a transaction record
+	var txID int64
+	err = tx.QueryRow("INSERT INTO transactions (user_id, wallet_id, type, amount, currency, fee, hold_id) VALUES ($1, $2, 'withdraw', $3, $4, $5, $6) RETURNING id",
+		wallet.UserID, wallet.ID, amount, wallet.Currency, nullFee(fee), holdID).Scan(&txID)
+	if err != nil {
+		return 0, err
+	}
+
+	//the money leaves the system through the external withdrawals account
+	walletAccount, err := walletAccountID(tx, int64(wallet.ID))
+	if err != nil {
+		return 0, err
+	}
+	external, err := systemAccountID(tx, AccountExternalWithdrawals)
+	if err != nil {
+		return 0, err
+	}
+	postings, err := withFee(tx, []Posting{
+		{AccountID: walletAccount, Amount: amount.Neg(), Currency: wallet.Currency},
+		{AccountID: external, Amount: amount, Currency: wallet.Currency},
+	}, fee)
 	if err != nil {
-		return decimal.Zero, err
+		return 0, err
 	}
-	return balance, nil
+	if err := postJournal(tx, txID, postings); err != nil {
+		return 0, err
+	}
+	return txID, nil
 }
 
-// GetTransactionHistory returns the transaction history for a given user
-func (s *WalletService) GetTransactionHistory(userID int) ([]Transaction, error) {
-	rows, err := s.DB.Query("SELECT id, user_id, type, amount, to_user_id, created_at FROM transactions WHERE user_id = $1 ORDER BY created_at DESC", userID)
+// bookTransfer moves amount between two locked wallets of the same currency
+// and charges fee to the sender, the caller has checked the funds of the sender
+func bookTransfer(tx *sql.Tx, from, to Wallet, amount, fee decimal.Decimal, holdID sql.NullInt64) (int64, error) {
+	//reduce the transfer amount and fee from sender and add the amount to receiver
+	newFromBalance := from.Balance.Sub(amount).Sub(fee)
+	newToBalance := to.Balance.Add(amount)
+
+	_, err := tx.Exec("UPDATE wallets SET balance = $1 WHERE id = $2", newFromBalance, from.ID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
 
-	var transactions []Transaction
-	for rows.Next() {
-		var transaction Transaction
-		var amount decimal.Decimal
-		err := rows.Scan(&transaction.ID, &transaction.UserID, &transaction.Type, &amount, &transaction.ToUserID, &transaction.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		transaction.Amount = amount
-		transactions = append(transactions, transaction)
+	_, err = tx.Exec("UPDATE wallets SET balance = $1 WHERE id = $2", newToBalance, to.ID)
+	if err != nil {
+		return 0, err
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	//record this transfer as a transaction record
+	var txID int64
+	err = tx.QueryRow("INSERT INTO transactions (user_id, wallet_id, type, amount, currency, fee, to_user_id, to_wallet_id, hold_id) VALUES ($1, $2, 'transfer', $3, $4, $5, $6, $7, $8) RETURNING id",
+		from.UserID, from.ID, amount, from.Currency, nullFee(fee), to.UserID, to.ID, holdID).Scan(&txID)
+	if err != nil {
+		return 0, err
 	}
 
-	return transactions, nil
+	fromAccount, err := walletAccountID(tx, int64(from.ID))
+	if err != nil {
+		return 0, err
+	}
+	toAccount, err := walletAccountID(tx, int64(to.ID))
+	if err != nil {
+		return 0, err
+	}
+	postings, err := withFee(tx, []Posting{
+		{AccountID: fromAccount, Amount: amount.Neg(), Currency: from.Currency},
+		{AccountID: toAccount, Amount: amount, Currency: to.Currency},
+	}, fee)
+	if err != nil {
+		return 0, err
+	}
+	if err := postJournal(tx, txID, postings); err != nil {
+		return 0, err
+	}
+	return txID, nil
+}
+
+// GetDefaultWallet returns the default wallet of given user
+func (s *WalletService) GetDefaultWallet(ctx context.Context, userID int) (Wallet, error) {
+	walletID, err := s.DefaultWalletID(ctx, userID)
+	if err != nil {
+		return Wallet{}, err
+	}
+	return s.GetWallet(ctx, walletID)
+}
+
+// GetBalance returns the balance of the default wallet of given user
+func (s *WalletService) GetBalance(ctx context.Context, userID int) (Balance, error) {
+	walletID, err := s.DefaultWalletID(ctx, userID)
+	if err != nil {
+		return Balance{}, err
+	}
+	return s.GetWalletBalance(ctx, walletID)
+}
+
+// GetWalletBalance returns the total balance of given wallet and the part of
+// it not reserved by holds
+func (s *WalletService) GetWalletBalance(ctx context.Context, walletID int) (Balance, error) {
+	wallet, err := s.GetWallet(ctx, walletID)
+	if err != nil {
+		return Balance{}, err
+	}
+	return Balance{Total: wallet.Balance, Available: wallet.Available(), Currency: wallet.Currency}, nil
 }
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Wallet statuses, a frozen wallet can still receive money but not send it
+// and a closed one can do neither. Closing is final.
+const (
+	WalletActive = "active"
+	WalletFrozen = "frozen"
+	WalletClosed = "closed"
+)
+
+// MaxStatusActor is the longest actor name a status change accepts, in characters.
+const MaxStatusActor = 128
+
+// WalletStatusEvent records one change of a wallet status.
+type WalletStatusEvent struct {
+	ID         int       `json:"id"`
+	WalletID   int       `json:"wallet_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Actor      string    `json:"actor"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// statusChanges lists, for every target status, the statuses a wallet may be in to move there
+var statusChanges = map[string][]string{
+	WalletActive: {WalletFrozen},
+	WalletFrozen: {WalletActive},
+	WalletClosed: {WalletActive, WalletFrozen},
+}
+
+// canSend reports why money may not leave the wallet, nil when it may
+func (w Wallet) canSend() error {
+	switch w.Status {
+	case WalletFrozen:
+		return fmt.Errorf("%w: %d", ErrWalletFrozen, w.ID)
+	case WalletClosed:
+		return fmt.Errorf("%w: %d", ErrWalletClosed, w.ID)
+	}
+	return nil
+}
+
+// canReceive reports why money may not reach the wallet, nil when it may
+func (w Wallet) canReceive() error {
+	if w.Status == WalletClosed {
+		return fmt.Errorf("%w: %d", ErrWalletClosed, w.ID)
+	}
+	return nil
+}
+
+// FreezeWallet stops given wallet from sending money, it can still receive
+func (s *WalletService) FreezeWallet(ctx context.Context, walletID int, actor, reason string) (Wallet, error) {
+	return s.changeWalletStatus(ctx, walletID, WalletFrozen, actor, reason)
+}
+
+// UnfreezeWallet makes a frozen wallet active again
+func (s *WalletService) UnfreezeWallet(ctx context.Context, walletID int, actor, reason string) (Wallet, error) {
+	return s.changeWalletStatus(ctx, walletID, WalletActive, actor, reason)
+}
+
+// CloseWallet closes an active or frozen wallet for good, its balance must be zero
+func (s *WalletService) CloseWallet(ctx context.Context, walletID int, actor, reason string) (Wallet, error) {
+	return s.changeWalletStatus(ctx, walletID, WalletClosed, actor, reason)
+}
+
+/*
+changeWalletStatus moves a wallet to status and records the change in the
+same db transaction. The wallet row is locked first, so a change can not
+slip in between the funds check of a withdrawal or transfer and its booking,
+and a wallet can not be closed while money is on its way into it.
+*/
+func (s *WalletService) changeWalletStatus(ctx context.Context, walletID int, status, actor, reason string) (Wallet, error) {
+	actor = strings.TrimSpace(actor)
+	if actor == "" || utf8.RuneCountInString(actor) > MaxStatusActor {
+		return Wallet{}, ErrInvalidActor
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" || utf8.RuneCountInString(reason) > MaxReversalReason {
+		return Wallet{}, ErrInvalidReason
+	}
+
+	var wallet Wallet
+	err := retryTx(ctx, func() error {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		wallet, err = lockWallet(tx, walletID)
+		if err != nil {
+			return err
+		}
+
+		allowed := false
+		for _, from := range statusChanges[status] {
+			allowed = allowed || wallet.Status == from
+		}
+		if !allowed {
+			return fmt.Errorf("%w: wallet %d is %s, it can not become %s", ErrInvalidStatusChange, wallet.ID, wallet.Status, status)
+		}
+		//held money is part of the balance, so this covers active holds too
+		if status == WalletClosed && !wallet.Balance.IsZero() {
+			return fmt.Errorf("%w: wallet %d holds %s %s", ErrWalletNotEmpty, wallet.ID, FormatAmount(wallet.Currency, wallet.Balance), wallet.Currency)
+		}
+
+		_, err = tx.Exec("UPDATE wallets SET status = $1 WHERE id = $2", status, wallet.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO wallet_status_events (wallet_id, from_status, to_status, actor, reason) VALUES ($1, $2, $3, $4, $5)",
+			wallet.ID, wallet.Status, status, actor, reason)
+		if err != nil {
+			return err
+		}
+		err = recordAudit(ctx, tx, AuditWalletStatus, auditDetails{
+			"wallet_id": wallet.ID, "from_status": wallet.Status, "to_status": status, "status_actor": actor, "reason": reason,
+		})
+		if err != nil {
+			return err
+		}
+
+		wallet.Status = status
+		return tx.Commit()
+	})
+	return wallet, err
+}
+
+// WalletStatusEvents returns the status changes of given wallet, oldest first
+func (s *WalletService) WalletStatusEvents(ctx context.Context, walletID int) ([]WalletStatusEvent, error) {
+	if _, err := s.GetWallet(ctx, walletID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, wallet_id, from_status, to_status, actor, reason, created_at
+		FROM wallet_status_events WHERE wallet_id = $1 ORDER BY id`, walletID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []WalletStatusEvent{}
+	for rows.Next() {
+		var e WalletStatusEvent
+		if err := rows.Scan(&e.ID, &e.WalletID, &e.FromStatus, &e.ToStatus, &e.Actor, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"services"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	setup()
+	ctx := services.WithAuditContext(context.Background(), services.AuditContext{Actor: "audit-tests", IP: "203.0.113.7", RequestID: "req-audit"})
+
+	walletID := newFundedWallet(t, 1, "10.00")
+	if err := walletService.WithdrawFromWallet(ctx, walletID, "2.50"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := walletService.AuditEvents(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, events, 1) {
+		return
+	}
+	event := events[0]
+	assert.Equal(t, services.AuditWithdraw, event.Operation)
+	assert.Equal(t, "audit-tests", event.Actor)
+	assert.Equal(t, "203.0.113.7", event.IP)
+	assert.Equal(t, "req-audit", event.RequestID)
+
+	var balances []services.BalanceChange
+	if err := json.Unmarshal(event.Balances, &balances); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, balances, 1) {
+		assert.Equal(t, walletID, balances[0].WalletID)
+		assert.True(t, balances[0].BalanceBefore.Equal(decimal.RequireFromString("10")))
+		assert.True(t, balances[0].BalanceAfter.Equal(decimal.RequireFromString("7.5")))
+	}
+
+	report, err := walletService.VerifyAuditLog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, report.OK(), "%+v", report.Broken)
+	assert.Equal(t, event.Hash, report.LastHash)
+
+	//events can not be changed or removed
+	_, err = walletService.DB.Exec("UPDATE audit_events SET actor = 'someone else' WHERE id = $1", event.ID)
+	assert.Error(t, err)
+	_, err = walletService.DB.Exec("DELETE FROM audit_events WHERE id = $1", event.ID)
+	assert.Error(t, err)
+}
+
+func TestAuditLogDetectsTampering(t *testing.T) {
+	setup()
+	newFundedWallet(t, 1, "1.00")
+	events, err := walletService.AuditEvents(context.Background(), 1)
+	if err != nil || len(events) != 1 {
+		t.Fatal(err)
+	}
+	event := events[0]
+
+	//the owner of the table can still get past the trigger, the chain shows it
+	db := walletService.DB
+	if _, err := db.Exec("ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		db.Exec("UPDATE audit_events SET actor = $1 WHERE id = $2", event.Actor, event.ID)
+		db.Exec("ALTER TABLE audit_events ENABLE TRIGGER audit_events_append_only")
+	}()
+	if _, err := db.Exec("UPDATE audit_events SET actor = 'someone else' WHERE id = $1", event.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := walletService.VerifyAuditLog(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, report.OK())
+	assert.Contains(t, report.Broken, services.AuditProblem{EventID: event.ID, Problem: "hash does not match its content"})
+}
@@ -0,0 +1,326 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"handles"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var testJWTSecret = []byte("0123456789abcdef0123456789abcdef")
+
+type fakeAPIKeys map[string]services.APIKey
+
+func (k fakeAPIKeys) AuthenticateAPIKey(ctx context.Context, secret string) (services.APIKey, error) {
+	key, ok := k[secret]
+	if !ok {
+		return key, services.ErrUnauthenticated
+	}
+	return key, nil
+}
+
+// fakeOwners says every wallet, hold and schedule n belongs to user n/10
+type fakeOwners struct{}
+
+func (fakeOwners) ResourceOwner(ctx context.Context, resource string, id int) (int, error) {
+	if id >= 1000 {
+		return 0, services.ErrWalletNotFound
+	}
+	return id / 10, nil
+}
+
+// fakeRoles holds the roles assigned to users beyond customer
+type fakeRoles map[int][]string
+
+func (r fakeRoles) UserRoles(ctx context.Context, userID int) ([]string, error) {
+	return append([]string{services.RoleCustomer}, r[userID]...), nil
+}
+
+func signedToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func authRouter(auth *handles.Authenticator) *gin.Engine {
+	router := gin.New()
+	api := router.Group(handles.APIPrefix, auth.Authenticate)
+	whoami := func(c *gin.Context) {
+		principal, _ := handles.PrincipalFrom(c)
+		c.JSON(http.StatusOK, principal)
+	}
+	api.GET("/wallet/:user_id/balance", whoami)
+	api.GET("/wallets/:wallet_id/balance", whoami)
+	api.POST("/wallets/:wallet_id/withdraw", handles.RequirePermission(services.PermWalletsWrite), whoami)
+	api.POST("/admin/wallets/:wallet_id/freeze", handles.RequirePermission(services.PermWalletsFreeze), whoami)
+	return router
+}
+
+func authStatus(router *gin.Engine, method, path string, header http.Header) int {
+	req := httptest.NewRequest(method, handles.APIPrefix+path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	router := authRouter(&handles.Authenticator{JWT: handles.JWTConfig{HMACSecret: testJWTSecret, Issuer: "wallet-tests"}, Owners: fakeOwners{}})
+	exp := time.Now().Add(time.Hour).Unix()
+	user1 := signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "1", "iss": "wallet-tests", "exp": exp})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, handles.APIPrefix+"/wallet/1/balance", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+	assert.Contains(t, rr.Body.String(), handles.CodeUnauthorized)
+
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallet/1/balance", bearer(user1)))
+	assert.Equal(t, http.StatusForbidden, authStatus(router, http.MethodGet, "/wallet/2/balance", bearer(user1)))
+	//wallets 10 to 19 belong to user 1
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallets/12/balance", bearer(user1)))
+	assert.Equal(t, http.StatusForbidden, authStatus(router, http.MethodGet, "/wallets/25/balance", bearer(user1)))
+	assert.Equal(t, http.StatusNotFound, authStatus(router, http.MethodGet, "/wallets/5000/balance", bearer(user1)))
+	assert.Equal(t, http.StatusForbidden, authStatus(router, http.MethodPost, "/admin/wallets/12/freeze", bearer(user1)))
+
+	admin := signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "ops", "scope": "read admin", "iss": "wallet-tests", "exp": exp})
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallet/2/balance", bearer(admin)))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/admin/wallets/25/freeze", bearer(admin)))
+
+	for name, token := range map[string]string{
+		"expired":      signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "1", "iss": "wallet-tests", "exp": time.Now().Add(-time.Minute).Unix()}),
+		"without exp":  signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "1", "iss": "wallet-tests"}),
+		"without sub":  signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"iss": "wallet-tests", "exp": exp}),
+		"wrong issuer": signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "1", "iss": "elsewhere", "exp": exp}),
+		"wrong secret": signedToken(t, jwt.SigningMethodHS256, []byte("another secret of thirty-two b.."), jwt.MapClaims{"sub": "1", "iss": "wallet-tests", "exp": exp}),
+		"unsigned":     signedToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{"sub": "1", "iss": "wallet-tests", "exp": exp}),
+	} {
+		assert.Equal(t, http.StatusUnauthorized, authStatus(router, http.MethodGet, "/wallet/1/balance", bearer(token)), name)
+	}
+}
+
+func TestAuthenticateRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := authRouter(&handles.Authenticator{JWT: handles.JWTConfig{RSAPublicKey: &key.PublicKey}})
+	exp := time.Now().Add(time.Hour).Unix()
+
+	token := signedToken(t, jwt.SigningMethodRS256, key, jwt.MapClaims{"sub": "3", "exp": exp})
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallet/3/balance", bearer(token)))
+
+	//HS256 is not configured, a token can not switch to it
+	hs := signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "3", "exp": exp})
+	assert.Equal(t, http.StatusUnauthorized, authStatus(router, http.MethodGet, "/wallet/3/balance", bearer(hs)))
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	router := authRouter(&handles.Authenticator{
+		APIKeys: fakeAPIKeys{
+			"wk_user": {ID: 1, UserID: 4, Scopes: []string{}},
+			"wk_ops":  {ID: 2, Scopes: []string{services.RoleAdmin}},
+		},
+		Owners: fakeOwners{},
+	})
+	key := func(secret string) http.Header {
+		header := http.Header{}
+		header.Set(handles.APIKeyHeader, secret)
+		return header
+	}
+
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallet/4/balance", key("wk_user")))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallets/41/balance", key("wk_user")))
+	assert.Equal(t, http.StatusForbidden, authStatus(router, http.MethodGet, "/wallet/5/balance", key("wk_user")))
+	assert.Equal(t, http.StatusUnauthorized, authStatus(router, http.MethodGet, "/wallet/4/balance", key("wk_unknown")))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/admin/wallets/41/freeze", key("wk_ops")))
+
+	//JWTs are not configured here
+	token := signedToken(t, jwt.SigningMethodHS256, testJWTSecret, jwt.MapClaims{"sub": "4", "exp": time.Now().Add(time.Hour).Unix()})
+	assert.Equal(t, http.StatusUnauthorized, authStatus(router, http.MethodGet, "/wallet/4/balance", bearer(token)))
+}
+
+func TestRequirePermission(t *testing.T) {
+	router := authRouter(&handles.Authenticator{
+		JWT:    handles.JWTConfig{HMACSecret: testJWTSecret},
+		Owners: fakeOwners{},
+		Roles:  fakeRoles{2: {services.RoleSupport}, 3: {services.RoleFinance}},
+	})
+	token := func(claims jwt.MapClaims) http.Header {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		return bearer(signedToken(t, jwt.SigningMethodHS256, testJWTSecret, claims))
+	}
+	customer := token(jwt.MapClaims{"sub": "1"})
+	support := token(jwt.MapClaims{"sub": "2"})
+	finance := token(jwt.MapClaims{"sub": "3"})
+	service := token(jwt.MapClaims{"sub": "ops", "scope": services.RoleSupport})
+
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", customer))
+	assert.Equal(t, http.StatusForbidden, authStatus(router, http.MethodPost, "/admin/wallets/12/freeze", customer))
+
+	//support reaches every account and can freeze, but moves no money
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallets/45/balance", support))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/admin/wallets/45/freeze", support))
+	assert.Equal(t, http.StatusForbidden, authStatus(router, http.MethodPost, "/wallets/45/withdraw", support))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/admin/wallets/45/freeze", service))
+	assert.Equal(t, http.StatusForbidden, authStatus(router, http.MethodPost, "/admin/wallets/45/freeze", finance))
+	//the user's own wallets are still theirs to use
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/25/withdraw", support))
+
+	req := httptest.NewRequest(http.MethodPost, handles.APIPrefix+"/admin/wallets/12/freeze", nil)
+	req.Header = customer
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), handles.CodeForbidden)
+	assert.Contains(t, rr.Body.String(), `"permission":"`+services.PermWalletsFreeze+`"`)
+}
+
+func TestRolePermissions(t *testing.T) {
+	for _, role := range []string{services.RoleCustomer, services.RoleSupport, services.RoleFinance, services.RoleAdmin} {
+		assert.True(t, services.IsRole(role), role)
+	}
+	assert.False(t, services.IsRole("root"))
+
+	perms := services.Permissions([]string{services.RoleCustomer, services.RoleFinance})
+	assert.True(t, perms[services.PermWalletsWrite])
+	assert.True(t, perms[services.PermTransactionsReverse])
+	assert.False(t, perms[services.PermWalletsFreeze])
+	assert.True(t, services.Permissions([]string{services.RoleAdmin})[services.PermRolesManage])
+	assert.Empty(t, services.Permissions([]string{"root"}))
+
+	//what customer grants is only for the user's own wallets
+	others := services.AnyAccountPermissions([]string{services.RoleCustomer, services.RoleSupport})
+	assert.True(t, others[services.PermWalletsFreeze])
+	assert.False(t, others[services.PermWalletsWrite])
+	assert.Empty(t, services.AnyAccountPermissions([]string{services.RoleCustomer}))
+}
+
+func TestRoleAssignments(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	userService := &services.UserService{DB: walletService.DB}
+
+	_, err := userService.AssignRole(ctx, 1, "root", "tests")
+	assert.True(t, errors.Is(err, services.ErrInvalidRole))
+	_, err = userService.AssignRole(ctx, 1, services.RoleCustomer, "tests")
+	assert.True(t, errors.Is(err, services.ErrInvalidRole))
+	_, err = userService.AssignRole(ctx, 1, services.RoleSupport, " ")
+	assert.True(t, errors.Is(err, services.ErrInvalidActor))
+	_, err = userService.AssignRole(ctx, 1<<30, services.RoleSupport, "tests")
+	assert.True(t, errors.Is(err, services.ErrUserNotFound))
+
+	assignment, err := userService.AssignRole(ctx, 1, services.RoleSupport, "tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "tests", assignment.GrantedBy)
+	//assigning again keeps who granted it first
+	again, err := userService.AssignRole(ctx, 1, services.RoleSupport, "someone else")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "tests", again.GrantedBy)
+	}
+	//and is not audited, the last event is still the assignment that was made
+	events, err := walletService.AuditEvents(ctx, 1)
+	if assert.NoError(t, err) && assert.Len(t, events, 1) {
+		assert.Equal(t, services.AuditRoleAssign, events[0].Operation)
+		var details map[string]interface{}
+		if assert.NoError(t, json.Unmarshal(events[0].Details, &details)) {
+			assert.Equal(t, "tests", details["granted_by"])
+		}
+	}
+
+	roles, err := userService.UserRoles(ctx, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{services.RoleCustomer, services.RoleSupport}, roles)
+	}
+
+	assert.NoError(t, userService.RevokeRole(ctx, 1, services.RoleSupport))
+	roles, err = userService.UserRoles(ctx, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{services.RoleCustomer}, roles)
+	}
+
+	//through the api the caller is who granted the role, whatever the body says
+	auth := &handles.Authenticator{
+		APIKeys: fakeAPIKeys{"wk_admin": {ID: 7, UserID: 9}},
+		Owners:  fakeOwners{},
+		Roles:   fakeRoles{9: {services.RoleAdmin}},
+	}
+	router := handles.NewRouter(auth, nil, handles.NewUserHandler(userService))
+	req := httptest.NewRequest(http.MethodPut, handles.APIPrefix+"/admin/users/1/roles/"+services.RoleFinance, strings.NewReader(`{"actor": "someone else"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(handles.APIKeyHeader, "wk_admin")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response struct {
+		Assignment services.RoleAssignment `json:"role_assignment"`
+	}
+	if assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response)) {
+		assert.Equal(t, "api_key:7", response.Assignment.GrantedBy)
+	}
+	assert.NoError(t, userService.RevokeRole(ctx, 1, services.RoleFinance))
+}
+
+func TestAPIKeys(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	userService := &services.UserService{DB: walletService.DB}
+
+	_, _, err := userService.CreateAPIKey(ctx, 1, "payroll", []string{"Not A Scope"})
+	assert.True(t, errors.Is(err, services.ErrInvalidAPIKey))
+	_, _, err = userService.CreateAPIKey(ctx, 1<<30, "payroll", nil)
+	assert.True(t, errors.Is(err, services.ErrUserNotFound))
+
+	key, secret, err := userService.CreateAPIKey(ctx, 1, "payroll", []string{"admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, key.UserID)
+	assert.Equal(t, []string{"admin"}, key.Scopes)
+	assert.Contains(t, secret, key.Prefix)
+
+	found, err := userService.AuthenticateAPIKey(ctx, secret)
+	if assert.NoError(t, err) {
+		assert.Equal(t, key.ID, found.ID)
+	}
+	_, err = userService.AuthenticateAPIKey(ctx, secret+"0")
+	assert.True(t, errors.Is(err, services.ErrUnauthenticated))
+
+	keys, err := userService.ListAPIKeys(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, keys)
+
+	revoked, err := userService.RevokeAPIKey(ctx, key.ID)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, revoked.RevokedAt)
+	}
+	_, err = userService.AuthenticateAPIKey(ctx, secret)
+	assert.True(t, errors.Is(err, services.ErrUnauthenticated))
+	_, err = userService.RevokeAPIKey(ctx, 1<<30)
+	assert.True(t, errors.Is(err, services.ErrAPIKeyNotFound))
+}
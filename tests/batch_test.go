@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"services"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchValidation(t *testing.T) {
+	//these are refused before the db is looked at
+	service := &services.WalletService{}
+	ctx := context.Background()
+	leg := services.BatchLeg{ToUserID: 2, Amount: "10"}
+
+	_, err := service.TransferBatch(ctx, 1, "sometimes", []services.BatchLeg{leg})
+	assert.True(t, errors.Is(err, services.ErrInvalidBatch))
+	_, err = service.TransferBatch(ctx, 1, services.BatchAtomic, nil)
+	assert.True(t, errors.Is(err, services.ErrInvalidBatch))
+
+	_, err = service.TransferBatch(ctx, 1, services.BatchBestEffort, []services.BatchLeg{leg, {ToUserID: 2, Amount: "-5"}})
+	var legErr *services.BatchLegError
+	if assert.True(t, errors.As(err, &legErr)) {
+		assert.Equal(t, 1, legErr.Index)
+		assert.True(t, errors.Is(err, services.ErrInvalidAmount))
+	}
+}
+
+func TestBatchTransferModes(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	sourceID := newFundedWallet(t, 1, "100.00")
+	receiverID, err := walletService.DefaultWalletID(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := walletService.GetWalletBalance(ctx, receiverID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//an atomic batch over the balance books nothing
+	_, err = walletService.TransferBatch(ctx, sourceID, services.BatchAtomic, []services.BatchLeg{
+		{ToUserID: 2, Amount: "60.00"},
+		{ToUserID: 2, Amount: "60.00"},
+	})
+	assert.True(t, errors.Is(err, services.ErrInsufficientFunds))
+	assertBalance(t, sourceID, "100", "100")
+
+	//an atomic batch with a leg that can not be booked names it and books nothing
+	_, err = walletService.TransferBatch(ctx, sourceID, services.BatchAtomic, []services.BatchLeg{
+		{ToUserID: 2, Amount: "10.00"},
+		{ToUserID: 1 << 30, Amount: "10.00"},
+	})
+	var legErr *services.BatchLegError
+	if assert.True(t, errors.As(err, &legErr)) {
+		assert.Equal(t, 1, legErr.Index)
+		assert.True(t, errors.Is(err, services.ErrWalletNotFound))
+	}
+	assertBalance(t, sourceID, "100", "100")
+
+	//best effort books what it can
+	result, err := walletService.TransferBatch(ctx, sourceID, services.BatchBestEffort, []services.BatchLeg{
+		{ToUserID: 2, Amount: "30.00"},
+		{ToUserID: 1 << 30, Amount: "10.00"},
+		{ToUserID: 2, Amount: "80.00"},
+		{ToUserID: 2, Amount: "20.00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, result.Succeeded)
+	assert.Equal(t, 2, result.Failed)
+	assert.True(t, result.Total.Equal(decimal.NewFromInt(50)))
+	if assert.Len(t, result.Legs, 4) {
+		assert.Equal(t, services.LegSucceeded, result.Legs[0].Status)
+		assert.NotZero(t, result.Legs[0].TransactionID)
+		assert.True(t, errors.Is(result.Legs[1].Err, services.ErrWalletNotFound))
+		assert.True(t, errors.Is(result.Legs[2].Err, services.ErrInsufficientFunds))
+		assert.Equal(t, services.LegSucceeded, result.Legs[3].Status)
+	}
+	assertBalance(t, sourceID, "50", "50")
+	after := before.Total.Add(decimal.NewFromInt(50))
+	assertBalance(t, receiverID, after.String(), before.Available.Add(decimal.NewFromInt(50)).String())
+
+	report, err := walletService.CheckLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, report.OK())
+}
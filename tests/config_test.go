@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "wallet.yaml")
+	content := "db:\n  dsn: host=filehost dbname=wallet\n  max_open_conns: 7\nserver:\n  addr: \":9000\"\n  read_timeout: 3s\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("WALLET_CONFIG", file)
+	t.Setenv("WALLET_DB_MAX_OPEN_CONNS", "11")
+	t.Setenv("WALLET_ADDR", ":9100")
+
+	cfg, err := config.Load([]string{"-addr", ":9200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//file overrides defaults
+	assert.Equal(t, "host=filehost dbname=wallet", cfg.DB.DSN)
+	assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout.Duration)
+	//env overrides file
+	assert.Equal(t, 11, cfg.DB.MaxOpenConns)
+	//flags override env
+	assert.Equal(t, ":9200", cfg.Server.Addr)
+	//untouched values keep their defaults
+	assert.Equal(t, config.Default().Server.ShutdownTimeout, cfg.Server.ShutdownTimeout)
+}
+
+func TestConfigTOMLFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wallet.toml")
+	content := "[db]\ndsn = \"host=tomlhost\"\nconn_max_lifetime = \"90s\"\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load([]string{"-config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "host=tomlhost", cfg.DB.DSN)
+	assert.Equal(t, 90*time.Second, cfg.DB.ConnMaxLifetime.Duration)
+}
+
+func TestConfigTrustedProxies(t *testing.T) {
+	assert.Empty(t, config.Default().Server.TrustedProxies)
+
+	t.Setenv("WALLET_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.7")
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.7"}, cfg.Server.TrustedProxies)
+
+	_, err = config.Load([]string{"-trusted-proxies", "lb.internal"})
+	assert.Error(t, err)
+}
+
+func TestConfigRateLimits(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wallet.yaml")
+	content := "rate_limits:\n  transfers:\n    principal:\n      requests: 3\n      per: 1m\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load([]string{"-config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a group in the file replaces its defaults, the others keep theirs
+	assert.Equal(t, config.RouteLimitsConfig{Principal: config.RateLimitConfig{Requests: 3, Per: config.Duration{Duration: time.Minute}}}, cfg.RateLimits["transfers"])
+	assert.Equal(t, config.Default().RateLimits["reads"], cfg.RateLimits["reads"])
+
+	cfg = config.Default()
+	cfg.RateLimits["uploads"] = config.RouteLimitsConfig{}
+	assert.Error(t, cfg.Validate())
+	cfg = config.Default()
+	cfg.RateLimits["reads"] = config.RouteLimitsConfig{IP: config.RateLimitConfig{Requests: 5}}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestInitDBReturnsError(t *testing.T) {
+	cfg := config.Default().DB
+	cfg.DSN = "host=127.0.0.1 port=1 dbname=none sslmode=disable connect_timeout=1"
+	cfg.ConnectTimeout.Duration = time.Second
+
+	//an unreachable db must come back as an error instead of exiting the process
+	db, err := config.InitDB(cfg)
+	assert.Nil(t, db)
+	assert.Error(t, err)
+}
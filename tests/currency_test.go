@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"services"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAmountUsesMinorUnits(t *testing.T) {
+	cases := []struct {
+		currency string
+		amount   string
+		ok       bool
+	}{
+		{"JPY", "500", true},
+		{"JPY", "0.5", false},
+		{"USD", "10.25", true},
+		{"USD", "10.255", false},
+		{"KWD", "1.125", true},
+		{"KWD", "1.1255", false},
+	}
+	for _, c := range cases {
+		err := services.ValidateAmount(c.currency, decimal.RequireFromString(c.amount))
+		if c.ok {
+			assert.NoError(t, err, "%s %s", c.amount, c.currency)
+		} else {
+			assert.True(t, errors.Is(err, services.ErrInvalidAmount), "%s %s", c.amount, c.currency)
+		}
+	}
+
+	assert.Equal(t, "5", services.FormatAmount("JPY", decimal.NewFromInt(5)))
+	assert.Equal(t, "5.00", services.FormatAmount("USD", decimal.NewFromInt(5)))
+
+	code, err := services.NormalizeCurrency(" eur ")
+	assert.NoError(t, err)
+	assert.Equal(t, "EUR", code)
+
+	_, err = services.NormalizeCurrency("XYZ")
+	assert.True(t, errors.Is(err, services.ErrUnsupportedCurrency))
+}
+
+func TestTransferRejectsCurrencyMismatch(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	userID := 1
+
+	euroID, err := walletService.CreateWallet(ctx, userID, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultID, err := walletService.DefaultWalletID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.DepositToWallet(ctx, defaultID, "10.00"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = walletService.TransferBetweenWallets(ctx, defaultID, int(euroID), "5.00")
+	assert.True(t, errors.Is(err, services.ErrCurrencyMismatch))
+
+	balance, err := walletService.GetWalletBalance(ctx, int(euroID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, balance.Total.IsZero())
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"handles"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceErrorsMatchWithErrorsIs(t *testing.T) {
+	_, err := services.ParseAmount("abc")
+	assert.True(t, errors.Is(err, services.ErrInvalidAmount))
+
+	_, err = services.ParseAmount("-10")
+	assert.True(t, errors.Is(err, services.ErrInvalidAmount))
+
+	_, err = services.ParseAmount("0")
+	assert.True(t, errors.Is(err, services.ErrInvalidAmount))
+}
+
+func TestErrorResponseBody(t *testing.T) {
+	//amount validation fails before the db is touched, so no db is needed here
+	handler := handles.NewWalletHandler(&services.WalletService{})
+	router := gin.New()
+	router.Use(handles.RequestID())
+	router.POST("/wallet/:user_id/withdraw", handler.Withdraw)
+
+	bodyJSON, _ := json.Marshal(map[string]string{"amount": "-5"})
+	req, _ := http.NewRequest(http.MethodPost, "/wallet/1/withdraw", bytes.NewReader(bodyJSON))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(handles.RequestIDHeader, "test-request-1")
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "test-request-1", rr.Header().Get(handles.RequestIDHeader))
+
+	var body struct {
+		Error handles.ErrorBody `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, handles.CodeInvalidAmount, body.Error.Code)
+	assert.Equal(t, "test-request-1", body.Error.RequestID)
+	assert.NotEmpty(t, body.Error.Message)
+}
@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"services"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeScheduleRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fees.json")
+	content := `{"rules": [
+		{"operation": "withdraw", "currency": "usd", "flat": "0.25", "percent": "1", "min": "0.50", "max": "5"},
+		{"operation": "transfer", "currency": "*", "tiers": [{"up_to": "100", "flat": "0"}, {"up_to": "1000", "percent": "0.5"}, {"flat": "2"}]},
+		{"operation": "transfer", "currency": "JPY", "flat": "10"}
+	]}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := services.LoadFeeSchedule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		operation string
+		currency  string
+		amount    string
+		fee       string
+	}{
+		{services.FeeWithdraw, "USD", "10", "0.5"},   //below the minimum
+		{services.FeeWithdraw, "USD", "100", "1.25"}, //flat plus percent
+		{services.FeeWithdraw, "USD", "1000", "5"},   //capped at the maximum
+		{services.FeeWithdraw, "EUR", "100", "0"},    //no rule means free
+		{services.FeeTransfer, "EUR", "100", "0"},
+		{services.FeeTransfer, "EUR", "100.01", "0.5"}, //rounded to cents
+		{services.FeeTransfer, "EUR", "5000", "2"},
+		{services.FeeTransfer, "JPY", "5000", "10"}, //the exact currency wins over *
+	}
+	for _, c := range cases {
+		fee, err := schedule.Fee(context.Background(), c.operation, c.currency, decimal.RequireFromString(c.amount))
+		assert.NoError(t, err)
+		assert.True(t, fee.Equal(decimal.RequireFromString(c.fee)), "%s %s %s: got %s", c.operation, c.amount, c.currency, fee)
+	}
+
+	invalid := [][]services.FeeRule{
+		{{Operation: "deposit", Currency: "USD"}},
+		{{Operation: services.FeeWithdraw, Currency: "USD", Flat: decimal.NewFromInt(-1)}},
+		{{Operation: services.FeeWithdraw, Currency: "USD", Min: decimalPtr("5"), Max: decimalPtr("1")}},
+		{{Operation: services.FeeWithdraw, Currency: "USD", Tiers: []services.FeeTier{{}, {UpTo: decimalPtr("10")}}}},
+		{{Operation: services.FeeWithdraw, Currency: "USD"}, {Operation: services.FeeWithdraw, Currency: "usd"}},
+	}
+	for _, rules := range invalid {
+		_, err := services.NewRuleSchedule(rules)
+		assert.Error(t, err, "%+v", rules)
+	}
+}
+
+func TestFeesAreBookedToRevenue(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	schedule, err := services.NewRuleSchedule([]services.FeeRule{
+		{Operation: services.FeeWithdraw, Currency: "USD", Flat: decimal.NewFromInt(1)},
+		{Operation: services.FeeTransfer, Currency: "USD", Percent: decimal.NewFromInt(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletService.Fees = schedule
+	defer func() { walletService.Fees = nil }()
+
+	senderID := newFundedWallet(t, 1, "100.00")
+	receiverID := newFundedWallet(t, 2, "0.01")
+
+	quote, err := walletService.PreviewFee(ctx, senderID, services.FeeTransfer, "50.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, quote.Fee.Equal(decimal.RequireFromString("0.5")))
+	assert.True(t, quote.Total.Equal(decimal.RequireFromString("50.5")))
+	_, err = walletService.PreviewFee(ctx, senderID, "deposit", "50.00")
+	assert.True(t, errors.Is(err, services.ErrInvalidOperation))
+
+	assert.NoError(t, walletService.TransferBetweenWallets(ctx, senderID, receiverID, "50.00"))
+	assertBalance(t, senderID, "49.5", "49.5")
+	assertBalance(t, receiverID, "50.01", "50.01")
+
+	//the fee has to be covered as well
+	err = walletService.WithdrawFromWallet(ctx, senderID, "49.00")
+	assert.True(t, errors.Is(err, services.ErrInsufficientFunds))
+	assert.NoError(t, walletService.WithdrawFromWallet(ctx, senderID, "48.50"))
+	assertBalance(t, senderID, "0", "0")
+
+	page, err := walletService.GetWalletTransactionHistory(ctx, senderID, services.HistoryFilter{Types: []string{"withdraw"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, page.Transactions, 1) && assert.NotNil(t, page.Transactions[0].Fee) {
+		assert.True(t, page.Transactions[0].Fee.Equal(decimal.NewFromInt(1)))
+	}
+
+	//a full reversal gives the fee back as well
+	transferID := lastTransactionID(t, receiverID)
+	if _, err := walletService.Reverse(ctx, transferID, "refund with fee"); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, senderID, "50.5", "50.5")
+
+	report, err := walletService.CheckLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, report.OK())
+}
+
+func TestConversionsAreCharged(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	schedule, err := services.NewRuleSchedule([]services.FeeRule{
+		{Operation: services.FeeTransfer, Currency: "USD", Flat: decimal.RequireFromString("0.5")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletService.Fees = schedule
+	defer func() { walletService.Fees = nil }()
+	rates := services.NewMemoryRates()
+	rates.Set("USD", "EUR", decimal.RequireFromString("0.9"))
+	walletService.FX = rates
+	defer func() { walletService.FX = nil }()
+
+	dollarID := newFundedWallet(t, 1, "10.00")
+	euroID, err := walletService.CreateWallet(ctx, 2, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := walletService.CreateQuote(ctx, "USD", "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the fee is on top of the amount, 9.6 USD plus 0.5 is more than there is
+	_, err = walletService.ConvertAndTransfer(ctx, dollarID, int(euroID), "9.60", quote.ID)
+	assert.True(t, errors.Is(err, services.ErrInsufficientFunds))
+
+	txID, err := walletService.ConvertAndTransfer(ctx, dollarID, int(euroID), "9.00", quote.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, dollarID, "0.5", "0.5")
+	assertBalance(t, int(euroID), "8.1", "8.1")
+
+	page, err := walletService.GetWalletTransactionHistory(ctx, dollarID, services.HistoryFilter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, page.Transactions, 1) && assert.NotNil(t, page.Transactions[0].Fee) {
+		assert.Equal(t, int(txID), page.Transactions[0].ID)
+		assert.True(t, page.Transactions[0].Fee.Equal(decimal.RequireFromString("0.5")))
+	}
+
+	report, err := walletService.CheckLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, report.OK())
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"services"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticRatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(`{"USD/EUR": "0.8", "eur/jpy": "160"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := services.LoadStaticRates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	rate, err := rates.Rate(ctx, "USD", "EUR")
+	assert.NoError(t, err)
+	assert.True(t, rate.Equal(decimal.RequireFromString("0.8")))
+
+	//the opposite direction is derived from the inverse
+	rate, err = rates.Rate(ctx, "EUR", "USD")
+	assert.NoError(t, err)
+	assert.True(t, rate.Equal(decimal.RequireFromString("1.25")))
+
+	_, err = rates.Rate(ctx, "USD", "JPY")
+	assert.True(t, errors.Is(err, services.ErrRateUnavailable))
+}
+
+func TestConvertAndTransfer(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	userID := 1
+
+	rates := services.NewMemoryRates()
+	rates.Set("USD", "JPY", decimal.RequireFromString("150.5"))
+	walletService.FX = rates
+	defer func() { walletService.FX = nil }()
+
+	yenID, err := walletService.CreateWallet(ctx, userID, "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dollarID, err := walletService.DefaultWalletID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.DepositToWallet(ctx, dollarID, "10.00"); err != nil {
+		t.Fatal(err)
+	}
+
+	quote, err := walletService.CreateQuote(ctx, "usd", "jpy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "USD", quote.FromCurrency)
+	assert.True(t, quote.Rate.Equal(decimal.RequireFromString("150.5")))
+
+	//1.01 USD at 150.5 is 152.005 JPY, the receiver gets whole yen only
+	//the quote belongs to the caller that asked for it
+	_, err = walletService.ConvertAndTransfer(services.WithAuditContext(ctx, services.AuditContext{Actor: "api_key:99"}), dollarID, int(yenID), "1.01", quote.ID)
+	assert.True(t, errors.Is(err, services.ErrQuoteNotFound))
+
+	txID, err := walletService.ConvertAndTransfer(ctx, dollarID, int(yenID), "1.01", quote.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := walletService.GetWalletBalance(ctx, int(yenID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, balance.Total.Equal(decimal.NewFromInt(152)), balance.Total.String())
+
+	//a quote converts once
+	_, err = walletService.ConvertAndTransfer(ctx, dollarID, int(yenID), "1.00", quote.ID)
+	assert.True(t, errors.Is(err, services.ErrQuoteExpired))
+
+	history, err := walletService.GetWalletTransactionHistory(ctx, dollarID, services.HistoryFilter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted := history.Transactions[0]
+	assert.Equal(t, txID, int64(converted.ID))
+	assert.Equal(t, quote.ID, converted.QuoteID)
+	assert.Equal(t, "JPY", converted.ToCurrency)
+	assert.True(t, converted.ToAmount.Equal(decimal.NewFromInt(152)))
+	assert.True(t, converted.FXRate.Equal(quote.Rate))
+
+	report, err := walletService.CheckLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, report.OK())
+}
@@ -8,13 +8,20 @@ replace services => ../services
 
 replace config => ../config
 
+replace migrations => ../migrations
+
+replace signing => ../signing
+
 require (
 	config v0.0.0-00010101000000-000000000000
 	github.com/gin-gonic/gin v1.10.0
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/shopspring/decimal v1.4.0
 	github.com/stretchr/testify v1.9.0
 	handles v0.0.0-00010101000000-000000000000
+	migrations v0.0.0-00010101000000-000000000000
 	services v0.0.0-00010101000000-000000000000
+	signing v0.0.0-00010101000000-000000000000
 )
 
 require (
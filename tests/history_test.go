@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"handles"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryQueryValidation(t *testing.T) {
+	//malformed parameters are rejected before the db is touched
+	handler := handles.NewWalletHandler(&services.WalletService{})
+	router := gin.New()
+	router.GET("/wallets/:wallet_id/transactions", handler.GetWalletTransactionHistory)
+
+	for _, query := range []string{
+		"limit=0",
+		"limit=1000",
+		"order=sideways",
+		"min_amount=ten",
+		"from=yesterday",
+		"counterparty_user_id=-3",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/wallets/1/transactions?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		assert.Contains(t, rr.Body.String(), handles.CodeInvalidRequest, query)
+	}
+}
+
+func TestHistoryPagination(t *testing.T) {
+	setup()
+	ctx := context.Background()
+
+	walletID64, err := walletService.CreateWallet(ctx, 1, services.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletID := int(walletID64)
+	for _, amount := range []string{"1.00", "2.00", "3.00", "4.00", "5.00"} {
+		if err := walletService.DepositToWallet(ctx, walletID, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := walletService.WithdrawFromWallet(ctx, walletID, "1.50"); err != nil {
+		t.Fatal(err)
+	}
+
+	//newest first, two per page, every transaction exactly once
+	var seen []int
+	filter := services.HistoryFilter{Limit: 2}
+	for pages := 0; ; pages++ {
+		page, err := walletService.GetWalletTransactionHistory(ctx, walletID, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range page.Transactions {
+			seen = append(seen, tx.ID)
+		}
+		if page.NextCursor == "" {
+			assert.Equal(t, 2, pages)
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+	assert.Len(t, seen, 6)
+	for i := 1; i < len(seen); i++ {
+		assert.Greater(t, seen[i-1], seen[i])
+	}
+
+	//filters combine
+	min := decimal.RequireFromString("2.00")
+	max := decimal.RequireFromString("4.00")
+	page, err := walletService.GetWalletTransactionHistory(ctx, walletID, services.HistoryFilter{
+		Types:     []string{"deposit"},
+		MinAmount: &min,
+		MaxAmount: &max,
+		Ascending: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, page.Transactions, 3) {
+		assert.True(t, page.Transactions[0].Amount.Equal(min))
+		assert.True(t, page.Transactions[2].Amount.Equal(max))
+	}
+
+	_, err = walletService.GetWalletTransactionHistory(ctx, walletID, services.HistoryFilter{Cursor: "not-a-cursor"})
+	assert.True(t, errors.Is(err, services.ErrInvalidFilter))
+}
+
+func TestHistoryIncludesIncomingTransfers(t *testing.T) {
+	setup()
+	ctx := context.Background()
+
+	senderID64, err := walletService.CreateWallet(ctx, 1, services.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverID64, err := walletService.CreateWallet(ctx, 2, services.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderID, receiverID := int(senderID64), int(receiverID64)
+
+	if err := walletService.DepositToWallet(ctx, senderID, "80.00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.TransferBetweenWallets(ctx, senderID, receiverID, "50.00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.DepositToWallet(ctx, receiverID, "5.00"); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := walletService.GetWalletTransactionHistory(ctx, receiverID, services.HistoryFilter{Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, page.Transactions, 2) {
+		return
+	}
+	received := page.Transactions[0]
+	assert.Equal(t, "transfer", received.Type)
+	assert.Equal(t, services.DirectionCredit, received.Direction)
+	assert.Equal(t, 1, received.CounterpartyUserID)
+	assert.Equal(t, senderID, received.CounterpartyWalletID)
+	assert.True(t, received.BalanceAfter.Equal(decimal.NewFromInt(50)))
+
+	//the running balance of the last entry is the wallet balance
+	balance, err := walletService.GetWalletBalance(ctx, receiverID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, page.Transactions[1].BalanceAfter.Equal(balance.Total))
+
+	//the sender sees the same transfer as a debit
+	page, err = walletService.GetWalletTransactionHistory(ctx, senderID, services.HistoryFilter{CounterpartyWalletID: receiverID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, page.Transactions, 1) {
+		assert.Equal(t, services.DirectionDebit, page.Transactions[0].Direction)
+		assert.True(t, page.Transactions[0].BalanceAfter.Equal(decimal.NewFromInt(30)))
+	}
+}
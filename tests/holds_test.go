@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFundedWallet(t *testing.T, userID int, amount string) int {
+	t.Helper()
+	walletID, err := walletService.CreateWallet(context.Background(), userID, services.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.DepositToWallet(context.Background(), int(walletID), amount); err != nil {
+		t.Fatal(err)
+	}
+	return int(walletID)
+}
+
+func assertBalance(t *testing.T, walletID int, total, available string) {
+	t.Helper()
+	balance, err := walletService.GetWalletBalance(context.Background(), walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, balance.Total.Equal(decimal.RequireFromString(total)), "total %s, want %s", balance.Total, total)
+	assert.True(t, balance.Available.Equal(decimal.RequireFromString(available)), "available %s, want %s", balance.Available, available)
+}
+
+func TestHoldCaptureAndVoid(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	walletID := newFundedWallet(t, 1, "100.00")
+	merchantID := newFundedWallet(t, 2, "1.00")
+
+	hold, err := walletService.PlaceHold(ctx, walletID, "60.00", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.HoldActive, hold.Status)
+	assertBalance(t, walletID, "100", "40")
+
+	//held money can be neither withdrawn nor held twice
+	err = walletService.WithdrawFromWallet(ctx, walletID, "50.00")
+	assert.True(t, errors.Is(err, services.ErrInsufficientFunds))
+	_, err = walletService.PlaceHold(ctx, walletID, "50.00", 0)
+	assert.True(t, errors.Is(err, services.ErrInsufficientFunds))
+
+	//capturing less than the hold releases the rest
+	if err := walletService.CaptureHold(ctx, hold.ID, "45.00", merchantID); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, walletID, "55", "55")
+	assertBalance(t, merchantID, "46", "46")
+
+	captured, err := walletService.GetHold(ctx, hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.HoldCaptured, captured.Status)
+	assert.True(t, captured.CapturedAmount.Equal(decimal.NewFromInt(45)))
+
+	err = walletService.CaptureHold(ctx, hold.ID, "1.00", 0)
+	assert.True(t, errors.Is(err, services.ErrHoldNotActive))
+
+	hold, err = walletService.PlaceHold(ctx, walletID, "10.00", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = walletService.CaptureHold(ctx, hold.ID, "10.01", 0)
+	assert.True(t, errors.Is(err, services.ErrInvalidAmount))
+	if err := walletService.VoidHold(ctx, hold.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, walletID, "55", "55")
+
+	report, err := walletService.CheckLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, report.OK())
+}
+
+func TestHoldsExpire(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	walletID := newFundedWallet(t, 1, "30.00")
+
+	hold, err := walletService.PlaceHold(ctx, walletID, "20.00", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, walletID, "30", "10")
+	time.Sleep(100 * time.Millisecond)
+
+	//an expired hold can not be captured even before the sweep ran
+	err = walletService.CaptureHold(ctx, hold.ID, "20.00", 0)
+	assert.True(t, errors.Is(err, services.ErrHoldNotActive))
+
+	released, err := walletService.ExpireHolds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.GreaterOrEqual(t, released, 1)
+	assertBalance(t, walletID, "30", "30")
+
+	expired, err := walletService.GetHold(ctx, hold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.HoldExpired, expired.Status)
+
+	//the sweep's audit event records what it released
+	events, err := walletService.AuditEvents(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, services.AuditHoldsExpire, events[0].Operation)
+		var balances []services.BalanceChange
+		if err := json.Unmarshal(events[0].Balances, &balances); err != nil {
+			t.Fatal(err)
+		}
+		var change *services.BalanceChange
+		for i := range balances {
+			if balances[i].WalletID == walletID {
+				change = &balances[i]
+			}
+		}
+		if assert.NotNil(t, change) {
+			assert.True(t, change.HeldBefore.Equal(decimal.NewFromInt(20)))
+			assert.True(t, change.HeldAfter.IsZero())
+		}
+	}
+}
+
+func TestPlaceHoldIdempotencyKey(t *testing.T) {
+	setup()
+	walletID := newFundedWallet(t, 1, "50.00")
+
+	router := gin.Default()
+	router.POST("/wallets/:wallet_id/holds", walletHandler.PlaceHold)
+
+	key := fmt.Sprintf("hold-%d", time.Now().UnixNano())
+	send := func(amount string) *httptest.ResponseRecorder {
+		bodyJSON, _ := json.Marshal(map[string]string{"amount": amount})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/wallets/%d/holds", walletID), bytes.NewReader(bodyJSON))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := send("20.00")
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	//the retry gets the same hold back instead of holding the money twice
+	retry := send("20.00")
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assertBalance(t, walletID, "50", "30")
+
+	reused := send("25.00")
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+}
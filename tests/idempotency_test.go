@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"handles"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepositIdempotencyKey(t *testing.T) {
+	setup()
+	userID := 1
+
+	var initialBalance decimal.Decimal
+	err := walletService.DB.QueryRow("SELECT balance FROM wallets WHERE user_id = $1", userID).Scan(&initialBalance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.Default()
+	router.POST("/wallet/:user_id/deposit", walletHandler.Deposit)
+
+	key := fmt.Sprintf("deposit-%d", time.Now().UnixNano())
+	send := func(amount string) *httptest.ResponseRecorder {
+		bodyJSON, _ := json.Marshal(map[string]string{"amount": amount})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/wallet/%d/deposit", userID), bytes.NewReader(bodyJSON))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := send("25.00")
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	//the retry gets the original response without moving money again
+	retry := send("25.00")
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+
+	//reusing the key for another request is rejected
+	reused := send("30.00")
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+
+	var balance decimal.Decimal
+	err = walletService.DB.QueryRow("SELECT balance FROM wallets WHERE user_id = $1", userID).Scan(&balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, initialBalance.Add(decimal.NewFromInt(25)).Equal(balance))
+}
+
+func TestIdempotencyKeysArePerCaller(t *testing.T) {
+	setup()
+	walletID := newFundedWallet(t, 1, "0.01")
+
+	auth := &handles.Authenticator{
+		APIKeys: fakeAPIKeys{"wk_admin": {ID: 7, UserID: 9}, "wk_finance": {ID: 8, UserID: 8}},
+		Owners:  fakeOwners{},
+		Roles:   fakeRoles{9: {services.RoleAdmin}, 8: {services.RoleAdmin}},
+	}
+	router := handles.NewRouter(auth, nil, walletHandler)
+
+	key := fmt.Sprintf("shared-%d", time.Now().UnixNano())
+	send := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/wallets/%d/deposit", handles.APIPrefix, walletID), strings.NewReader(`{"amount": "5.00"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(handles.APIKeyHeader, apiKey)
+		req.Header.Set(handles.IdempotencyHeader, key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//the same key and body from two callers are two deposits, not a replay
+	first := send("wk_admin")
+	assert.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	second := send("wk_finance")
+	assert.Equal(t, http.StatusOK, second.Code, second.Body.String())
+	assert.Empty(t, second.Header().Get("Idempotent-Replayed"))
+
+	retry := send("wk_admin")
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assertBalance(t, walletID, "10.01", "10.01")
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerPostingsBalance(t *testing.T) {
+	setup()
+
+	if err := walletService.Deposit(context.Background(), 1, "120.00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.Withdraw(context.Background(), 1, "20.00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.Transfer(context.Background(), 1, 2, "30.00"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the transfer is booked as a debit on the sender and a credit on the receiver
+	var txID int64
+	err := walletService.DB.QueryRow("SELECT id FROM transactions WHERE user_id = 1 AND type = 'transfer' ORDER BY id DESC LIMIT 1").Scan(&txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := walletService.DB.Query(`
+		SELECT w.user_id, p.amount FROM postings p
+		JOIN accounts a ON a.id = p.account_id
+		JOIN wallets w ON w.id = a.wallet_id
+		WHERE p.transaction_id = $1`, txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	legs := map[int]decimal.Decimal{}
+	for rows.Next() {
+		var userID int
+		var amount decimal.Decimal
+		if err := rows.Scan(&userID, &amount); err != nil {
+			t.Fatal(err)
+		}
+		legs[userID] = amount
+	}
+	assert.True(t, decimal.NewFromInt(-30).Equal(legs[1]))
+	assert.True(t, decimal.NewFromInt(30).Equal(legs[2]))
+
+	//no journal may be unbalanced and the whole ledger sums to zero
+	report, err := walletService.CheckLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for currency, total := range report.Totals {
+		assert.True(t, total.IsZero(), "%s postings sum to %s", currency, total)
+	}
+	assert.Empty(t, report.UnbalancedTransactions)
+	//every cached balance agrees with the postings of its wallet
+	assert.Empty(t, report.MismatchedWallets)
+}
+
+// setBalance brings the default wallet of userID to amount with a deposit or
+// a withdrawal, writing wallets.balance directly would leave the cached
+// balance disagreeing with the ledger
+func setBalance(t *testing.T, userID int, amount decimal.Decimal) {
+	t.Helper()
+	ctx := context.Background()
+	balance, err := walletService.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := amount.Sub(balance.Total)
+	switch diff.Sign() {
+	case 1:
+		err = walletService.Deposit(ctx, userID, diff.String())
+	case -1:
+		err = walletService.Withdraw(ctx, userID, diff.Neg().String())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"services"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func decimalPtr(s string) *decimal.Decimal {
+	d := decimal.RequireFromString(s)
+	return &d
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestLimitExceededErrorMatchesSentinel(t *testing.T) {
+	var err error = &services.LimitExceededError{WalletID: 7, Limit: services.LimitDailyAmount, Remaining: decimal.RequireFromString("12.5"), Currency: "USD"}
+	assert.True(t, errors.Is(err, services.ErrLimitExceeded))
+	assert.Contains(t, err.Error(), "12.50 USD remaining")
+
+	var limitErr *services.LimitExceededError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, services.LimitDailyAmount, limitErr.Limit)
+}
+
+func TestWalletLimitsOverrideTierLimits(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	walletID := newFundedWallet(t, 1, "1000.00")
+	otherID := newFundedWallet(t, 2, "0.01")
+
+	tier := "limits-test"
+	err := walletService.SetTierLimits(ctx, tier, "USD", services.Limits{MaxPerTransaction: decimalPtr("100"), DailyAmount: decimalPtr("150")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := walletService.SetWalletTier(ctx, walletID, tier); err != nil {
+		t.Fatal(err)
+	}
+
+	err = walletService.WithdrawFromWallet(ctx, walletID, "100.01")
+	var limitErr *services.LimitExceededError
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, services.LimitPerTransaction, limitErr.Limit)
+		assert.True(t, limitErr.Remaining.Equal(decimal.NewFromInt(100)))
+	}
+
+	assert.NoError(t, walletService.WithdrawFromWallet(ctx, walletID, "100.00"))
+	err = walletService.TransferBetweenWallets(ctx, walletID, otherID, "60.00")
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, services.LimitDailyAmount, limitErr.Limit)
+		assert.True(t, limitErr.Remaining.Equal(decimal.NewFromInt(50)))
+	}
+	assert.NoError(t, walletService.TransferBetweenWallets(ctx, walletID, otherID, "50.00"))
+
+	//limits of the wallet itself replace the tier ones as a whole
+	err = walletService.SetWalletLimits(ctx, walletID, services.Limits{DailyCount: intPtr(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, walletService.WithdrawFromWallet(ctx, walletID, "200.00"))
+	err = walletService.WithdrawFromWallet(ctx, walletID, "1.00")
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, services.LimitDailyCount, limitErr.Limit)
+	}
+
+	limits, err := walletService.GetWalletLimits(ctx, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.LimitSourceWallet, limits.Source)
+	assert.Equal(t, 3, limits.Used.DailyCount)
+	assert.True(t, limits.Used.DailyAmount.Equal(decimal.NewFromInt(350)))
+
+	assert.NoError(t, walletService.ClearWalletLimits(ctx, walletID))
+	limits, err = walletService.GetWalletLimits(ctx, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.LimitSourceTier, limits.Source)
+
+	//money coming in is not limited
+	assert.NoError(t, walletService.DepositToWallet(ctx, walletID, "500.00"))
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"migrations"
+	"services"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsAreComplete(t *testing.T) {
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range all {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, strings.TrimSpace(m.Up), "migration %d has an empty up script", m.Version)
+		assert.NotEmpty(t, strings.TrimSpace(m.Down), "migration %d has an empty down script", m.Version)
+	}
+
+	//services must be written against the newest embedded migration
+	latest, err := migrations.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.SchemaVersion, latest)
+}
+
+func TestMigrationsApplied(t *testing.T) {
+	setup()
+	db := walletService.DB
+
+	latest, _ := migrations.Latest()
+	version, err := migrations.CurrentVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, latest, version)
+	assert.NoError(t, migrations.Verify(db, services.SchemaVersion))
+
+	//nothing is pending after setup ran the migrations
+	applied, err := migrations.Up(db)
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	statuses, err := migrations.Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range statuses {
+		assert.NotNil(t, st.AppliedAt, "migration %d is not applied", st.Version)
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"handles"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &handles.MemoryStore{Now: func() time.Time { return now }}
+	limit := handles.Limit{Requests: 2, Per: time.Second, Burst: 3}
+	ctx := context.Background()
+
+	//a new bucket starts full
+	for i := 0; i < 3; i++ {
+		ok, _, err := store.Take(ctx, "k", limit)
+		assert.NoError(t, err)
+		assert.True(t, ok, "request %d", i)
+	}
+	ok, retryAfter, err := store.Take(ctx, "k", limit)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	//other keys have buckets of their own
+	ok, _, _ = store.Take(ctx, "other", limit)
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _, _ = store.Take(ctx, "k", limit)
+	assert.True(t, ok)
+	ok, _, _ = store.Take(ctx, "k", limit)
+	assert.False(t, ok)
+
+	//refilling stops at the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _, _ = store.Take(ctx, "k", limit)
+		assert.True(t, ok)
+	}
+	ok, _, _ = store.Take(ctx, "k", limit)
+	assert.False(t, ok)
+}
+
+func TestRouteGroup(t *testing.T) {
+	assert.Equal(t, handles.RateGroupTransfers, handles.RouteGroup(http.MethodPost, handles.APIPrefix+"/wallets/:wallet_id/transfer"))
+	assert.Equal(t, handles.RateGroupTransfers, handles.RouteGroup(http.MethodPost, handles.APIPrefix+"/wallet/:user_id/deposit"))
+	assert.Equal(t, handles.RateGroupTransfers, handles.RouteGroup(http.MethodPost, handles.APIPrefix+"/holds/:hold_id/capture"))
+	assert.Equal(t, handles.RateGroupWrites, handles.RouteGroup(http.MethodPost, handles.APIPrefix+"/wallets"))
+	assert.Equal(t, handles.RateGroupWrites, handles.RouteGroup(http.MethodPatch, handles.APIPrefix+"/schedules/:schedule_id"))
+	assert.Equal(t, handles.RateGroupReads, handles.RouteGroup(http.MethodGet, handles.APIPrefix+"/wallets/:wallet_id/balance"))
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit handles.Limit) (bool, time.Duration, error) {
+	return false, 0, errors.New("store unavailable")
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := &handles.RateLimiter{
+		Store: &handles.MemoryStore{Now: func() time.Time { return now }},
+		Groups: map[string]handles.RouteLimits{
+			handles.RateGroupTransfers: {
+				Principal: handles.Limit{Requests: 1, Per: time.Second, Burst: 3},
+				Wallet:    handles.Limit{Requests: 1, Per: 2 * time.Second, Burst: 2},
+			},
+		},
+	}
+	auth := &handles.Authenticator{
+		APIKeys: fakeAPIKeys{"key-1": {ID: 1, UserID: 1}, "key-2": {ID: 2, UserID: 1}},
+		Owners:  fakeOwners{},
+		Roles:   fakeRoles{},
+	}
+	router := handles.NewRouter(auth, limiter, withdrawRoute{})
+	key := func(secret string) http.Header {
+		header := http.Header{}
+		header.Set(handles.APIKeyHeader, secret)
+		return header
+	}
+
+	//two withdrawals empty the bucket of wallet 12, but not that of the key
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", key("key-1")))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", key("key-1")))
+
+	req := httptest.NewRequest(http.MethodPost, handles.APIPrefix+"/wallets/12/withdraw", nil)
+	req.Header.Set(handles.APIKeyHeader, "key-2")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), handles.CodeRateLimited)
+	assert.Contains(t, rr.Body.String(), `"limit":"wallet"`)
+
+	//another wallet passes until key-1 runs out
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/13/withdraw", key("key-1")))
+	assert.Equal(t, http.StatusTooManyRequests, authStatus(router, http.MethodPost, "/wallets/14/withdraw", key("key-1")))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/14/withdraw", key("key-2")))
+
+	//reads are in a group without limits
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodGet, "/wallets/12/balance", key("key-1")))
+
+	//requests that do not authenticate use up no principal bucket
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusUnauthorized, authStatus(router, http.MethodPost, "/wallets/15/withdraw", key("wrong")))
+	}
+
+	//the aliases of user 1 have a wallet bucket keyed by the user, nothing
+	//is looked up to charge it
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallet/1/withdraw", key("key-2")))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallet/1/withdraw", key("key-2")))
+	assert.Equal(t, http.StatusTooManyRequests, authStatus(router, http.MethodPost, "/wallet/1/withdraw", key("key-2")))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", key("key-1")))
+
+	//an unavailable store lets requests through
+	limiter.Store = failingStore{}
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", key("key-1")))
+}
+
+func TestRateLimiterIPBeforeAuthentication(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := &handles.RateLimiter{
+		Store: &handles.MemoryStore{Now: func() time.Time { return now }},
+		Groups: map[string]handles.RouteLimits{
+			handles.RateGroupTransfers: {IP: handles.Limit{Requests: 1, Per: time.Minute, Burst: 2}},
+		},
+	}
+	auth := &handles.Authenticator{
+		APIKeys: fakeAPIKeys{"key-1": {ID: 1, UserID: 1}},
+		Owners:  fakeOwners{},
+		Roles:   fakeRoles{},
+	}
+	router := handles.NewRouter(auth, limiter, withdrawRoute{})
+	header := http.Header{}
+	header.Set(handles.APIKeyHeader, "wrong")
+
+	//the ip bucket is charged before the credentials are looked at
+	assert.Equal(t, http.StatusUnauthorized, authStatus(router, http.MethodPost, "/wallets/12/withdraw", header))
+	assert.Equal(t, http.StatusUnauthorized, authStatus(router, http.MethodPost, "/wallets/12/withdraw", header))
+	header.Set(handles.APIKeyHeader, "key-1")
+	assert.Equal(t, http.StatusTooManyRequests, authStatus(router, http.MethodPost, "/wallets/12/withdraw", header))
+}
+
+func TestRateLimiterIgnoresSpoofedForwardedFor(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := &handles.RateLimiter{
+		Store: &handles.MemoryStore{Now: func() time.Time { return now }},
+		Groups: map[string]handles.RouteLimits{
+			handles.RateGroupTransfers: {IP: handles.Limit{Requests: 1, Per: time.Minute, Burst: 2}},
+		},
+	}
+	auth := &handles.Authenticator{
+		APIKeys: fakeAPIKeys{"key-1": {ID: 1, UserID: 1}},
+		Owners:  fakeOwners{},
+		Roles:   fakeRoles{},
+	}
+	router := handles.NewRouter(auth, limiter, withdrawRoute{})
+	forwardedFor := func(ip string) http.Header {
+		header := http.Header{}
+		header.Set(handles.APIKeyHeader, "key-1")
+		header.Set("X-Forwarded-For", ip)
+		return header
+	}
+
+	//every request claims another client, they all come from the same address
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", forwardedFor("203.0.113.1")))
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", forwardedFor("203.0.113.2")))
+	assert.Equal(t, http.StatusTooManyRequests, authStatus(router, http.MethodPost, "/wallets/12/withdraw", forwardedFor("203.0.113.3")))
+
+	//behind a trusted proxy the forwarded client is the one limited
+	if err := router.SetTrustedProxies([]string{"192.0.2.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, authStatus(router, http.MethodPost, "/wallets/12/withdraw", forwardedFor("203.0.113.4")))
+}
+
+// withdrawRoute registers stand-ins for transfer routes and a read route
+type withdrawRoute struct{}
+
+func (withdrawRoute) RegisterRoutes(rg *gin.RouterGroup) {
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	rg.POST("/wallets/:wallet_id/withdraw", handles.RequirePermission(services.PermWalletsWrite), ok)
+	rg.POST("/wallet/:user_id/withdraw", handles.RequirePermission(services.PermWalletsWrite), ok)
+	rg.GET("/wallets/:wallet_id/balance", handles.RequirePermission(services.PermWalletsRead), ok)
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// lastTransactionID returns the id of the newest entry in the history of walletID
+func lastTransactionID(t *testing.T, walletID int) int {
+	t.Helper()
+	page, err := walletService.GetWalletTransactionHistory(context.Background(), walletID, services.HistoryFilter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Transactions) == 0 {
+		t.Fatal("empty history")
+	}
+	return page.Transactions[0].ID
+}
+
+func TestRefundAndReverseTransfer(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	senderID := newFundedWallet(t, 1, "100.00")
+	receiverID := newFundedWallet(t, 2, "0.01")
+
+	if err := walletService.TransferBetweenWallets(ctx, senderID, receiverID, "30.00"); err != nil {
+		t.Fatal(err)
+	}
+	transferID := lastTransactionID(t, senderID)
+
+	_, err := walletService.Refund(ctx, transferID, "10.00", "")
+	assert.True(t, errors.Is(err, services.ErrInvalidReason))
+
+	//a partial refund first, then the rest in full
+	refundID, err := walletService.Refund(ctx, transferID, "10.00", "wrong amount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, senderID, "80", "80")
+	assertBalance(t, receiverID, "20.01", "20.01")
+
+	_, err = walletService.Refund(ctx, transferID, "20.01", "too much")
+	assert.True(t, errors.Is(err, services.ErrInvalidAmount))
+
+	reverseID, err := walletService.Reverse(ctx, transferID, "sent to the wrong wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, senderID, "100", "100")
+	assertBalance(t, receiverID, "0.01", "0.01")
+
+	_, err = walletService.Reverse(ctx, transferID, "again")
+	assert.True(t, errors.Is(err, services.ErrAlreadyReversed))
+	_, err = walletService.Reverse(ctx, int(reverseID), "undo the undo")
+	assert.True(t, errors.Is(err, services.ErrNotReversible))
+
+	//the history links both ways
+	page, err := walletService.GetWalletTransactionHistory(ctx, receiverID, services.HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range page.Transactions {
+		switch tx.ID {
+		case transferID:
+			assert.Equal(t, []int64{refundID, reverseID}, tx.ReversedBy)
+		case int(reverseID):
+			assert.Equal(t, transferID, tx.ReversesID)
+			assert.Equal(t, "sent to the wrong wallet", tx.Reason)
+			assert.Equal(t, services.DirectionDebit, tx.Direction)
+		}
+	}
+
+	report, err := walletService.CheckLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, report.OK())
+}
+
+func TestReverseSpentDeposit(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	walletID := newFundedWallet(t, 1, "50.00")
+	depositID := lastTransactionID(t, walletID)
+
+	if err := walletService.WithdrawFromWallet(ctx, walletID, "40.00"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the deposit was mostly spent, taking all of it back would overdraw the wallet
+	_, err := walletService.Reverse(ctx, depositID, "chargeback")
+	assert.True(t, errors.Is(err, services.ErrInsufficientFunds))
+
+	_, err = walletService.Refund(ctx, depositID, "10.00", "partial chargeback")
+	assert.NoError(t, err)
+	assertBalance(t, walletID, "0", "0")
+}
+
+func TestReverseTransactionResponse(t *testing.T) {
+	setup()
+	walletID := newFundedWallet(t, 1, "30.00")
+	depositID := lastTransactionID(t, walletID)
+
+	router := gin.Default()
+	router.POST("/transactions/:transaction_id/reverse", walletHandler.ReverseTransaction)
+
+	key := fmt.Sprintf("reverse-%d", time.Now().UnixNano())
+	send := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/transactions/%d/reverse", depositID), strings.NewReader(`{"reason": "duplicate", "amount": "5.00"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		TransactionID int64 `json:"transaction_id"`
+		ReversesID    int   `json:"reverses_id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, depositID, response.ReversesID)
+	assert.Equal(t, int64(lastTransactionID(t, walletID)), response.TransactionID)
+
+	//a retry names the same reversal
+	retry := send()
+	assert.Equal(t, rr.Body.String(), retry.Body.String())
+	assertBalance(t, walletID, "25", "25")
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"handles"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterRegistersAllRoutes(t *testing.T) {
+	//building the router must not panic on conflicting wildcards
+	router := handles.NewRouter(&handles.Authenticator{}, nil, handles.NewWalletHandler(nil), handles.NewUserHandler(nil))
+
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	expected := []string{
+		http.MethodPost + " /api/v1/wallets",
+		http.MethodPost + " /api/v1/wallet/:user_id/deposit",
+		http.MethodPost + " /api/v1/wallet/:user_id/withdraw",
+		http.MethodPost + " /api/v1/wallet/:user_id/transfer",
+		http.MethodGet + " /api/v1/wallet/:user_id/balance",
+		http.MethodGet + " /api/v1/wallet/:user_id/transactions",
+		http.MethodGet + " /api/v1/users/:id/wallets",
+		http.MethodPost + " /api/v1/wallets/:wallet_id/deposit",
+		http.MethodPost + " /api/v1/wallets/:wallet_id/withdraw",
+		http.MethodPost + " /api/v1/wallets/:wallet_id/transfer",
+		http.MethodPost + " /api/v1/wallets/:wallet_id/transfers/batch",
+		http.MethodPost + " /api/v1/wallets/:wallet_id/convert",
+		http.MethodPost + " /api/v1/fx/quotes",
+		http.MethodPost + " /api/v1/wallets/:wallet_id/holds",
+		http.MethodGet + " /api/v1/holds/:hold_id",
+		http.MethodPost + " /api/v1/holds/:hold_id/capture",
+		http.MethodPost + " /api/v1/holds/:hold_id/void",
+		http.MethodPost + " /api/v1/transactions/:transaction_id/reverse",
+		http.MethodPost + " /api/v1/admin/wallets/:wallet_id/freeze",
+		http.MethodPost + " /api/v1/admin/wallets/:wallet_id/unfreeze",
+		http.MethodPost + " /api/v1/admin/wallets/:wallet_id/close",
+		http.MethodGet + " /api/v1/admin/wallets/:wallet_id/status-events",
+		http.MethodPut + " /api/v1/admin/wallets/:wallet_id/limits",
+		http.MethodDelete + " /api/v1/admin/wallets/:wallet_id/limits",
+		http.MethodPut + " /api/v1/admin/wallets/:wallet_id/tier",
+		http.MethodPut + " /api/v1/admin/tiers/:tier/limits/:currency",
+		http.MethodGet + " /api/v1/wallets/:wallet_id/limits",
+		http.MethodGet + " /api/v1/wallets/:wallet_id/fee",
+		http.MethodPost + " /api/v1/wallets/:wallet_id/schedules",
+		http.MethodGet + " /api/v1/wallets/:wallet_id/schedules",
+		http.MethodGet + " /api/v1/schedules/:schedule_id",
+		http.MethodPatch + " /api/v1/schedules/:schedule_id",
+		http.MethodDelete + " /api/v1/schedules/:schedule_id",
+		http.MethodGet + " /api/v1/schedules/:schedule_id/runs",
+		http.MethodGet + " /api/v1/wallets/:wallet_id/balance",
+		http.MethodGet + " /api/v1/wallets/:wallet_id/transactions",
+		http.MethodPost + " /api/v1/users",
+		http.MethodGet + " /api/v1/users",
+		http.MethodGet + " /api/v1/users/:id",
+		http.MethodPatch + " /api/v1/users/:id",
+		http.MethodGet + " /api/v1/users/:id/api-keys",
+		http.MethodGet + " /api/v1/users/:id/roles",
+		http.MethodPut + " /api/v1/admin/users/:id/roles/:role",
+		http.MethodDelete + " /api/v1/admin/users/:id/roles/:role",
+		http.MethodPost + " /api/v1/admin/api-keys",
+		http.MethodDelete + " /api/v1/admin/api-keys/:key_id",
+	}
+	for _, route := range expected {
+		assert.True(t, registered[route], "missing route %s", route)
+	}
+}
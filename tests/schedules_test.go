@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runDue makes every run due at at, one pass only advances a schedule by one run
+func runDue(t *testing.T, at time.Time) {
+	t.Helper()
+	for {
+		n, err := walletService.RunDueSchedules(context.Background(), at, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	//these are refused before the db is looked at
+	service := &services.WalletService{}
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(-time.Hour)
+	for _, req := range []services.NewSchedule{
+		{FromWalletID: 1, ToWalletID: 2, Amount: "10", Recurrence: "hourly"},
+		{FromWalletID: 1, ToWalletID: 2, Amount: "10", Recurrence: "* * *"},
+		{FromWalletID: 1, ToWalletID: 2, Amount: "10", Recurrence: "60 * * * *"},
+		{FromWalletID: 1, ToWalletID: 2, Amount: "10", Recurrence: "*/0 * * * *"},
+		{FromWalletID: 1, ToWalletID: 2, Amount: "10", Recurrence: "0 0 31 2 *"},
+		{FromWalletID: 1, ToWalletID: 2, Amount: "10", Recurrence: "daily", StartAt: start, EndAt: &end},
+	} {
+		_, err := service.CreateSchedule(context.Background(), req)
+		assert.True(t, errors.Is(err, services.ErrInvalidSchedule), "%q: %v", req.Recurrence, err)
+	}
+
+	_, err := service.CreateSchedule(context.Background(), services.NewSchedule{FromWalletID: 1, ToWalletID: 1, Amount: "10"})
+	assert.True(t, errors.Is(err, services.ErrSameWallet))
+	_, err = service.CreateSchedule(context.Background(), services.NewSchedule{FromWalletID: 1, ToWalletID: 2, Amount: "-10"})
+	assert.True(t, errors.Is(err, services.ErrInvalidAmount))
+}
+
+func TestRecurringScheduleRuns(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	senderID := newFundedWallet(t, 1, "100.00")
+	receiverID := newFundedWallet(t, 2, "0.01")
+
+	schedule, err := walletService.CreateSchedule(ctx, services.NewSchedule{
+		FromWalletID: senderID, ToWalletID: receiverID, Amount: "30.00", Recurrence: services.RecurDaily,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer walletService.CancelSchedule(ctx, schedule.ID)
+	first := *schedule.NextRunAt
+
+	runDue(t, first)
+	assertBalance(t, senderID, "70", "70")
+	assertBalance(t, receiverID, "30.01", "30.01")
+	schedule, err = walletService.GetSchedule(ctx, schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.ScheduleActive, schedule.Status)
+	assert.True(t, schedule.NextRunAt.Equal(first.AddDate(0, 0, 1)), "next run %s", schedule.NextRunAt)
+
+	//a second pass at the same time has nothing to do
+	runDue(t, first)
+	assertBalance(t, senderID, "70", "70")
+
+	//paused schedules do not run, a resumed one continues from now
+	paused := services.SchedulePaused
+	if _, err := walletService.UpdateSchedule(ctx, schedule.ID, services.ScheduleUpdate{Status: &paused}); err != nil {
+		t.Fatal(err)
+	}
+	runDue(t, first.AddDate(0, 0, 1))
+	assertBalance(t, senderID, "70", "70")
+	active := services.ScheduleActive
+	if _, err := walletService.UpdateSchedule(ctx, schedule.ID, services.ScheduleUpdate{Status: &active}); err != nil {
+		t.Fatal(err)
+	}
+	runDue(t, first.AddDate(0, 0, 1))
+	assertBalance(t, senderID, "40", "40")
+
+	runs, err := walletService.ScheduleRuns(ctx, schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, runs, 2) {
+		assert.Equal(t, services.RunSucceeded, runs[0].Status)
+		assert.NotZero(t, runs[0].TransactionID)
+	}
+
+	cancelled, err := walletService.CancelSchedule(ctx, schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.ScheduleCancelled, cancelled.Status)
+	assert.Nil(t, cancelled.NextRunAt)
+	_, err = walletService.UpdateSchedule(ctx, schedule.ID, services.ScheduleUpdate{Status: &active})
+	assert.True(t, errors.Is(err, services.ErrScheduleNotActive))
+}
+
+func TestFailedScheduleRunIsRecorded(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	senderID := newFundedWallet(t, 1, "10.00")
+	receiverID := newFundedWallet(t, 2, "0.01")
+
+	schedule, err := walletService.CreateSchedule(ctx, services.NewSchedule{
+		FromWalletID: senderID, ToWalletID: receiverID, Amount: "25.00", Recurrence: services.RecurOnce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runDue(t, *schedule.NextRunAt)
+	assertBalance(t, senderID, "10", "10")
+
+	runs, err := walletService.ScheduleRuns(ctx, schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, services.RunFailed, runs[0].Status)
+		assert.Contains(t, runs[0].Error, services.ErrInsufficientFunds.Error())
+	}
+	schedule, err = walletService.GetSchedule(ctx, schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.ScheduleCompleted, schedule.Status)
+}
+
+func TestScheduleRunKeyCanNotBeClaimedByClients(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	senderID := newFundedWallet(t, 1, "50.00")
+	receiverID := newFundedWallet(t, 2, "0.01")
+	attackerID := newFundedWallet(t, 3, "0.01")
+
+	schedule, err := walletService.CreateSchedule(ctx, services.NewSchedule{
+		FromWalletID: senderID, ToWalletID: receiverID, Amount: "20.00", Recurrence: services.RecurOnce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a client sending the key the run will use takes nothing from it
+	key := fmt.Sprintf("schedule:%d:%d", schedule.ID, schedule.NextRunAt.UnixNano())
+	claimed := services.WithIdempotencyKey(ctx, &services.IdempotencyKey{Key: key, Fingerprint: strings.Repeat("0", 64), Status: 200, Body: []byte("{}")})
+	assert.NoError(t, walletService.DepositToWallet(claimed, attackerID, "1.00"))
+
+	runDue(t, *schedule.NextRunAt)
+	assertBalance(t, senderID, "30", "30")
+	assertBalance(t, receiverID, "20.01", "20.01")
+	runs, err := walletService.ScheduleRuns(ctx, schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, services.RunSucceeded, runs[0].Status)
+	}
+}
+
+func TestScheduleRunTimes(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	senderID := newFundedWallet(t, 1, "100.00")
+	receiverID := newFundedWallet(t, 2, "0.01")
+
+	//weekdays at 09:30, the first after a saturday is the monday
+	weekdays, err := walletService.CreateSchedule(ctx, services.NewSchedule{
+		FromWalletID: senderID, ToWalletID: receiverID, Amount: "1.00", Recurrence: "30 9 * * 1-5",
+		StartAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer walletService.CancelSchedule(ctx, weekdays.ID)
+	assert.True(t, weekdays.NextRunAt.Equal(time.Date(2024, 6, 3, 9, 30, 0, 0, time.UTC)), "next run %s", weekdays.NextRunAt)
+
+	//monthly from the 31st falls on the last day of shorter months
+	monthly, err := walletService.CreateSchedule(ctx, services.NewSchedule{
+		FromWalletID: senderID, ToWalletID: receiverID, Amount: "1.00", Recurrence: services.RecurMonthly,
+		StartAt: time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer walletService.CancelSchedule(ctx, monthly.ID)
+	runDue(t, time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC))
+	monthly, err = walletService.GetSchedule(ctx, monthly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, monthly.NextRunAt.Equal(time.Date(2024, 2, 29, 8, 0, 0, 0, time.UTC)), "next run %s", monthly.NextRunAt)
+	assertBalance(t, senderID, "99", "99")
+}
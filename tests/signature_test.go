@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"handles"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"services"
+	"signing"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	partnerSecret    = "partner secret of at least 32 bytes"
+	partnerNewSecret = "the partner secret rotated in, 32+"
+	otherSecret      = "the secret of another partner, 32+"
+)
+
+// echoDeposit is a stand-in deposit route answering with the body it got
+type echoDeposit struct{}
+
+func (echoDeposit) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("/wallets/:wallet_id/deposit", handles.RequirePermission(services.PermWalletsWrite), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		keyID, _ := handles.SigningKeyFrom(c)
+		c.String(http.StatusOK, keyID+" "+string(body))
+	})
+}
+
+func TestRequestSignatures(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Hour)
+	auth := &handles.Authenticator{
+		APIKeys: fakeAPIKeys{"wk_partner": {ID: 1, UserID: 1}, "wk_other": {ID: 2, UserID: 2}},
+		Owners:  fakeOwners{},
+		Roles:   fakeRoles{},
+		Signatures: &handles.SignatureVerifier{
+			Keys: []handles.SigningKey{
+				{ID: "partner-old", Secret: partnerSecret, Subject: "api_key:1", ExpiresAt: &expired},
+				{ID: "partner-1", Secret: partnerSecret, Subject: "api_key:1"},
+				{ID: "partner-2", Secret: partnerNewSecret, Subject: "api_key:1"},
+				{ID: "other-1", Secret: otherSecret, Subject: "api_key:2"},
+			},
+			Nonces:   handles.NewMemoryNonceStore(),
+			Skew:     time.Minute,
+			Required: true,
+			Now:      func() time.Time { return now },
+		},
+	}
+	server := httptest.NewServer(handles.NewRouter(auth, nil, echoDeposit{}))
+	defer server.Close()
+	url := server.URL + handles.APIPrefix + "/wallets/12/deposit?note=a"
+
+	request := func(body string) *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set(handles.APIKeyHeader, "wk_partner")
+		return req
+	}
+	send := func(req *http.Request) (int, string) {
+		res, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+	signer := func(keyID, secret string) signing.Signer {
+		return signing.Signer{KeyID: keyID, Secret: []byte(secret), Now: func() time.Time { return now }}
+	}
+
+	//the client helper signs whatever an http.Client sends
+	client := &http.Client{Transport: &signing.Transport{Signer: signer("partner-1", partnerSecret)}}
+	res, err := client.Do(request(`{"amount": "10"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	//the handler still gets the body the signature was checked over
+	assert.Equal(t, `partner-1 {"amount": "10"}`, string(body))
+
+	//both keys work while callers rotate
+	req := request(`{"amount": "10"}`)
+	assert.NoError(t, signer("partner-2", partnerNewSecret).SignRequest(req))
+	status, _ := send(req)
+	assert.Equal(t, http.StatusOK, status)
+
+	//a replay is refused
+	replay := request(`{"amount": "10"}`)
+	replay.Header = req.Header.Clone()
+	status, body2 := send(replay)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Contains(t, body2, handles.CodeInvalidSignature)
+	assert.Contains(t, body2, "nonce")
+
+	//a valid signature of one partner does not vouch for the api key of
+	//another, in either direction
+	stolen, _ := http.NewRequest(http.MethodPost, server.URL+handles.APIPrefix+"/wallets/22/deposit", strings.NewReader(`{"amount": "10"}`))
+	stolen.Header.Set(handles.APIKeyHeader, "wk_other")
+	assert.NoError(t, signer("partner-1", partnerSecret).SignRequest(stolen))
+	status, body2 = send(stolen)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Contains(t, body2, `signing key \"partner-1\" does not belong to the caller`)
+	borrowed := request(`{"amount": "10"}`)
+	assert.NoError(t, signer("other-1", otherSecret).SignRequest(borrowed))
+	status, _ = send(borrowed)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	owned, _ := http.NewRequest(http.MethodPost, server.URL+handles.APIPrefix+"/wallets/22/deposit", strings.NewReader(`{"amount": "10"}`))
+	owned.Header.Set(handles.APIKeyHeader, "wk_other")
+	assert.NoError(t, signer("other-1", otherSecret).SignRequest(owned))
+	status, _ = send(owned)
+	assert.Equal(t, http.StatusOK, status)
+
+	for name, tc := range map[string]struct {
+		signer signing.Signer
+		alter  func(req *http.Request)
+	}{
+		"tampered body": {signer("partner-1", partnerSecret), func(req *http.Request) {
+			req.Body = io.NopCloser(strings.NewReader(`{"amount": "1000"}`))
+			req.ContentLength = -1
+		}},
+		"tampered path":   {signer("partner-1", partnerSecret), func(req *http.Request) { req.URL.RawQuery = "note=b" }},
+		"wrong secret":    {signer("partner-1", partnerNewSecret), func(req *http.Request) {}},
+		"unknown key":     {signer("partner-9", partnerSecret), func(req *http.Request) {}},
+		"expired key":     {signer("partner-old", partnerSecret), func(req *http.Request) {}},
+		"stale":           {signing.Signer{KeyID: "partner-1", Secret: []byte(partnerSecret), Now: func() time.Time { return now.Add(-2 * time.Minute) }}, func(req *http.Request) {}},
+		"from the future": {signing.Signer{KeyID: "partner-1", Secret: []byte(partnerSecret), Now: func() time.Time { return now.Add(2 * time.Minute) }}, func(req *http.Request) {}},
+		"unsigned":        {signing.Signer{}, nil},
+	} {
+		req := request(`{"amount": "10"}`)
+		if tc.alter != nil {
+			assert.NoError(t, tc.signer.SignRequest(req), name)
+			tc.alter(req)
+		}
+		status, body := send(req)
+		assert.Equal(t, http.StatusUnauthorized, status, name)
+		assert.Contains(t, body, handles.CodeInvalidSignature, name)
+	}
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "keys.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	keys, err := handles.LoadSigningKeys(write(`{"keys": [{"id": "a", "secret": "` + partnerSecret + `", "subject": "api_key:1", "expires_at": "2030-01-01T00:00:00Z"}, {"id": "b", "secret": "` + partnerNewSecret + `", "subject": "api_key:1"}]}`))
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, 2030, keys[0].ExpiresAt.Year())
+		assert.Nil(t, keys[1].ExpiresAt)
+		assert.Equal(t, "api_key:1", keys[0].Subject)
+	}
+
+	_, err = handles.LoadSigningKeys(write(`{"keys": [{"id": "a", "secret": "short", "subject": "api_key:1"}]}`))
+	assert.Error(t, err)
+	_, err = handles.LoadSigningKeys(write(`{"keys": [{"id": "a", "secret": "` + partnerSecret + `"}]}`))
+	assert.Error(t, err)
+	_, err = handles.LoadSigningKeys(write(`{"keys": [{"id": "a", "secret": "` + partnerSecret + `", "subject": "api_key:1"}, {"id": "a", "secret": "` + partnerSecret + `", "subject": "api_key:1"}]}`))
+	assert.Error(t, err)
+}
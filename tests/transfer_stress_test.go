@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferBidirectionalNoDeadlock(t *testing.T) {
+	setup()
+	userA := 1
+	userB := 2
+	initialBalance := decimal.NewFromFloat(1000.00)
+
+	for _, userID := range []int{userA, userB} {
+		setBalance(t, userID, initialBalance)
+	}
+
+	router := gin.Default()
+	router.POST("/wallet/:from_user_id/transfer", walletHandler.Transfer)
+
+	//half of the transfers go A->B and the other half B->A at the same time,
+	//with lock ordering and retries every single one must succeed
+	transferAmount := decimal.NewFromFloat(10.00)
+	rounds := 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := []string{}
+
+	for i := 0; i < rounds; i++ {
+		for _, pair := range [][2]int{{userA, userB}, {userB, userA}} {
+			wg.Add(1)
+			go func(from, to int) {
+				defer wg.Done()
+
+				body := map[string]interface{}{
+					"to_user_id": to,
+					"amount":     transferAmount.String(),
+				}
+				bodyJSON, _ := json.Marshal(body)
+				req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/wallet/%d/transfer", from), bytes.NewReader(bodyJSON))
+				req.Header.Set("Content-Type", "application/json")
+
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					mu.Lock()
+					failures = append(failures, rr.Body.String())
+					mu.Unlock()
+				}
+			}(pair[0], pair[1])
+		}
+	}
+	wg.Wait()
+
+	assert.Empty(t, failures)
+
+	//every A->B transfer was matched by a B->A one
+	for _, userID := range []int{userA, userB} {
+		balance, err := walletService.GetBalance(context.Background(), userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, initialBalance.Equal(balance.Total), "user %d ended with %s", userID, balance.Total)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"handles"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func userRouter() *gin.Engine {
+	handler := handles.NewUserHandler(&services.UserService{DB: walletService.DB})
+	router := gin.Default()
+	handler.RegisterRoutes(&router.RouterGroup)
+	return router
+}
+
+func TestCreateUserProvisionsWallet(t *testing.T) {
+	setup()
+	router := userRouter()
+
+	bodyJSON, _ := json.Marshal(map[string]string{"name": "carol"})
+	req, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewReader(bodyJSON))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created struct {
+		User     services.User `json:"user"`
+		WalletID int64         `json:"wallet_id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "carol", created.User.Name)
+	assert.NotZero(t, created.WalletID)
+
+	//the wallet belongs to the new user and can take deposits right away
+	var owner int
+	err := walletService.DB.QueryRow("SELECT user_id FROM wallets WHERE id = $1", created.WalletID).Scan(&owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, created.User.ID, owner)
+
+	//rename and read back
+	bodyJSON, _ = json.Marshal(map[string]string{"name": "caroline"})
+	req, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%d", created.User.ID), bytes.NewReader(bodyJSON))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d", created.User.ID), nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "caroline")
+}
+
+func TestGetUnknownUser(t *testing.T) {
+	setup()
+	router := userRouter()
+
+	req, _ := http.NewRequest(http.MethodGet, "/users/999999999", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), handles.CodeUserNotFound)
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferBetweenWalletIDs(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	userID := 1
+
+	//a second wallet for user 1 next to the default one
+	secondID, err := walletService.CreateWallet(ctx, userID, services.DefaultCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultID, err := walletService.DefaultWalletID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, int(secondID), defaultID)
+
+	if err := walletService.DepositToWallet(ctx, defaultID, "40.00"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := walletService.GetWalletBalance(ctx, defaultID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.Default()
+	router.POST("/wallets/:wallet_id/transfer", walletHandler.TransferBetweenWallets)
+	router.GET("/wallets/:wallet_id/balance", walletHandler.GetWalletBalance)
+	router.GET("/users/:id/wallets", walletHandler.ListUserWallets)
+
+	body := map[string]interface{}{
+		"to_wallet_id": secondID,
+		"amount":       "15.00",
+	}
+	bodyJSON, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/wallets/%d/transfer", defaultID), bytes.NewReader(bodyJSON))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/wallets/%d/balance", secondID), nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), decimal.NewFromFloat(15.00).String())
+
+	after, err := walletService.GetWalletBalance(ctx, defaultID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, before.Total.Sub(decimal.NewFromInt(15)).Equal(after.Total))
+
+	//both wallets are listed, the default one first
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d/wallets", userID), nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var listed struct {
+		Wallets []services.Wallet `json:"wallets"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	assert.GreaterOrEqual(t, len(listed.Wallets), 2)
+	assert.Equal(t, defaultID, listed.Wallets[0].ID)
+	assert.True(t, listed.Wallets[0].IsDefault)
+}
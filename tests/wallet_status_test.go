@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"handles"
+	"net/http"
+	"net/http/httptest"
+	"services"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrozenWalletReceivesButDoesNotSend(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	walletID := newFundedWallet(t, 1, "100.00")
+	otherID := newFundedWallet(t, 2, "100.00")
+
+	_, err := walletService.FreezeWallet(ctx, walletID, "", "aml review")
+	assert.True(t, errors.Is(err, services.ErrInvalidActor))
+
+	wallet, err := walletService.FreezeWallet(ctx, walletID, "compliance@example.com", "aml review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.WalletFrozen, wallet.Status)
+
+	err = walletService.WithdrawFromWallet(ctx, walletID, "10.00")
+	assert.True(t, errors.Is(err, services.ErrWalletFrozen))
+	err = walletService.TransferBetweenWallets(ctx, walletID, otherID, "10.00")
+	assert.True(t, errors.Is(err, services.ErrWalletFrozen))
+	_, err = walletService.PlaceHold(ctx, walletID, "10.00", 0)
+	assert.True(t, errors.Is(err, services.ErrWalletFrozen))
+
+	assert.NoError(t, walletService.DepositToWallet(ctx, walletID, "5.00"))
+	assert.NoError(t, walletService.TransferBetweenWallets(ctx, otherID, walletID, "5.00"))
+	assertBalance(t, walletID, "110", "110")
+
+	_, err = walletService.FreezeWallet(ctx, walletID, "compliance@example.com", "again")
+	assert.True(t, errors.Is(err, services.ErrInvalidStatusChange))
+
+	_, err = walletService.UnfreezeWallet(ctx, walletID, "compliance@example.com", "cleared")
+	assert.NoError(t, err)
+	assert.NoError(t, walletService.WithdrawFromWallet(ctx, walletID, "10.00"))
+
+	events, err := walletService.WalletStatusEvents(ctx, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, services.WalletActive, events[0].FromStatus)
+		assert.Equal(t, services.WalletFrozen, events[0].ToStatus)
+		assert.Equal(t, "compliance@example.com", events[0].Actor)
+		assert.Equal(t, "aml review", events[0].Reason)
+		assert.Equal(t, services.WalletActive, events[1].ToStatus)
+	}
+}
+
+func TestCloseWalletRequiresZeroBalance(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	walletID := newFundedWallet(t, 1, "20.00")
+	otherID := newFundedWallet(t, 2, "20.00")
+
+	_, err := walletService.CloseWallet(ctx, walletID, "support", "customer request")
+	assert.True(t, errors.Is(err, services.ErrWalletNotEmpty))
+
+	if err := walletService.WithdrawFromWallet(ctx, walletID, "20.00"); err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := walletService.CloseWallet(ctx, walletID, "support", "customer request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, services.WalletClosed, wallet.Status)
+
+	//closed wallets neither receive nor send and stay closed
+	err = walletService.DepositToWallet(ctx, walletID, "1.00")
+	assert.True(t, errors.Is(err, services.ErrWalletClosed))
+	err = walletService.TransferBetweenWallets(ctx, otherID, walletID, "1.00")
+	assert.True(t, errors.Is(err, services.ErrWalletClosed))
+	_, err = walletService.UnfreezeWallet(ctx, walletID, "support", "reopen")
+	assert.True(t, errors.Is(err, services.ErrInvalidStatusChange))
+}
+
+func TestWalletStatusActorIsTheCaller(t *testing.T) {
+	setup()
+	ctx := context.Background()
+	walletID := newFundedWallet(t, 1, "10.00")
+
+	auth := &handles.Authenticator{
+		APIKeys: fakeAPIKeys{"wk_compliance": {ID: 7, UserID: 9}},
+		Owners:  fakeOwners{},
+		Roles:   fakeRoles{9: {services.RoleAdmin}},
+	}
+	router := handles.NewRouter(auth, nil, walletHandler)
+
+	//an actor in the body is not taken over, the status history names the caller
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/wallets/%d/freeze", handles.APIPrefix, walletID),
+		strings.NewReader(`{"actor": "someone else", "reason": "aml review"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(handles.APIKeyHeader, "wk_compliance")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	events, err := walletService.WalletStatusEvents(ctx, walletID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "api_key:7", events[0].Actor)
+		assert.Equal(t, "aml review", events[0].Reason)
+	}
+}
@@ -3,9 +3,11 @@ package tests
 import (
 	"bytes"
 	"config"
+	"context"
 	"encoding/json"
 	"fmt"
 	"handles"
+	"migrations"
 	"net/http"
 	"net/http/httptest"
 	"services"
@@ -22,10 +24,17 @@ var walletHandler *handles.WalletHandler
 
 func setup() {
 	// Initialize the wallet service and handler
-	db, err := config.InitDB() // Assuming InitDB initializes the PostgreSQL connection
+	cfg, err := config.Load(nil)
 	if err != nil {
 		panic(err)
 	}
+	db, err := config.InitDB(cfg.DB) // connection settings come from WALLET_* env vars
+	if err != nil {
+		panic(err)
+	}
+	if _, err := migrations.Up(db); err != nil {
+		panic(err)
+	}
 
 	walletService = &services.WalletService{DB: db}
 	walletHandler = &handles.WalletHandler{Service: walletService}
@@ -142,10 +151,7 @@ func TestGetBalance(t *testing.T) {
 	userID := 1
 	//init user with given balance
 	initialDeposit := decimal.NewFromFloat(300.00)
-	_, err := walletService.DB.Exec("UPDATE wallets set balance = $2 where user_id = $1", userID, initialDeposit)
-	if err != nil {
-		t.Fatal(err)
-	}
+	setBalance(t, userID, initialDeposit)
 
 	//  Prepare the request to get the balance
 	userStr := fmt.Sprintf("%d", userID)
@@ -183,10 +189,7 @@ func TestTransfer(t *testing.T) {
 		t.Fatal(err)
 	}
 	//set receiver balance to 0 for later verification
-	_, err = walletService.DB.Exec("UPDATE wallets set balance = $2 where user_id = $1", toUserID, decimal.Zero)
-	if err != nil {
-		t.Fatal(err)
-	}
+	setBalance(t, toUserID, decimal.Zero)
 
 	// Prepare the request to transfer money
 	transferAmount := decimal.NewFromFloat(50.00)
@@ -239,20 +242,17 @@ func TestTransfer(t *testing.T) {
 
 func TestGetTransactionHistory(t *testing.T) {
 	setup()
-	userID := 2
-	//delete all transactions for user with id 2
-	_, err := walletService.DB.Exec("DELETE FROM transactions WHERE user_id = $1", userID)
+	//a new user has no history yet, its balance is booked like any other
+	user, _, err := (&services.UserService{DB: walletService.DB}).CreateUser(context.Background(), "history", services.DefaultCurrency)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	//update user balance to 100.00
-	_, err = walletService.DB.Exec("UPDATE wallets set balance = $2 where user_id = $1", userID, decimal.NewFromFloat(100.00))
-	if err != nil {
+	userID := user.ID
+	if err := walletService.Deposit(context.Background(), userID, "100.00"); err != nil {
 		t.Fatal(err)
 	}
 
-	//make a transfer from user with id 2 to user with id 1
+	//make a transfer from the new user to user with id 1
 	transferAmount := decimal.NewFromFloat(50.00)
 	toUserID := 1
 	body := map[string]interface{}{
@@ -260,8 +260,7 @@ func TestGetTransactionHistory(t *testing.T) {
 		"amount":     transferAmount.String(),
 	}
 	bodyJSON, _ := json.Marshal(body)
-	fromUserID := 2
-	fromUserIDStr := fmt.Sprintf("%d", fromUserID)
+	fromUserIDStr := fmt.Sprintf("%d", userID)
 	req, err := http.NewRequest(http.MethodPost, "/wallet/"+fromUserIDStr+"/transfer", bytes.NewReader(bodyJSON))
 	if err != nil {
 		t.Fatal(err)
@@ -288,19 +287,27 @@ func TestGetTransactionHistory(t *testing.T) {
 	router.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
-	var transactions []services.Transaction
-	err = json.Unmarshal([]byte(rr.Body.String()), &transactions)
+	var page services.HistoryPage
+	err = json.Unmarshal([]byte(rr.Body.String()), &page)
 	if err != nil {
 		t.Fatal(err)
 	}
+	transactions := page.Transactions
+	assert.Empty(t, page.NextCursor)
 
+	//newest first, the transfer and the deposit before it
 	txCount := len(transactions)
-	assert.Equal(t, 1, txCount)
+	if !assert.Equal(t, 2, txCount) {
+		return
+	}
 	assert.Equal(t, "transfer", transactions[0].Type)
+	assert.Equal(t, "deposit", transactions[1].Type)
 	isEqual := transferAmount.Equal(transactions[0].Amount)
 	assert.Equal(t, true, isEqual)
 	assert.Equal(t, toUserID, transactions[0].ToUserID)
 	assert.Equal(t, userID, transactions[0].UserID)
+	assert.Equal(t, services.DirectionDebit, transactions[0].Direction)
+	assert.Equal(t, toUserID, transactions[0].CounterpartyUserID)
 }
 
 func TestTransferInvalidUserID(t *testing.T) {
@@ -398,8 +405,8 @@ func TestWithdrawInsufficientBalance(t *testing.T) {
 	router.POST("/wallet/:user_id/withdraw", walletHandler.Withdraw)
 	router.ServeHTTP(rr, req)
 
-	assert.Equal(t, http.StatusBadRequest, rr.Code)
-	assert.Contains(t, rr.Body.String(), "Insufficient balance")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "insufficient_funds")
 }
 
 func TestTransferInsufficientBalance(t *testing.T) {
@@ -425,8 +432,8 @@ func TestTransferInsufficientBalance(t *testing.T) {
 	router.POST("/wallet/:from_user_id/transfer", walletHandler.Transfer)
 	router.ServeHTTP(rr, req)
 
-	assert.Equal(t, http.StatusBadRequest, rr.Code)
-	assert.Contains(t, rr.Body.String(), "insufficient balance")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "insufficient_funds")
 }
 
 func TestTransferRaceCondition(t *testing.T) {
@@ -434,16 +441,9 @@ func TestTransferRaceCondition(t *testing.T) {
 	fromUserID := 1
 	toUserID := 2
 	//set receiver balance to 0 for later verification
-	_, err := walletService.DB.Exec("UPDATE wallets set balance = $2 where user_id = $1", toUserID, decimal.Zero)
-	if err != nil {
-		t.Fatal(err)
-	}
+	setBalance(t, toUserID, decimal.Zero)
 	//set sender balance to 1000.00 for later verification
-	_, err = walletService.DB.Exec("UPDATE wallets set balance = $2 where user_id = $1", fromUserID,
-		decimal.NewFromFloat(1000.00))
-	if err != nil {
-		t.Fatal(err)
-	}
+	setBalance(t, fromUserID, decimal.NewFromFloat(1000.00))
 
 	transferAmount := decimal.NewFromFloat(50.00)
 	numTransfers := 10